@@ -0,0 +1,184 @@
+package bottest
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// Responder build the data of api response by request params, return nil for null data,
+// and return an error to fail the call with its message
+type Responder func(params zero.Params) any
+
+// FakeDriver is a zero driver without connection, events are injected by Inject,
+// api calls are recorded and answered by responders, send actions get increasing message id by default
+type FakeDriver struct {
+	SelfID int64
+
+	mu         sync.Mutex
+	listener   func([]byte, zero.APICaller)
+	calls      []zero.APIRequest
+	responders map[string]Responder
+	messageID  atomic.Int64
+	notify     chan struct{}
+	listening  chan struct{}
+	listenOnce sync.Once
+}
+
+// NewFakeDriver create a fake driver of the bot account
+func NewFakeDriver(selfID int64) *FakeDriver {
+	return &FakeDriver{SelfID: selfID, responders: map[string]Responder{}, notify: make(chan struct{}, 1), listening: make(chan struct{})}
+}
+
+// Connect register the driver as api caller of the bot account
+func (d *FakeDriver) Connect() {
+	zero.APICallers.Store(d.SelfID, d)
+}
+
+// Listen keep the event handler of zero, events are pushed by Inject instead of reading connection
+func (d *FakeDriver) Listen(handler func([]byte, zero.APICaller)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.listener = handler
+	d.listenOnce.Do(func() { close(d.listening) })
+}
+
+// Inject push a raw OneBot event to zero, it waits until zero listens, and the event is handled asynchronously
+func (d *FakeDriver) Inject(event []byte) {
+	<-d.listening
+	d.mu.Lock()
+	listener := d.listener
+	d.mu.Unlock()
+
+	listener(event, d)
+}
+
+// CallApi record the request and answer it by responder
+func (d *FakeDriver) CallApi(request zero.APIRequest) (zero.APIResponse, error) {
+	d.mu.Lock()
+	d.calls = append(d.calls, request)
+	responder, exist := d.responders[request.Action]
+	d.mu.Unlock()
+
+	// wake up waiting assertions without blocking the handler
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+
+	var data any
+	switch {
+	case exist:
+		data = responder(request.Params)
+	case isSendAction(request.Action):
+		data = map[string]any{"message_id": d.messageID.Add(1)}
+	}
+
+	if failure, failed := data.(error); failed {
+		return zero.APIResponse{Status: "failed", Data: gjson.Parse("null"), Msg: failure.Error(), RetCode: 100}, nil
+	}
+
+	encoded, _ := json.Marshal(data)
+	return zero.APIResponse{Status: "ok", Data: gjson.ParseBytes(encoded), RetCode: 0}, nil
+}
+
+// Respond set the responder of action, it replaces the previous one, nil restores the default response
+func (d *FakeDriver) Respond(action string, responder Responder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if responder == nil {
+		delete(d.responders, action)
+		return
+	}
+	d.responders[action] = responder
+}
+
+// Calls get a copy of recorded api calls
+func (d *FakeDriver) Calls() []zero.APIRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]zero.APIRequest{}, d.calls...)
+}
+
+// Reset drop recorded api calls, responders are kept
+func (d *FakeDriver) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.calls = nil
+}
+
+// Reply is a message sent by bot, parsed from send actions
+type Reply struct {
+	Action  string          `json:"action"`
+	GroupID int64           `json:"group_id,omitempty"`
+	UserID  int64           `json:"user_id,omitempty"`
+	Message message.Message `json:"message"`
+}
+
+// Text get plain text of the reply, other segments are ignored
+func (r Reply) Text() string {
+	text := ""
+	for _, segment := range r.Message {
+		if segment.Type == "text" {
+			text += segment.Data["text"]
+		}
+	}
+
+	return text
+}
+
+// Replies get messages sent by bot from recorded api calls
+func (d *FakeDriver) Replies() (replies []Reply) {
+	for _, call := range d.Calls() {
+		if !isSendAction(call.Action) {
+			continue
+		}
+
+		replies = append(replies, replyOf(call.Action, call.Params))
+	}
+
+	return replies
+}
+
+func replyOf(action string, params zero.Params) Reply {
+	encoded, _ := json.Marshal(params["message"])
+	if parsed := gjson.ParseBytes(encoded); parsed.IsObject() {
+		// a single segment is sent as object
+		encoded = []byte("[" + parsed.Raw + "]")
+	}
+	reply := Reply{Action: action, Message: message.ParseMessage(encoded)}
+	reply.GroupID, _ = toInt64(params["group_id"])
+	reply.UserID, _ = toInt64(params["user_id"])
+
+	return reply
+}
+
+func isSendAction(action string) bool {
+	switch action {
+	case "send_msg", "send_group_msg", "send_private_msg", "send_guild_channel_msg", "send_group_forward_msg", "send_private_forward_msg":
+		return true
+	}
+
+	return false
+}
+
+func toInt64(value any) (int64, bool) {
+	switch number := value.(type) {
+	case int64:
+		return number, true
+	case int:
+		return int64(number), true
+	case float64:
+		return int64(number), true
+	}
+
+	return 0, false
+}
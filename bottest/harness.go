@@ -0,0 +1,260 @@
+package bottest
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FloatTech/zbputils/control"
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/ceobebot-core/driver"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	// DefaultSelfID is the account of bot driven by harness
+	DefaultSelfID int64 = 10000
+
+	// DefaultTimeout is the time assertions wait for replies
+	DefaultTimeout = 2 * time.Second
+)
+
+var (
+	startOnce sync.Once
+	started   *Harness
+	startErr  error
+)
+
+// Harness run the configured bot in process, events are injected by a fake driver and replies are recorded,
+// so plugin authors can check which handler a message triggers and what it replies without a OneBot server
+type Harness struct {
+	*FakeDriver
+	Config *core.Config
+
+	mu        sync.Mutex
+	cursor    int
+	messageID atomic.Int64
+}
+
+// MessageEvent describe a message sent to bot, GroupID 0 means private message, Role is the role of sender
+// in group, such as owner, admin or member
+type MessageEvent struct {
+	GroupID  int64
+	UserID   int64
+	Nickname string
+	Role     string
+	Text     string
+}
+
+// Start initialize core with the config content and bind plugins the same way as InitializeZeroBot,
+// plugins must be registered before starting, zero and registries are global, so the harness is started
+// once per process, usually in TestMain, later calls return the started harness and ignore the content
+func Start(content []byte) (*Harness, error) {
+	startOnce.Do(func() {
+		ctx, coreConfig, pluginConfigMap, initErr := core.InitializeWithConfig(content)
+		if initErr != nil {
+			startErr = initErr
+			return
+		}
+
+		driver.BindZeroBot(ctx, coreConfig, pluginConfigMap)
+
+		// zbp only responds in groups opened by its response command, open all groups for testing
+		if !control.CanResponse(0) {
+			_ = control.Response(0)
+		}
+
+		fake := NewFakeDriver(DefaultSelfID)
+		coreConfig.ZeroConfig.Driver = []zero.Driver{fake}
+		zero.Run(coreConfig.ZeroConfig)
+		started = &Harness{FakeDriver: fake, Config: coreConfig}
+	})
+
+	return started, startErr
+}
+
+// MustStart start the harness and panic on error, it is convenient in TestMain
+func MustStart(content []byte) *Harness {
+	harness, startErr := Start(content)
+	if startErr != nil {
+		panic(startErr)
+	}
+
+	return harness
+}
+
+// Send inject the message event, the message is parsed as CQ code like real OneBot servers do
+func (h *Harness) Send(event MessageEvent) {
+	messageType, subType := "group", "normal"
+	if event.GroupID == 0 {
+		messageType, subType = "private", "friend"
+	}
+	if event.Nickname == "" {
+		event.Nickname = "user"
+	}
+	if event.Role == "" {
+		event.Role = "member"
+	}
+
+	h.Inject(map[string]any{
+		"time":         time.Now().Unix(),
+		"self_id":      h.SelfID,
+		"post_type":    "message",
+		"message_type": messageType,
+		"sub_type":     subType,
+		"message_id":   h.messageID.Add(1),
+		"group_id":     event.GroupID,
+		"user_id":      event.UserID,
+		"message":      event.Text,
+		"raw_message":  event.Text,
+		"font":         0,
+		"sender":       map[string]any{"user_id": event.UserID, "nickname": event.Nickname, "role": event.Role},
+	})
+}
+
+// GroupMessage inject a message sent by user in group
+func (h *Harness) GroupMessage(groupID, userID int64, text string) {
+	h.Send(MessageEvent{GroupID: groupID, UserID: userID, Text: text})
+}
+
+// PrivateMessage inject a message sent by user in private chat
+func (h *Harness) PrivateMessage(userID int64, text string) {
+	h.Send(MessageEvent{UserID: userID, Text: text})
+}
+
+// Inject inject a raw event, such as notice or request, self_id and time are filled if missing
+func (h *Harness) Inject(event map[string]any) {
+	if _, exist := event["self_id"]; !exist {
+		event["self_id"] = h.SelfID
+	}
+	if _, exist := event["time"]; !exist {
+		event["time"] = time.Now().Unix()
+	}
+
+	encoded, _ := json.Marshal(event)
+	h.FakeDriver.Inject(encoded)
+}
+
+// Reset drop recorded api calls and replies, it should be called between test cases
+func (h *Harness) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.FakeDriver.Reset()
+	h.cursor = 0
+}
+
+// ExpectReply wait for a reply containing the text, replies before the matched one are skipped,
+// so following assertions start after it
+func (h *Harness) ExpectReply(t testing.TB, contains string) Reply {
+	t.Helper()
+
+	reply, matched := h.waitReply(DefaultTimeout, func(reply Reply) bool { return strings.Contains(reply.Text(), contains) })
+	if !matched {
+		t.Fatalf("Expected reply containing %q, but got %v", contains, h.pending())
+	}
+
+	return reply
+}
+
+// ExpectReplyTo wait for a reply containing the text sent to the group, or to the user if group id is 0
+func (h *Harness) ExpectReplyTo(t testing.TB, groupID, userID int64, contains string) Reply {
+	t.Helper()
+
+	reply, matched := h.waitReply(DefaultTimeout, func(reply Reply) bool {
+		return sentTo(reply, groupID, userID) && strings.Contains(reply.Text(), contains)
+	})
+	if !matched {
+		t.Fatalf("Expected reply containing %q to group %d user %d, but got %v", contains, groupID, userID, h.pending())
+	}
+
+	return reply
+}
+
+// ExpectNoReply wait for the duration and check that bot replied nothing new
+func (h *Harness) ExpectNoReply(t testing.TB, within time.Duration) {
+	t.Helper()
+
+	if reply, replied := h.waitReply(within, func(Reply) bool { return true }); replied {
+		t.Fatalf("Expected no reply, but got %q", reply.Text())
+	}
+}
+
+// ExpectCall wait for an api call of the action, such as set_group_ban
+func (h *Harness) ExpectCall(t testing.TB, action string) zero.APIRequest {
+	t.Helper()
+
+	deadline := time.Now().Add(DefaultTimeout)
+	for {
+		for _, call := range h.Calls() {
+			if call.Action == action {
+				return call
+			}
+		}
+		if !h.wait(deadline) {
+			t.Fatalf("Expected api call %s, but it was not called", action)
+			return zero.APIRequest{}
+		}
+	}
+}
+
+// waitReply wait for the first reply after cursor matching the condition, the cursor is moved after it
+func (h *Harness) waitReply(timeout time.Duration, match func(Reply) bool) (Reply, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		h.mu.Lock()
+		replies := h.Replies()
+		for i := h.cursor; i < len(replies); i++ {
+			if match(replies[i]) {
+				h.cursor = i + 1
+				h.mu.Unlock()
+				return replies[i], true
+			}
+		}
+		h.mu.Unlock()
+
+		if !h.wait(deadline) {
+			return Reply{}, false
+		}
+	}
+}
+
+// wait until a new api call or deadline, return false if deadline exceeded
+func (h *Harness) wait(deadline time.Time) bool {
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return false
+	}
+
+	select {
+	case <-h.notify:
+		return true
+	case <-time.After(remaining):
+		return false
+	}
+}
+
+// pending get texts of replies after cursor for failure messages
+func (h *Harness) pending() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	texts := []string{}
+	replies := h.Replies()
+	for i := h.cursor; i < len(replies); i++ {
+		texts = append(texts, replies[i].Text())
+	}
+
+	return texts
+}
+
+func sentTo(reply Reply, groupID, userID int64) bool {
+	if groupID != 0 {
+		return reply.GroupID == groupID
+	}
+
+	return reply.GroupID == 0 && reply.UserID == userID
+}
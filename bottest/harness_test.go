@@ -0,0 +1,379 @@
+package bottest
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FloatTech/zbputils/control"
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/ceobebot-core/devserver"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const testConfig = `
+bot:
+  nickname: ["ceobe"]
+  supper_users: [1]
+  logger: "console"
+plugins:
+  - name: "echo"
+    enable: true
+    handlers:
+      - name: "ping"
+        triggers:
+          full_matches: ["ping"]
+      - name: "greet"
+        reply:
+          text: "hello {{.Nickname}}"
+        triggers:
+          commands: ["greet"]
+      - name: "broadcast"
+        limiter: "owner"
+        triggers:
+          commands: ["broadcast"]
+      - name: "help"
+        limiter: "owner"
+        triggers:
+          commands: ["help"]
+      - name: "blocklist"
+        limiter: "owner"
+        triggers:
+          # block command of zbp control matches blocklist by prefix
+          commands: ["黑名单"]
+  - name: "quiet"
+    description: "quiet plugin"
+    help: "reacts to heartbeats only"
+    enable: true
+    groups:
+      deny: [100]
+    handlers:
+      - name: "heartbeat"
+        triggers:
+          metas: ["heartbeat"]
+blocklist:
+  groups: [900]
+limiters:
+  - name: "owner"
+    by: "user"
+    interval: "1ms"
+    burst: 100
+queue:
+  bot_interval: "-1ns"
+  group_interval: "-1ns"
+  max_retries: -1
+`
+
+var (
+	harness    *Harness
+	heartbeats = make(chan int64, 8)
+)
+
+func TestMain(m *testing.M) {
+	core.RegisterPlugin("echo")
+	core.RegisterPlugin("quiet")
+	core.RegisterHandler("heartbeat", func(ctx *zero.Ctx) {
+		select {
+		case heartbeats <- ctx.Event.SelfID:
+		default:
+		}
+	})
+	core.RegisterHandler("ping", func(ctx *zero.Ctx) {
+		ctx.Send(message.Text("pong"))
+	})
+
+	harness = MustStart([]byte(testConfig))
+	os.Exit(m.Run())
+}
+
+func TestHarness(t *testing.T) {
+	t.Run("GroupMessage", func(t *testing.T) {
+		harness.Reset()
+		harness.GroupMessage(100, 2, "ping")
+
+		if reply := harness.ExpectReply(t, "pong"); reply.GroupID != 100 {
+			t.Errorf("Expected reply to group 100, but got %d", reply.GroupID)
+		}
+	})
+
+	t.Run("PrivateMessage", func(t *testing.T) {
+		harness.Reset()
+		harness.Send(MessageEvent{UserID: 3, Nickname: "alice", Text: "greet"})
+
+		harness.ExpectReplyTo(t, 0, 3, "hello alice")
+	})
+
+	t.Run("NoReply", func(t *testing.T) {
+		harness.Reset()
+		harness.GroupMessage(100, 2, "pong")
+
+		harness.ExpectNoReply(t, 200*time.Millisecond)
+	})
+
+	t.Run("Responder", func(t *testing.T) {
+		harness.Reset()
+		harness.Respond("get_group_member_info", func(params zero.Params) any {
+			return map[string]any{"user_id": params["user_id"], "card": "member"}
+		})
+
+		info := zero.GetBot(DefaultSelfID).GetGroupMemberInfo(100, 2, false)
+		if info.Get("card").String() != "member" {
+			t.Errorf("Expected responder data, but got %s", info.Raw)
+		}
+
+		if call := harness.ExpectCall(t, "get_group_member_info"); call.Params["group_id"] != int64(100) {
+			t.Errorf("Expected call params recorded, but got %v", call.Params)
+		}
+	})
+}
+
+func TestHelp(t *testing.T) {
+	t.Run("PluginList", func(t *testing.T) {
+		harness.Reset()
+		harness.GroupMessage(200, 4, "help")
+
+		reply := harness.ExpectReplyTo(t, 200, 0, "[echo]")
+		for _, expected := range []string{"  - ping", "  - greet", "[quiet] quiet plugin", "  - <meta:heartbeat>"} {
+			if !strings.Contains(reply.Text(), expected) {
+				t.Errorf("Expected %q listed, but got %s", expected, reply.Text())
+			}
+		}
+		if strings.Contains(reply.Text(), "reacts to heartbeats only") {
+			t.Errorf("Expected plugin help only in detail, but got %s", reply.Text())
+		}
+	})
+
+	t.Run("HandlerDetail", func(t *testing.T) {
+		harness.Reset()
+		harness.GroupMessage(200, 4, "help quiet")
+
+		reply := harness.ExpectReplyTo(t, 200, 0, "[quiet] quiet plugin\nreacts to heartbeats only\n  - <meta:heartbeat>")
+		if strings.Contains(reply.Text(), "[echo]") {
+			t.Errorf("Expected only the plugin asked, but got %s", reply.Text())
+		}
+	})
+
+	t.Run("DisabledPlugin", func(t *testing.T) {
+		harness.Reset()
+		harness.GroupMessage(100, 4, "help")
+
+		if reply := harness.ExpectReplyTo(t, 100, 0, "[echo]"); strings.Contains(reply.Text(), "[quiet]") {
+			t.Errorf("Expected plugin disabled in group not listed, but got %s", reply.Text())
+		}
+
+		harness.Reset()
+		harness.GroupMessage(100, 4, "help quiet")
+		harness.ExpectReplyTo(t, 100, 0, "no plugin available: quiet")
+	})
+}
+
+func TestBroadcast(t *testing.T) {
+	harness.Respond("get_group_list", func(zero.Params) any {
+		return []map[string]any{{"group_id": 300}, {"group_id": 100}, {"group_id": 200}}
+	})
+	harness.Respond("send_group_msg", func(params zero.Params) any {
+		if params["group_id"] == int64(300) {
+			return errors.New("risk controlled")
+		}
+		return map[string]any{"message_id": 1}
+	})
+	defer harness.Respond("get_group_list", nil)
+	defer harness.Respond("send_group_msg", nil)
+
+	t.Run("Permission", func(t *testing.T) {
+		harness.Reset()
+		harness.PrivateMessage(2, "broadcast hello")
+
+		harness.ExpectReply(t, "permission denied")
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		harness.Reset()
+		harness.PrivateMessage(1, "broadcast --dry-run hello")
+
+		harness.ExpectReply(t, "dry run, 3 groups targeted: 100, 200, 300")
+		for _, call := range harness.Calls() {
+			if call.Action == "send_group_msg" {
+				t.Errorf("Expected nothing sent in dry run, but got %v", call.Params)
+			}
+		}
+	})
+
+	t.Run("Report", func(t *testing.T) {
+		harness.Reset()
+		harness.PrivateMessage(1, "broadcast --groups 100,300 maintenance tonight")
+
+		if reply := harness.ExpectReplyTo(t, 100, 0, "maintenance tonight"); reply.Text() != "maintenance tonight" {
+			t.Errorf("Expected announcement sent to group 100, but got %s", reply.Text())
+		}
+		harness.ExpectReply(t, "1 succeeded, 1 failed\ngroup 300: send_group_msg failed: 100 risk controlled")
+	})
+
+	t.Run("UnknownPlugin", func(t *testing.T) {
+		harness.Reset()
+		harness.PrivateMessage(1, "broadcast --plugin missing hello")
+
+		harness.ExpectReply(t, "plugin not found: missing")
+	})
+}
+
+func TestBlocklist(t *testing.T) {
+	t.Run("RemoveSeeded", func(t *testing.T) {
+		harness.Reset()
+		harness.PrivateMessage(1, "黑名单 remove --group 900")
+
+		harness.ExpectReply(t, "entry is seeded from config, remove it from config instead: group 900")
+		if !core.IsBlocked(2, 900) {
+			t.Errorf("Expected seeded group still blocked, but it was not")
+		}
+	})
+
+	t.Run("AddAndRemove", func(t *testing.T) {
+		harness.Reset()
+		harness.PrivateMessage(1, "黑名单 add 55 --for 1h")
+		harness.ExpectReply(t, "blocked user 55")
+
+		harness.Reset()
+		harness.PrivateMessage(1, "黑名单 remove 55")
+		harness.ExpectReply(t, "unblocked user 55")
+		if core.IsBlocked(55, 0) {
+			t.Errorf("Expected user unblocked, but it was not")
+		}
+	})
+}
+
+func TestReplay(t *testing.T) {
+	recording := `{"time":"2024-06-25T12:00:00Z","kind":"action","self_id":10000,"action":"get_login_info","data":{}}
+{"time":"2024-06-25T12:00:00Z","kind":"event","self_id":20000,"data":{"post_type":"meta_event","meta_event_type":"heartbeat"}}
+{"time":"2024-06-25T12:00:01Z","kind":"event","self_id":20000,"data":{"post_type":"message","message_type":"group","sub_type":"normal","message_id":1,"group_id":100,"user_id":2,"message":"ping","raw_message":"ping","sender":{"user_id":2,"nickname":"bob"}}}
+{"time":"2024-06-25T12:00:01Z","kind":"action","self_id":20000,"action":"send_group_msg","data":{"group_id":100,"message":"pong"}}
+{"time":"2024-06-25T12:00:02Z","kind":"event","self_id":20000,"data":{"post_type":"message","message_type":"private","sub_type":"friend","message_id":2,"user_id":3,"message":"greet","raw_message":"greet","sender":{"user_id":3,"nickname":"alice"}}}
+{"time":"2024-06-25T12:00:02Z","kind":"action","self_id":20000,"action":"send_private_msg","data":{"user_id":3,"message":"[redacted]"}}
+`
+
+	records, decodeErr := core.DecodeRecords(strings.NewReader(recording))
+	if decodeErr != nil {
+		t.Fatalf("Expected recording decoded, but got %v", decodeErr)
+	}
+
+	steps, _ := replayStepsOf(records)
+	if len(steps) != 2 || len(steps[0].replies) != 1 || len(steps[1].replies) != 1 {
+		t.Fatalf("Expected 2 steps with 1 reply each, but got %v", steps)
+	}
+
+	harness.Replay(t, records)
+
+	t.Run("ArrayMessage", func(t *testing.T) {
+		event := `{"time":"2024-06-25T12:00:01Z","kind":"event","self_id":20000,"data":{"post_type":"message","message_type":"group","sub_type":"normal","message_id":3,"group_id":100,"user_id":2,"message":"ping","raw_message":"ping","sender":{"user_id":2,"nickname":"bob"}}}`
+		replay := func(t testing.TB, reply message.Message) {
+			path := t.TempDir() + "/replay.jsonl"
+			recorder, openErr := core.NewRecorder(core.RecorderConfig{Enable: true, Path: path, Redact: []string{"message"}})
+			if openErr != nil {
+				t.Fatalf("Expected recorder opened, but got %v", openErr)
+			}
+			_ = recorder.RecordAction(20000, zero.APIRequest{Action: "send_group_msg", Params: map[string]any{"group_id": 100, "message": reply}})
+			_ = recorder.Close()
+
+			actions, readErr := core.ReadRecords(path)
+			if readErr != nil || len(actions) != 1 {
+				t.Fatalf("Expected 1 action recorded, but got %v, %v", actions, readErr)
+			}
+			events, _ := core.DecodeRecords(strings.NewReader(event))
+			harness.Replay(t, append(events, actions...))
+		}
+
+		replay(t, message.Message{message.Text("pong")})
+
+		mismatch := &replayFailures{TB: t}
+		replay(mismatch, message.Message{message.Image("file:///pong.png")})
+		if len(mismatch.failures) == 0 {
+			t.Errorf("Expected replay failed on different segment types, but it passed")
+		}
+	})
+}
+
+// replayFailures collect failures of replay instead of failing the test
+type replayFailures struct {
+	testing.TB
+	failures []string
+}
+
+func (r *replayFailures) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestMetaEvent(t *testing.T) {
+	drain := func() {
+		for len(heartbeats) > 0 {
+			<-heartbeats
+		}
+	}
+
+	t.Run("Heartbeat", func(t *testing.T) {
+		drain()
+		harness.Inject(map[string]any{"post_type": "meta_event", "meta_event_type": "heartbeat"})
+		select {
+		case selfID := <-heartbeats:
+			if selfID != DefaultSelfID {
+				t.Errorf("Expected heartbeat of bot %d, but got %d", DefaultSelfID, selfID)
+			}
+		case <-time.After(DefaultTimeout):
+			t.Errorf("Expected heartbeat trigger fired, but timeout")
+		}
+	})
+
+	t.Run("DisabledPlugin", func(t *testing.T) {
+		drain()
+		manager, _ := control.Lookup("quiet")
+		manager.Disable(0)
+		defer func() {
+			// drop the setting for all chats, so group lists of the plugin take effect again
+			_ = manager.Manager.D.Del(manager.Service, "WHERE gid=0")
+			manager.Manager.Lock()
+			delete(manager.Cache, 0)
+			manager.Manager.Unlock()
+		}()
+
+		harness.Inject(map[string]any{"post_type": "meta_event", "meta_event_type": "heartbeat"})
+		select {
+		case <-heartbeats:
+			t.Errorf("Expected heartbeat trigger of disabled plugin not fired, but it was")
+		case <-time.After(DefaultTimeout / 10):
+		}
+	})
+
+	t.Run("Websocket", func(t *testing.T) {
+		drain()
+		server := devserver.NewServer(30000, "")
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+		defer server.Close()
+
+		// drive zero by a real websocket connection instead of the fake driver, so heartbeats go through the driver
+		<-harness.listening
+		harness.mu.Lock()
+		listener := harness.listener
+		harness.mu.Unlock()
+
+		client := core.NewWebsocketClient("ws"+strings.TrimPrefix(httpServer.URL, "http"), "", core.ReconnectConfig{InitialBackoff: time.Millisecond, MaxAttempts: 1})
+		client.Connect()
+		go client.Listen(listener)
+
+		if injectErr := server.Inject(map[string]any{"post_type": "meta_event", "meta_event_type": "heartbeat"}); injectErr != nil {
+			t.Fatalf("Expected heartbeat injected, but got %v", injectErr)
+		}
+		select {
+		case selfID := <-heartbeats:
+			if selfID != 30000 {
+				t.Errorf("Expected heartbeat of bot 30000, but got %d", selfID)
+			}
+		case <-time.After(DefaultTimeout):
+			t.Errorf("Expected heartbeat trigger fired through websocket driver, but timeout")
+		}
+	})
+}
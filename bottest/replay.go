@@ -0,0 +1,128 @@
+package bottest
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alioth-center/ceobebot-core/core"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// ReplaySettle is the time replay waits for unexpected replies after the recorded ones of each event
+var ReplaySettle = 100 * time.Millisecond
+
+// replayStep is a recorded event and the messages bot sent after it, before the next event
+type replayStep struct {
+	event   map[string]any
+	replies []Reply
+}
+
+// ReplayFile replay the recording file, see Replay
+func (h *Harness) ReplayFile(t testing.TB, path string) {
+	t.Helper()
+
+	records, readErr := core.ReadRecords(path)
+	if readErr != nil {
+		t.Fatalf("Expected recording readable, but got %v", readErr)
+	}
+
+	h.Replay(t, records)
+}
+
+// Replay feed recorded events to bot one by one and compare the messages it sends with the recorded ones,
+// messages are compared by action, target and content, redacted content is not compared, meta events are skipped
+func (h *Harness) Replay(t testing.TB, records []core.Record) {
+	t.Helper()
+
+	steps, parseErr := replayStepsOf(records)
+	if parseErr != nil {
+		t.Fatalf("Expected recording valid, but got %v", parseErr)
+	}
+
+	for i, step := range steps {
+		h.Reset()
+		step.event["self_id"] = h.SelfID
+		h.Inject(step.event)
+
+		for _, expected := range step.replies {
+			if _, matched := h.waitReply(DefaultTimeout, func(reply Reply) bool { return sameReply(expected, reply) }); !matched {
+				t.Errorf("Expected event %d replied %s to group %d user %d with %q, but got %v", i, expected.Action, expected.GroupID, expected.UserID, expected.Message.String(), h.pending())
+			}
+		}
+		if reply, replied := h.waitReply(ReplaySettle, func(Reply) bool { return true }); replied {
+			t.Errorf("Expected no more replies of event %d, but got %q", i, reply.Message.String())
+		}
+	}
+}
+
+// replayStepsOf group records by events, actions before the first event and other api calls are dropped
+func replayStepsOf(records []core.Record) ([]replayStep, error) {
+	steps := []replayStep{}
+	for _, record := range records {
+		switch record.Kind {
+		case core.RecordEvent:
+			event := map[string]any{}
+			decoder := json.NewDecoder(bytes.NewReader(record.Data))
+			decoder.UseNumber()
+			if decodeErr := decoder.Decode(&event); decodeErr != nil {
+				return nil, decodeErr
+			}
+			if event["post_type"] == "meta_event" {
+				continue
+			}
+
+			steps = append(steps, replayStep{event: event})
+		case core.RecordAction:
+			if len(steps) == 0 || !isSendAction(record.Action) {
+				continue
+			}
+
+			params := zero.Params{}
+			if decodeErr := json.Unmarshal(record.Data, &params); decodeErr != nil {
+				return nil, decodeErr
+			}
+
+			last := &steps[len(steps)-1]
+			last.replies = append(last.replies, replyOf(record.Action, params))
+		}
+	}
+
+	return steps, nil
+}
+
+func sameReply(expected, actual Reply) bool {
+	if expected.Action != actual.Action || expected.GroupID != actual.GroupID || expected.UserID != actual.UserID {
+		return false
+	}
+
+	// content of redacted recording is unknown
+	if expected.Text() == core.RedactedText {
+		return true
+	}
+
+	// only segment types are known for redacted array messages
+	if redacted(expected.Message) {
+		return slices.EqualFunc(expected.Message, actual.Message, func(e, a message.MessageSegment) bool {
+			return e.Type == a.Type
+		})
+	}
+
+	return expected.Message.String() == actual.Message.String()
+}
+
+// redacted check whether any segment data of the message is redacted
+func redacted(msg message.Message) bool {
+	for _, segment := range msg {
+		for _, value := range segment.Data {
+			if value == core.RedactedText {
+				return true
+			}
+		}
+	}
+
+	return false
+}
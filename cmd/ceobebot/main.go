@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/ceobebot-core/devserver"
+	"gopkg.in/yaml.v3"
+)
+
+const usage = `usage: ceobebot <command> [flags]
+
+commands:
+  dev    run a fake onebot v11 server and send messages to bot from terminal
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "dev":
+		if devErr := dev(os.Args[2:]); devErr != nil {
+			fmt.Fprintln(os.Stderr, devErr)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// dev serve a fake onebot server on the websocket address of bot config, then start the repl,
+// the bot is started separately and connects to the server as it connects to go-cqhttp
+func dev(args []string) error {
+	flags := flag.NewFlagSet("dev", flag.ExitOnError)
+	configPath := flags.String("config", "./config/bot.yaml", "bot config providing websocket address and access token")
+	addr := flags.String("addr", "", "listen address, default is websocket host and port of bot config, or 127.0.0.1:6700")
+	token := flags.String("token", "", "access token, default is websocket access token of bot config")
+	selfID := flags.Int64("self", devserver.DefaultSelfID, "account of bot")
+	groupID := flags.Int64("group", 0, "group of messages at start, 0 means private chat")
+	userID := flags.Int64("user", 1, "user sending messages at start")
+	_ = flags.Parse(args)
+
+	listenAddr, accessToken := "127.0.0.1:6700", ""
+	if websocket, loadErr := loadWebsocket(*configPath); loadErr != nil {
+		return loadErr
+	} else if endpoint, parseErr := url.Parse(websocket.URL); websocket.URL != "" && parseErr == nil {
+		// the fake server serves plain websocket only, path and tls options are ignored
+		listenAddr, accessToken = endpoint.Host, websocket.AccessToken
+	} else if websocket.Port != 0 {
+		listenAddr, accessToken = fmt.Sprintf("%s:%d", websocket.Host, websocket.Port), websocket.AccessToken
+	}
+	if *addr != "" {
+		listenAddr = *addr
+	}
+	if *token != "" {
+		accessToken = *token
+	}
+
+	server := devserver.NewServer(*selfID, accessToken)
+	repl := devserver.NewREPL(server, os.Stdin, os.Stdout)
+	repl.GroupID, repl.UserID = *groupID, *userID
+
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe(listenAddr) }()
+	fmt.Printf("fake onebot server listening on ws://%s, waiting for bot to connect\n", listenAddr)
+
+	select {
+	case serveErr := <-served:
+		return serveErr
+	case <-server.Connected():
+		fmt.Printf("bot %d connected\n", *selfID)
+	}
+
+	defer func() { _ = server.Close() }()
+	return repl.Run()
+}
+
+// loadWebsocket read websocket config from bot config, missing config file is not an error
+func loadWebsocket(path string) (core.WebsocketConfig, error) {
+	content, readErr := os.ReadFile(filepath.Clean(path))
+	if errors.Is(readErr, os.ErrNotExist) {
+		return core.WebsocketConfig{}, nil
+	} else if readErr != nil {
+		return core.WebsocketConfig{}, readErr
+	}
+
+	cfg := core.Config{}
+	if unmarshalErr := yaml.Unmarshal(content, &cfg); unmarshalErr != nil {
+		return core.WebsocketConfig{}, fmt.Errorf("failed to parse bot config: %w", unmarshalErr)
+	}
+
+	return cfg.Websocket, nil
+}
@@ -0,0 +1,185 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/infrastructure/logger"
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	BlockUser  = "user"
+	BlockGroup = "group"
+
+	blockPrefix = "block/"
+)
+
+var (
+	// ErrSeededBlock is returned when removing an entry seeded from config, it can only be removed from config
+	ErrSeededBlock = errors.New("entry is seeded from config, remove it from config instead")
+
+	blocklistStorageOnce sync.Once
+	blocklistStorage     Storage
+
+	// blocked is the stored entries kept in memory, so events are checked without querying storage,
+	// it maps the key of entry to the time it expires, zero time means permanently
+	blockedMu sync.RWMutex
+	blocked   map[string]time.Time
+)
+
+// BlockEntry is a blocked user or group, ExpiresIn 0 means blocked permanently,
+// entries seeded from config are marked as Seeded and cannot be removed by commands
+type BlockEntry struct {
+	Kind      string        `json:"kind"`
+	ID        int64         `json:"id"`
+	ExpiresIn time.Duration `json:"expires_in,omitempty"`
+	Seeded    bool          `json:"seeded,omitempty"`
+}
+
+// Block ignore the user or group, kind is BlockUser or BlockGroup, duration 0 means permanently
+func Block(kind string, id int64, duration time.Duration) error {
+	if kind != BlockUser && kind != BlockGroup {
+		return fmt.Errorf("invalid block kind: %s", kind)
+	}
+
+	key, expireAt, saveErr := blockKey(kind, id), time.Time{}, error(nil)
+	if duration > 0 {
+		expireAt, saveErr = time.Now().Add(duration), openBlocklistStorage().SetWithTTL(key, []byte(kind), duration)
+	} else {
+		saveErr = openBlocklistStorage().Set(key, []byte(kind))
+	}
+	if saveErr != nil {
+		return saveErr
+	}
+
+	blockedMu.Lock()
+	blocked[key] = expireAt
+	blockedMu.Unlock()
+
+	return nil
+}
+
+// Unblock remove the user or group from stored blocklist, entries seeded from config cannot be removed,
+// ErrSeededBlock is returned for them
+func Unblock(kind string, id int64) error {
+	seeded := liveBlocklist()
+	if (kind == BlockUser && slices.Contains(seeded.Users, id)) || (kind == BlockGroup && slices.Contains(seeded.Groups, id)) {
+		return fmt.Errorf("%w: %s %d", ErrSeededBlock, kind, id)
+	}
+
+	key := blockKey(kind, id)
+	if deleteErr := openBlocklistStorage().Delete(key); deleteErr != nil {
+		return deleteErr
+	}
+
+	blockedMu.Lock()
+	delete(blocked, key)
+	blockedMu.Unlock()
+
+	return nil
+}
+
+// IsBlocked check if the user or the group is blocked in memory, bot owners are never blocked
+func IsBlocked(uid, gid int64) bool {
+	if slices.Contains(zero.BotConfig.SuperUsers, uid) {
+		return false
+	}
+	if seeded := liveBlocklist(); slices.Contains(seeded.Users, uid) || (gid != 0 && slices.Contains(seeded.Groups, gid)) {
+		return true
+	}
+
+	openBlocklistStorage()
+	blockedMu.RLock()
+	defer blockedMu.RUnlock()
+
+	now := time.Now()
+	return isBlockedAt(blockKey(BlockUser, uid), now) || (gid != 0 && isBlockedAt(blockKey(BlockGroup, gid), now))
+}
+
+// NotBlocked is the pre handler of all plugins, events from blocked users or groups are dropped
+func NotBlocked(ctx *zero.Ctx) bool {
+	return !IsBlocked(ctx.Event.UserID, ctx.Event.GroupID)
+}
+
+// Blocks list seeded and stored entries, stored entries are sorted by kind and id
+func Blocks() (entries []BlockEntry) {
+	seeded := liveBlocklist()
+	for _, uid := range seeded.Users {
+		entries = append(entries, BlockEntry{Kind: BlockUser, ID: uid, Seeded: true})
+	}
+	for _, gid := range seeded.Groups {
+		entries = append(entries, BlockEntry{Kind: BlockGroup, ID: gid, Seeded: true})
+	}
+
+	stored := []BlockEntry{}
+	openBlocklistStorage()
+	blockedMu.Lock()
+	now := time.Now()
+	for key, expireAt := range blocked {
+		kind, rawID, valid := strings.Cut(strings.TrimPrefix(key, blockPrefix), "/")
+		id, parseErr := strconv.ParseInt(rawID, 10, 64)
+		if !isBlockedAt(key, now) {
+			// storage drops it by ttl as well
+			delete(blocked, key)
+			continue
+		}
+		if !valid || parseErr != nil {
+			continue
+		}
+
+		stored = append(stored, BlockEntry{Kind: kind, ID: id, ExpiresIn: shortcut.Ternary(expireAt.IsZero(), 0, expireAt.Sub(now))})
+	}
+	blockedMu.Unlock()
+	slices.SortFunc(stored, func(a, b BlockEntry) int {
+		return strings.Compare(blockKey(a.Kind, a.ID), blockKey(b.Kind, b.ID))
+	})
+
+	return append(entries, stored...)
+}
+
+// isBlockedAt check if the stored entry is not expired at now, the caller holds the lock
+func isBlockedAt(key string, now time.Time) bool {
+	expireAt, exist := blocked[key]
+	return exist && (expireAt.IsZero() || now.Before(expireAt))
+}
+
+func blockKey(kind string, id int64) string {
+	return blockPrefix + kind + "/" + strconv.FormatInt(id, 10)
+}
+
+func openBlocklistStorage() Storage {
+	blocklistStorageOnce.Do(func() {
+		storage, openErr := NewFileStorage(filepath.Join("data", "blocklist", "blocklist.storage.db"))
+		if openErr != nil {
+			coreLogger.Info(logger.NewFields().WithMessage("failed to open blocklist storage, fallback to memory").WithData(openErr.Error()))
+			storage = NewMemoryStorage()
+		}
+
+		loaded := loadBlocked(storage)
+		blockedMu.Lock()
+		blocklistStorage, blocked = storage, loaded
+		blockedMu.Unlock()
+	})
+
+	return blocklistStorage
+}
+
+// loadBlocked load stored entries into memory, they are kept in sync by Block and Unblock after loaded
+func loadBlocked(storage Storage) map[string]time.Time {
+	loaded, now := map[string]time.Time{}, time.Now()
+	for key := range storage.Scan(blockPrefix) {
+		if ttl, exist := storage.TTL(key); exist {
+			loaded[key] = shortcut.Ternary(ttl > 0, now.Add(ttl), time.Time{})
+		}
+	}
+
+	return loaded
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"strings"
+
 	"github.com/FloatTech/zbputils/ctxext"
 	zero "github.com/wdvxdr1123/ZeroBot"
 )
@@ -17,4 +19,12 @@ func initRegister() {
 	RegisterTriggerRule("only_public", zero.OnlyPublic)
 	RegisterTriggerRule("only_group", zero.OnlyGroup)
 	RegisterTriggerRule("only_guild", zero.OnlyGuild)
+
+	RegisterLimiterBackend(LimiterBackendMemory, NewStorageLimiterBackend(NewMemoryStorage()))
+
+	RegisterNormalizer("lower", strings.ToLower)
+	RegisterNormalizer("width", normalizeWidth)
+	RegisterNormalizer("space", normalizeSpace)
+	RegisterNormalizer("simplified", normalizeSimplified)
+	RegisterNormalizer("pinyin", normalizePinyin)
 }
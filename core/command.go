@@ -0,0 +1,273 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	argString   = "string"
+	argInt      = "int"
+	argBool     = "bool"
+	argDuration = "duration"
+	argMention  = "mention"
+	argEnum     = "enum"
+)
+
+type (
+	// argSpec is the schema of an argument, declared by struct tags:
+	//   - arg:"name[,pos][,required][,mention]", pos means positional argument, others are --name flags
+	//   - enum:"a|b|c", the value must be one of the options
+	//   - default:"value", the value used when argument is not given
+	argSpec struct {
+		field      int
+		name       string
+		kind       string
+		positional bool
+		required   bool
+		enum       []string
+		fallback   string
+		hasDefault bool
+	}
+
+	commandSchema struct {
+		positionals []argSpec
+		flags       map[string]argSpec
+		flagOrder   []string
+	}
+)
+
+// RegisterCommandHandler register a handler with typed arguments, args is a struct which fields declare the
+// argument schema, when arguments are invalid, the usage will be replied and the handler will not be called
+func RegisterCommandHandler[T any](name string, handler func(ctx *zero.Ctx, args T)) {
+	schema := newCommandSchema(reflect.TypeFor[T]())
+	RegisterHandler(name, func(ctx *zero.Ctx) {
+		var args T
+		raw, _ := ctx.State["args"].(string)
+		if parseErr := schema.parse(raw, mentionsOf(ctx), reflect.ValueOf(&args).Elem()); parseErr != nil {
+			command, _ := ctx.State["command"].(string)
+			ctx.Send(message.Text(parseErr.Error() + "\n" + schema.usage(zero.BotConfig.CommandPrefix+command)))
+			return
+		}
+
+		handler(ctx, args)
+	})
+}
+
+func newCommandSchema(typ reflect.Type) *commandSchema {
+	if typ.Kind() != reflect.Struct {
+		panic("command arguments must be a struct: " + typ.String())
+	}
+
+	schema := &commandSchema{flags: map[string]argSpec{}}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, tagged := field.Tag.Lookup("arg")
+		if !tagged || !field.IsExported() {
+			continue
+		}
+
+		spec := newArgSpec(i, field, strings.Split(tag, ","))
+		if spec.positional {
+			schema.positionals = append(schema.positionals, spec)
+		} else {
+			schema.flags[spec.name] = spec
+			schema.flagOrder = append(schema.flagOrder, spec.name)
+		}
+	}
+
+	return schema
+}
+
+// newArgSpec create argument spec from struct field, the kind is decided by field type and options
+func newArgSpec(index int, field reflect.StructField, options []string) argSpec {
+	spec := argSpec{field: index, name: options[0]}
+	spec.positional, spec.required = slices.Contains(options, "pos"), slices.Contains(options, "required")
+	spec.fallback, spec.hasDefault = field.Tag.Lookup("default")
+	if enum, isEnum := field.Tag.Lookup("enum"); isEnum {
+		spec.enum = strings.Split(enum, "|")
+	}
+
+	switch {
+	case field.Type == reflect.TypeFor[time.Duration]():
+		spec.kind = argDuration
+	case slices.Contains(options, "mention") && field.Type.Kind() == reflect.Int64:
+		spec.kind = argMention
+	case field.Type.Kind() >= reflect.Int && field.Type.Kind() <= reflect.Int64:
+		spec.kind = argInt
+	case field.Type.Kind() == reflect.Bool && !spec.positional:
+		spec.kind = argBool
+	case field.Type.Kind() == reflect.String && len(spec.enum) > 0:
+		spec.kind = argEnum
+	case field.Type.Kind() == reflect.String:
+		spec.kind = argString
+	default:
+		panic("unsupported argument type: " + field.Name + " " + field.Type.String())
+	}
+
+	return spec
+}
+
+// parse arguments into out, positional arguments are separated by spaces, flags are --name value or --name=value,
+// mentions are consumed by mention arguments before plain text, the last string positional takes the rest text
+func (s *commandSchema) parse(raw string, mentions []int64, out reflect.Value) error {
+	given, positionals := map[string]string{}, []string{}
+	tokens := strings.Fields(raw)
+	for i := 0; i < len(tokens); i++ {
+		name, isFlag := strings.CutPrefix(tokens[i], "--")
+		if !isFlag {
+			positionals = append(positionals, tokens[i])
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(name, "=")
+		spec, exist := s.flags[name]
+		switch {
+		case !exist:
+			return fmt.Errorf("unknown flag: --%s", name)
+		case hasValue:
+			given[name] = value
+		case spec.kind == argBool:
+			given[name] = "true"
+		case i+1 < len(tokens):
+			given[name], i = tokens[i+1], i+1
+		default:
+			return fmt.Errorf("missing value of flag: --%s", name)
+		}
+	}
+
+	for i, spec := range s.positionals {
+		switch {
+		case spec.kind == argMention && len(mentions) > 0:
+			given[spec.name], mentions = strconv.FormatInt(mentions[0], 10), mentions[1:]
+		case len(positionals) == 0:
+			continue
+		case spec.kind == argString && i == len(s.positionals)-1:
+			given[spec.name], positionals = strings.Join(positionals, " "), nil
+		default:
+			given[spec.name], positionals = positionals[0], positionals[1:]
+		}
+	}
+	if len(positionals) > 0 {
+		return fmt.Errorf("too many arguments: %s", strings.Join(positionals, " "))
+	}
+
+	for _, spec := range append(slices.Clone(s.positionals), s.flagSpecs()...) {
+		value, exist := given[spec.name]
+		switch {
+		case !exist && spec.hasDefault:
+			value = spec.fallback
+		case !exist && spec.required:
+			return fmt.Errorf("missing argument: %s", spec.name)
+		case !exist:
+			continue
+		}
+
+		if assignErr := spec.assign(out.Field(spec.field), value); assignErr != nil {
+			return assignErr
+		}
+	}
+
+	return nil
+}
+
+func (s *commandSchema) flagSpecs() (specs []argSpec) {
+	for _, name := range s.flagOrder {
+		specs = append(specs, s.flags[name])
+	}
+
+	return specs
+}
+
+// usage generate usage text, required arguments are wrapped by <>, optional ones are wrapped by []
+func (s *commandSchema) usage(command string) string {
+	usage := strings.Builder{}
+	usage.WriteString("usage: " + command)
+	for _, spec := range append(slices.Clone(s.positionals), s.flagSpecs()...) {
+		name := spec.name
+		if !spec.positional {
+			name = "--" + name
+			if spec.kind != argBool {
+				name += " " + spec.describe()
+			}
+		} else {
+			name += ":" + spec.describe()
+		}
+		if spec.hasDefault {
+			name += "=" + spec.fallback
+		}
+
+		usage.WriteString(" " + shortcut.Ternary(spec.required, "<"+name+">", "["+name+"]"))
+	}
+
+	return usage.String()
+}
+
+func (spec argSpec) describe() string {
+	if spec.kind == argEnum {
+		return strings.Join(spec.enum, "|")
+	}
+
+	return spec.kind
+}
+
+func (spec argSpec) assign(field reflect.Value, value string) error {
+	switch spec.kind {
+	case argInt:
+		parsed, parseErr := strconv.ParseInt(value, 10, 64)
+		if parseErr != nil || field.OverflowInt(parsed) {
+			return fmt.Errorf("invalid %s: %s is not an integer", spec.name, value)
+		}
+		field.SetInt(parsed)
+	case argMention:
+		parsed, parseErr := strconv.ParseInt(strings.TrimPrefix(value, "@"), 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid %s: %s is not a user", spec.name, value)
+		}
+		field.SetInt(parsed)
+	case argDuration:
+		parsed, parseErr := time.ParseDuration(value)
+		if parseErr != nil {
+			return fmt.Errorf("invalid %s: %s is not a duration", spec.name, value)
+		}
+		field.SetInt(int64(parsed))
+	case argBool:
+		parsed, parseErr := strconv.ParseBool(value)
+		if parseErr != nil {
+			return fmt.Errorf("invalid %s: %s is not a boolean", spec.name, value)
+		}
+		field.SetBool(parsed)
+	case argEnum:
+		if !slices.Contains(spec.enum, value) {
+			return fmt.Errorf("invalid %s: %s is not one of %s", spec.name, value, strings.Join(spec.enum, "|"))
+		}
+		field.SetString(value)
+	default:
+		field.SetString(value)
+	}
+
+	return nil
+}
+
+// mentionsOf get the users mentioned in message, mention all is ignored
+func mentionsOf(ctx *zero.Ctx) (mentions []int64) {
+	for _, segment := range ctx.Event.Message {
+		if segment.Type != "at" {
+			continue
+		}
+
+		if qq, parseErr := strconv.ParseInt(segment.Data["qq"], 10, 64); parseErr == nil {
+			mentions = append(mentions, qq)
+		}
+	}
+
+	return mentions
+}
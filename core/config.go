@@ -1,8 +1,12 @@
 package core
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"time"
 
 	zero "github.com/wdvxdr1123/ZeroBot"
 
@@ -13,22 +17,163 @@ import (
 type Config struct {
 	Bot        BotConfig       `yaml:"bot" json:"bot"`
 	Websocket  WebsocketConfig `yaml:"websocket" json:"websocket"`
+	Adapters   []AdapterConfig `yaml:"adapters" json:"adapters,omitempty"`
+	Admin      AdminConfig     `yaml:"admin" json:"admin"`
+	Roles      []RoleConfig    `yaml:"roles" json:"roles,omitempty"`
+	Blocklist  BlocklistConfig `yaml:"blocklist" json:"blocklist"`
+	Limiters   []LimiterConfig `yaml:"limiters" json:"limiters,omitempty"`
+	Queue      QueueConfig     `yaml:"queue" json:"queue"`
+	Recorder   RecorderConfig  `yaml:"recorder" json:"recorder"`
 	Plugins    []PluginConfig  `yaml:"plugins" json:"plugins,omitempty"`
 	ZeroConfig *zero.Config    `yaml:"-" json:"-"`
 }
 
 type BotConfig struct {
-	Nickname      []string `yaml:"nickname" json:"nickname,omitempty"`
-	TriggerPrefix string   `yaml:"trigger_prefix" json:"trigger_prefix,omitempty"`
-	SupperUsers   []int64  `yaml:"supper_users" json:"supper_users,omitempty"`
-	Logger        string   `yaml:"logger" json:"logger,omitempty"`
-	Debug         bool     `yaml:"debug" json:"debug,omitempty"`
+	Nickname      []string                   `yaml:"nickname" json:"nickname,omitempty"`
+	TriggerPrefix string                     `yaml:"trigger_prefix" json:"trigger_prefix,omitempty"`
+	SupperUsers   []int64                    `yaml:"supper_users" json:"supper_users,omitempty"`
+	Logger        string                     `yaml:"logger" json:"logger,omitempty"`
+	HelpAsImage   bool                       `yaml:"help_as_image" json:"help_as_image,omitempty"`
+	StrictTrigger bool                       `yaml:"strict_trigger" json:"strict_trigger,omitempty"`
+	Session       SessionConfig              `yaml:"session" json:"session"`
+	OnLimited     map[string]OnLimitedConfig `yaml:"on_limited" json:"on_limited,omitempty"`
+	Debug         bool                       `yaml:"debug" json:"debug,omitempty"`
 }
 
+type SessionConfig struct {
+	MaxConcurrent int      `yaml:"max_concurrent" json:"max_concurrent,omitempty"`
+	CancelWords   []string `yaml:"cancel_words" json:"cancel_words,omitempty"`
+	Persist       bool     `yaml:"persist" json:"persist,omitempty"`
+}
+
+// OnLimitedConfig is the feedback when limiter denies a request, it can be set per limiter in bot config
+// or per handler, mode is silent, reply or cooldown, text can contain {cooldown} placeholder computed from
+// the bound limiter, window is the anti-spam window of notices
+type OnLimitedConfig struct {
+	Mode   string        `yaml:"mode" json:"mode,omitempty"`
+	Text   string        `yaml:"text" json:"text,omitempty"`
+	Window time.Duration `yaml:"window" json:"window,omitempty"`
+}
+
+// LimiterConfig declare a limiter usable by limiter field of handlers, it is a daily quota when quota is set,
+// which resets at reset_at in local time, otherwise a token bucket refilled one token per interval,
+// by is the scope of user, group or user_group, backend is memory, file or a registered backend
+type LimiterConfig struct {
+	Name     string        `yaml:"name" json:"name,omitempty"`
+	By       string        `yaml:"by" json:"by,omitempty"`
+	Backend  string        `yaml:"backend" json:"backend,omitempty"`
+	Quota    int           `yaml:"quota" json:"quota,omitempty"`
+	ResetAt  string        `yaml:"reset_at" json:"reset_at,omitempty"`
+	Interval time.Duration `yaml:"interval" json:"interval,omitempty"`
+	Burst    int           `yaml:"burst" json:"burst,omitempty"`
+}
+
+// QueueConfig is the outbound queue used by Send, bot interval and group interval are the minimal gaps between
+// two messages of a bot and of a group, negative intervals disable throttling, failed sends are retried with
+// exponential backoff from retry backoff up to max retry backoff, negative max retries disables retry
+type QueueConfig struct {
+	Size            int           `yaml:"size" json:"size,omitempty"`
+	BotInterval     time.Duration `yaml:"bot_interval" json:"bot_interval,omitempty"`
+	GroupInterval   time.Duration `yaml:"group_interval" json:"group_interval,omitempty"`
+	MaxRetries      int           `yaml:"max_retries" json:"max_retries,omitempty"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff" json:"retry_backoff,omitempty"`
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff" json:"max_retry_backoff,omitempty"`
+}
+
+// WebsocketConfig is the connection of onebot server, url takes precedence over scheme, host, port and path,
+// headers are sent in handshake, which is useful for adapters behind reverse proxies,
+// mode is websocket by default, http mode uses http api and http post configured in http,
+// console mode reads messages from terminal as the user configured in console
 type WebsocketConfig struct {
-	Host        string `yaml:"host" json:"host,omitempty"`
-	Port        int    `yaml:"port" json:"port,omitempty"`
-	AccessToken string `yaml:"access_token" json:"access_token,omitempty"`
+	Mode        string            `yaml:"mode" json:"mode,omitempty"`
+	URL         string            `yaml:"url" json:"url,omitempty"`
+	Scheme      string            `yaml:"scheme" json:"scheme,omitempty"`
+	Host        string            `yaml:"host" json:"host,omitempty"`
+	Port        int               `yaml:"port" json:"port,omitempty"`
+	Path        string            `yaml:"path" json:"path,omitempty"`
+	AccessToken string            `yaml:"access_token" json:"access_token,omitempty"`
+	Headers     map[string]string `yaml:"headers" json:"headers,omitempty"`
+	TLS         TLSConfig         `yaml:"tls" json:"tls"`
+	HTTP        HTTPConfig        `yaml:"http" json:"http"`
+	Console     ConsoleConfig     `yaml:"console" json:"console"`
+	Reconnect   ReconnectConfig   `yaml:"reconnect" json:"reconnect"`
+}
+
+// ConsoleConfig is the fake user sending messages in console mode, group 0 means private chat
+type ConsoleConfig struct {
+	SelfID   int64  `yaml:"self_id" json:"self_id,omitempty"`
+	UserID   int64  `yaml:"user_id" json:"user_id,omitempty"`
+	Nickname string `yaml:"nickname" json:"nickname,omitempty"`
+	GroupID  int64  `yaml:"group_id" json:"group_id,omitempty"`
+	Role     string `yaml:"role" json:"role,omitempty"`
+}
+
+// AdapterConfig is the connection of a platform other than onebot, such as telegram or discord,
+// api and gateway override the official endpoints, which is useful for proxies and testing
+type AdapterConfig struct {
+	Platform string `yaml:"platform" json:"platform,omitempty"`
+	Token    string `yaml:"token" json:"token,omitempty"`
+	API      string `yaml:"api" json:"api,omitempty"`
+	Gateway  string `yaml:"gateway" json:"gateway,omitempty"`
+}
+
+// HTTPConfig is the onebot http api and http post of http mode, api is the url of http api,
+// listen is the address receiving events, secret is the key of event signature
+type HTTPConfig struct {
+	API     string        `yaml:"api" json:"api,omitempty"`
+	Listen  string        `yaml:"listen" json:"listen,omitempty"`
+	Path    string        `yaml:"path" json:"path,omitempty"`
+	Secret  string        `yaml:"secret" json:"secret,omitempty"`
+	Timeout time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+// TLSConfig is the tls options of wss connection, ca is the pem file of trusted certificate authorities,
+// cert and key are the pem files of client certificate
+type TLSConfig struct {
+	CA                 string `yaml:"ca" json:"ca,omitempty"`
+	Cert               string `yaml:"cert" json:"cert,omitempty"`
+	Key                string `yaml:"key" json:"key,omitempty"`
+	ServerName         string `yaml:"server_name" json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+// ReconnectConfig is the retry behavior of websocket connection, backoff doubles from initial to max backoff,
+// jitter is the random ratio applied to backoff, max attempts 0 means retrying forever
+type ReconnectConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff" json:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" json:"max_backoff,omitempty"`
+	Jitter         float64       `yaml:"jitter" json:"jitter,omitempty"`
+	MaxAttempts    int           `yaml:"max_attempts" json:"max_attempts,omitempty"`
+}
+
+// RecorderConfig is the recording of events and actions, max size is in megabytes,
+// redact is the keys whose values are masked, such as message, raw_message or nickname
+type RecorderConfig struct {
+	Enable   bool     `yaml:"enable" json:"enable,omitempty"`
+	Path     string   `yaml:"path" json:"path,omitempty"`
+	MaxSize  int      `yaml:"max_size" json:"max_size,omitempty"`
+	MaxFiles int      `yaml:"max_files" json:"max_files,omitempty"`
+	Redact   []string `yaml:"redact" json:"redact,omitempty"`
+}
+
+type AdminConfig struct {
+	Enable bool   `yaml:"enable" json:"enable,omitempty"`
+	Host   string `yaml:"host" json:"host,omitempty"`
+	Port   int    `yaml:"port" json:"port,omitempty"`
+	Token  string `yaml:"token" json:"token,omitempty"`
+}
+
+// BlocklistConfig is the users and groups always ignored, entries added by chat commands are stored separately
+type BlocklistConfig struct {
+	Users  []int64 `yaml:"users" json:"users,omitempty"`
+	Groups []int64 `yaml:"groups" json:"groups,omitempty"`
+}
+
+// RoleConfig is a permission role, users holding the role also hold the roles it includes
+type RoleConfig struct {
+	Name        string   `yaml:"name" json:"name,omitempty"`
+	Description string   `yaml:"description" json:"description,omitempty"`
+	Includes    []string `yaml:"includes" json:"includes,omitempty"`
 }
 
 type (
@@ -42,16 +187,37 @@ type (
 		ResourceFolder string           `yaml:"resource_folder" json:"resource_folder,omitempty"`
 		DataFolder     string           `yaml:"data_folder" json:"data_folder,omitempty"`
 		Priority       int              `yaml:"priority" json:"priority,omitempty"`
+		DefaultEnabled *bool            `yaml:"default_enabled" json:"default_enabled,omitempty"`
+		Groups         GroupsConfig     `yaml:"groups" json:"groups"`
 		Middlewares    MiddlewareConfig `yaml:"middlewares" json:"middlewares"`
 		Handlers       []HandlerConfig  `yaml:"handlers" json:"handlers,omitempty"`
 	}
 
 	HandlerConfig struct {
-		Name     string        `yaml:"name" json:"name,omitempty"`
-		Blocked  bool          `yaml:"blocked" json:"blocked,omitempty"`
-		Limiter  string        `yaml:"limiter" json:"limiter,omitempty"`
-		Rules    []string      `yaml:"rules" json:"rules,omitempty"`
-		Triggers TriggerConfig `yaml:"triggers" json:"triggers"`
+		Name        string           `yaml:"name" json:"name,omitempty"`
+		Description string           `yaml:"description" json:"description,omitempty"`
+		Blocked     bool             `yaml:"blocked" json:"blocked,omitempty"`
+		Limiter     string           `yaml:"limiter" json:"limiter,omitempty"`
+		Rules       []string         `yaml:"rules" json:"rules,omitempty"`
+		Triggers    TriggerConfig    `yaml:"triggers" json:"triggers"`
+		Reply       *ReplyConfig     `yaml:"reply" json:"reply,omitempty"`
+		OnLimited   *OnLimitedConfig `yaml:"on_limited" json:"on_limited,omitempty"`
+
+		DefaultEnabled *bool        `yaml:"default_enabled" json:"default_enabled,omitempty"`
+		Groups         GroupsConfig `yaml:"groups" json:"groups"`
+	}
+
+	// GroupsConfig is the initial enablement of groups, private chats are written as negative user id,
+	// deny takes precedence over allow, other groups follow default_enabled which is true if not set
+	GroupsConfig struct {
+		Allow []int64 `yaml:"allow" json:"allow,omitempty"`
+		Deny  []int64 `yaml:"deny" json:"deny,omitempty"`
+	}
+
+	ReplyConfig struct {
+		Text   string   `yaml:"text" json:"text,omitempty"`
+		Image  string   `yaml:"image" json:"image,omitempty"`
+		Random []string `yaml:"random" json:"random,omitempty"`
 	}
 
 	MiddlewareConfig struct {
@@ -60,42 +226,76 @@ type (
 	}
 
 	TriggerConfig struct {
-		FullMatches []string `yaml:"full_matches" json:"full_matches,omitempty"`
-		KeyWords    []string `yaml:"key_words" json:"key_words,omitempty"`
-		Commands    []string `yaml:"commands" json:"commands,omitempty"`
-		Prefixes    []string `yaml:"prefixes" json:"prefixes,omitempty"`
-		Suffixes    []string `yaml:"suffixes" json:"suffixes,omitempty"`
-		Regexes     []string `yaml:"regexes" json:"regexes,omitempty"`
-		Notice      bool     `yaml:"notice" json:"notice,omitempty"`
+		FullMatches []string     `yaml:"full_matches" json:"full_matches,omitempty"`
+		KeyWords    []string     `yaml:"key_words" json:"key_words,omitempty"`
+		Commands    []string     `yaml:"commands" json:"commands,omitempty"`
+		Prefixes    []string     `yaml:"prefixes" json:"prefixes,omitempty"`
+		Suffixes    []string     `yaml:"suffixes" json:"suffixes,omitempty"`
+		Regexes     []string     `yaml:"regexes" json:"regexes,omitempty"`
+		Notice      bool         `yaml:"notice" json:"notice,omitempty"`
+		Notices     []string     `yaml:"notices" json:"notices,omitempty"`
+		Requests    []string     `yaml:"requests" json:"requests,omitempty"`
+		Metas       []string     `yaml:"metas" json:"metas,omitempty"`
+		Segments    []string     `yaml:"segments" json:"segments,omitempty"`
+		Fuzzy       *FuzzyConfig `yaml:"fuzzy" json:"fuzzy,omitempty"`
+	}
+
+	// FuzzyConfig match full matches, commands and aliases within the edit distance of threshold,
+	// aliases always match after normalization, suggest is the edit distance to reply "did you mean",
+	// built-in normalizers are lower, width, space, simplified and pinyin
+	FuzzyConfig struct {
+		Aliases     []string `yaml:"aliases" json:"aliases,omitempty"`
+		Threshold   int      `yaml:"threshold" json:"threshold,omitempty"`
+		Normalizers []string `yaml:"normalizers" json:"normalizers,omitempty"`
+		Suggest     int      `yaml:"suggest" json:"suggest,omitempty"`
 	}
 )
 
 func loadConfig(metadata *PluginConfig) {
+	receiver, content, decodeErr := decodeConfig(metadata)
+	if decodeErr != nil {
+		panic(decodeErr.Error())
+	}
+
+	if applyErr := decodeInto(content, receiver); applyErr != nil {
+		panic("failed to decode config file of " + metadata.Name + ": " + applyErr.Error())
+	}
+}
+
+// decodeConfig read config file of plugin and check it decodes into a new value of its receiver type,
+// the receiver is not modified, so the caller can decode the content into it after all plugins are checked
+func decodeConfig(metadata *PluginConfig) (receiver any, content []byte, err error) {
 	// get config file path
 	filename := shortcut.Ternary(filepath.Ext(metadata.ConfigFile) == "", metadata.ConfigFile+".yaml", metadata.ConfigFile)
 	path := filepath.Join("./", "config", filename)
 
 	// check file exist
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		panic("config file not found: " + path)
+	if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+		return nil, nil, errors.New("config file not found: " + path)
 	}
 
 	// check receiver exist
 	pluginConfig, existConfig := plugins.Get(metadata.Name)
-	if !existConfig || pluginConfig == nil || pluginConfig.config == nil {
-		panic("config receiver not found: " + metadata.Name)
+	if !existConfig || pluginConfig == nil || pluginConfig.config == nil || reflect.TypeOf(pluginConfig.config).Kind() != reflect.Pointer {
+		return nil, nil, errors.New("config receiver not found: " + metadata.Name)
 	}
 
 	// Load config file
-	file, openErr := os.OpenFile(path, os.O_RDONLY, 0o644)
-	if openErr != nil {
-		panic("failed to open config file: " + path)
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, nil, errors.New("failed to open config file: " + path)
 	}
-	defer file.Close()
 
 	// unmarshal config file
-	decodeErr := yaml.NewDecoder(file).Decode(pluginConfig.config)
-	if decodeErr != nil {
-		panic("failed to decode config file: " + path)
+	checked := reflect.New(reflect.TypeOf(pluginConfig.config).Elem())
+	if decodeErr := decodeInto(content, checked.Interface()); decodeErr != nil {
+		return nil, nil, errors.New("failed to decode config file: " + path)
 	}
+
+	return pluginConfig.config, content, nil
+}
+
+// decodeInto decode the content of config file into receiver, fields missing in content keep their values
+func decodeInto(content []byte, receiver any) error {
+	return yaml.NewDecoder(bytes.NewReader(content)).Decode(receiver)
 }
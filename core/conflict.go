@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	ConflictDuplicate = "duplicate"
+	ConflictOverlap   = "overlap"
+	ConflictRegex     = "regex"
+)
+
+// TriggerConflict is a message which can be matched by triggers of two handlers,
+// the winner is bound first, the loser still handles the message unless the winner is blocked
+type TriggerConflict struct {
+	Kind     string `json:"kind"`
+	Trigger  string `json:"trigger"`
+	Winner   string `json:"winner"`
+	Loser    string `json:"loser"`
+	Shadowed bool   `json:"shadowed"`
+}
+
+func (c TriggerConflict) String() string {
+	result := fmt.Sprintf("%s trigger %q: %s wins over %s", c.Kind, c.Trigger, c.Winner, c.Loser)
+	if c.Shadowed {
+		result += ", loser never triggered because winner is blocked"
+	}
+
+	return result
+}
+
+type boundTrigger struct {
+	owner   string
+	blocked bool
+	kind    string
+	word    string
+	regex   *regexp.Regexp
+}
+
+// matches check if the trigger can match the text, commands are treated as prefixes like zero does
+func (t boundTrigger) matches(text string) bool {
+	switch t.kind {
+	case "full_match":
+		return text == t.word
+	case "prefix", "command":
+		return strings.HasPrefix(text, t.word)
+	case "suffix":
+		return strings.HasSuffix(text, t.word)
+	case "keyword":
+		return strings.Contains(text, t.word)
+	case "regex":
+		return t.regex != nil && t.regex.MatchString(text)
+	}
+
+	return false
+}
+
+// DetectConflicts find triggers overlapped between handlers, plugins must be sorted in binding order,
+// the trigger word itself is used as the sample message, so regex can only conflict with other kinds
+func DetectConflicts(plugins []PluginConfig, commandPrefix string) (conflicts []TriggerConflict) {
+	triggers := []boundTrigger{}
+	for _, plugin := range plugins {
+		for _, handler := range plugin.Handlers {
+			owner := plugin.Name + "/" + handler.Name
+			add := func(kind string, words []string, prefix string) {
+				for _, word := range words {
+					trigger := boundTrigger{owner: owner, blocked: handler.Blocked, kind: kind, word: prefix + word}
+					if kind == "regex" {
+						trigger.regex, _ = CompileRegex(word)
+					}
+					triggers = append(triggers, trigger)
+				}
+			}
+
+			add("full_match", handler.Triggers.FullMatches, "")
+			add("command", handler.Triggers.Commands, commandPrefix)
+			add("prefix", handler.Triggers.Prefixes, "")
+			add("suffix", handler.Triggers.Suffixes, "")
+			add("keyword", handler.Triggers.KeyWords, "")
+			add("regex", handler.Triggers.Regexes, "")
+		}
+	}
+
+	for i, winner := range triggers {
+		for _, loser := range triggers[i+1:] {
+			if winner.owner == loser.owner {
+				continue
+			}
+
+			conflict := TriggerConflict{Winner: winner.owner, Loser: loser.owner, Shadowed: winner.blocked}
+			switch {
+			case winner.kind == loser.kind && winner.word == loser.word:
+				conflict.Kind, conflict.Trigger = ConflictDuplicate, winner.word
+			case winner.kind == "regex" && loser.kind != "regex" && winner.matches(loser.word):
+				conflict.Kind, conflict.Trigger = ConflictRegex, loser.word
+			case loser.kind == "regex" && winner.kind != "regex" && loser.matches(winner.word):
+				conflict.Kind, conflict.Trigger = ConflictRegex, winner.word
+			case winner.kind != "regex" && loser.kind != "regex" && winner.matches(loser.word):
+				conflict.Kind, conflict.Trigger = ConflictOverlap, loser.word
+			case winner.kind != "regex" && loser.kind != "regex" && loser.matches(winner.word):
+				conflict.Kind, conflict.Trigger = ConflictOverlap, winner.word
+			default:
+				continue
+			}
+
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return conflicts
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	consoleURL           = "console"
+	consoleCommandPrefix = ":"
+	defaultConsoleSelfID = 10000
+)
+
+const consoleHelp = `:group <id>            send messages in group
+:private               send messages in private chat
+:user <id> [nickname]  send messages as user
+:role <role>           set role of user in group, such as owner, admin or member
+:help                  show this help
+other lines are sent to bot as message, CQ code is supported
+`
+
+// ConsoleDriver is a driver of zero reading messages from terminal, lines are sent to bot as the fake user,
+// and messages sent by bot are printed, it is used to try plugins without any chat platform
+type ConsoleDriver struct {
+	SelfID   int64
+	GroupID  int64
+	UserID   int64
+	Nickname string
+	Role     string
+
+	in        io.Reader
+	out       io.Writer
+	mu        sync.Mutex
+	messageID int64
+}
+
+// NewConsoleDriverWithConfig create a console driver reading stdin and writing stdout
+func NewConsoleDriverWithConfig(config WebsocketConfig) *ConsoleDriver {
+	return NewConsoleDriver(config.Console, os.Stdin, os.Stdout)
+}
+
+// NewConsoleDriver create a console driver on in and out, missing fields of config are filled with defaults
+func NewConsoleDriver(config ConsoleConfig, in io.Reader, out io.Writer) *ConsoleDriver {
+	driver := &ConsoleDriver{
+		SelfID:   config.SelfID,
+		GroupID:  config.GroupID,
+		UserID:   config.UserID,
+		Nickname: config.Nickname,
+		Role:     config.Role,
+		in:       in,
+		out:      out,
+	}
+	if driver.SelfID == 0 {
+		driver.SelfID = defaultConsoleSelfID
+	}
+	if driver.UserID == 0 {
+		driver.UserID = 1
+	}
+	if driver.Nickname == "" {
+		driver.Nickname = "user"
+	}
+	if driver.Role == "" {
+		driver.Role = "member"
+	}
+
+	return driver
+}
+
+// Connect register the bot as api caller, the console is always connected
+func (c *ConsoleDriver) Connect() {
+	zero.APICallers.Store(c.SelfID, c)
+	changeLifecycle(LifecycleEvent{Type: LifecycleConnect, URL: consoleURL, SelfID: c.SelfID, Time: time.Now()})
+}
+
+// Listen read lines until input ends, lines starting with colon are commands switching the user and chat
+func (c *ConsoleDriver) Listen(handler func([]byte, zero.APICaller)) {
+	c.print("type :help for commands\n")
+
+	scanner := bufio.NewScanner(c.in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, consoleCommandPrefix):
+			c.mu.Lock()
+			commandErr := c.command(strings.Fields(strings.TrimPrefix(line, consoleCommandPrefix)))
+			c.mu.Unlock()
+			if commandErr != nil {
+				c.print("error: " + commandErr.Error() + "\n")
+			}
+		default:
+			handler(c.event(line), c)
+		}
+	}
+
+	changeLifecycle(LifecycleEvent{Type: LifecycleDisconnect, URL: consoleURL, SelfID: c.SelfID, Time: time.Now()})
+}
+
+// CallApi print messages sent by bot and answer queries with the fake user, other actions are printed and succeed
+func (c *ConsoleDriver) CallApi(request zero.APIRequest) (zero.APIResponse, error) {
+	params, _ := json.Marshal(request.Params)
+	parsed := gjson.ParseBytes(params)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch request.Action {
+	case "send_msg", "send_group_msg", "send_private_msg":
+		target := "user " + parsed.Get("user_id").String()
+		if parsed.Get("group_id").Int() != 0 && parsed.Get("message_type").Str != "private" {
+			target = "group " + parsed.Get("group_id").String()
+		}
+		_, _ = fmt.Fprintf(c.out, "bot -> %s: %s\n", target, renderConsole(parsed.Get("message")))
+
+		c.messageID++
+		return consoleResponse(map[string]any{"message_id": c.messageID}), nil
+	case "get_login_info":
+		return consoleResponse(map[string]any{"user_id": c.SelfID, "nickname": "bot"}), nil
+	case "get_stranger_info", "get_group_member_info":
+		return consoleResponse(map[string]any{"user_id": parsed.Get("user_id").Int(), "nickname": c.Nickname, "role": c.Role}), nil
+	default:
+		_, _ = fmt.Fprintf(c.out, "bot called %s %s\n", request.Action, parsed.Raw)
+		return consoleResponse(nil), nil
+	}
+}
+
+// event build message event of the line sent by current user
+func (c *ConsoleDriver) event(line string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messageID++
+	event := map[string]any{
+		"time":         time.Now().Unix(),
+		"self_id":      c.SelfID,
+		"post_type":    "message",
+		"message_type": "group",
+		"sub_type":     "normal",
+		"message_id":   c.messageID,
+		"group_id":     c.GroupID,
+		"user_id":      c.UserID,
+		"message":      line,
+		"raw_message":  line,
+		"font":         0,
+		"sender":       map[string]any{"user_id": c.UserID, "nickname": c.Nickname, "role": c.Role},
+	}
+	if c.GroupID == 0 {
+		event["message_type"], event["sub_type"] = "private", "friend"
+	}
+
+	encoded, _ := json.Marshal(event)
+	return encoded
+}
+
+// command run the console command, the caller holds the lock
+func (c *ConsoleDriver) command(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	switch fields[0] {
+	case "help":
+		_, _ = io.WriteString(c.out, consoleHelp)
+	case "private":
+		c.GroupID = 0
+	case "group":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: :group <id>")
+		}
+		groupID, parseErr := strconv.ParseInt(fields[1], 10, 64)
+		if parseErr != nil || groupID <= 0 {
+			return fmt.Errorf("invalid group id: %s", fields[1])
+		}
+		c.GroupID = groupID
+	case "user":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: :user <id> [nickname]")
+		}
+		userID, parseErr := strconv.ParseInt(fields[1], 10, 64)
+		if parseErr != nil || userID <= 0 {
+			return fmt.Errorf("invalid user id: %s", fields[1])
+		}
+		c.UserID, c.Nickname = userID, "user"
+		if len(fields) > 2 {
+			c.Nickname = strings.Join(fields[2:], " ")
+		}
+	case "role":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: :role <role>")
+		}
+		c.Role = fields[1]
+	default:
+		return fmt.Errorf("unknown command %s, type :help for commands", fields[0])
+	}
+
+	return nil
+}
+
+func (c *ConsoleDriver) print(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, _ = io.WriteString(c.out, text)
+}
+
+// renderConsole get readable text of message param, text is kept as is, images are shown as their files
+// and other segments are shown as CQ code
+func renderConsole(param gjson.Result) string {
+	var msg message.Message
+	if param.IsObject() {
+		msg = message.ParseMessageFromArray(gjson.Parse("[" + param.Raw + "]"))
+	} else {
+		msg = message.ParseMessage([]byte(param.Raw))
+	}
+
+	sb := strings.Builder{}
+	for _, segment := range msg {
+		switch segment.Type {
+		case "text":
+			sb.WriteString(segment.Data["text"])
+		case "image":
+			sb.WriteString("[image: " + segment.Data["file"] + "]")
+		default:
+			sb.WriteString(segment.String())
+		}
+	}
+
+	return sb.String()
+}
+
+func consoleResponse(data any) zero.APIResponse {
+	encoded, _ := json.Marshal(data)
+	return zero.APIResponse{Status: "ok", Data: gjson.ParseBytes(encoded)}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"slices"
+
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// segmentToMe is the pseudo segment type of messages sent to bot, zero removes the segment of @bot
+// from message, so it is judged by the to me flag instead
+const segmentToMe = "at_me"
+
+// NoticeRule match notice events, types can be notice type, sub type or both joined by dot,
+// such as group_increase, poke or notify.poke
+func NoticeRule(types ...string) zero.Rule {
+	return func(ctx *zero.Ctx) bool {
+		return matchEventType(types, ctx.Event.NoticeType, ctx.Event.SubType)
+	}
+}
+
+// RequestRule match request events, types can be request type, sub type or both joined by dot,
+// such as friend, group or group.invite
+func RequestRule(types ...string) zero.Rule {
+	return func(ctx *zero.Ctx) bool {
+		return matchEventType(types, ctx.Event.RequestType, ctx.Event.SubType)
+	}
+}
+
+// MetaRule match meta events, types can be meta event type, sub type or both joined by dot,
+// such as heartbeat, lifecycle or lifecycle.connect
+func MetaRule(types ...string) zero.Rule {
+	return func(ctx *zero.Ctx) bool {
+		return matchEventType(types, ctx.Event.RawEvent.Get("meta_event_type").String(), ctx.Event.SubType)
+	}
+}
+
+// SegmentRule match messages containing any of the segment types, such as image, reply, at or at_me
+func SegmentRule(types ...string) zero.Rule {
+	return func(ctx *zero.Ctx) bool {
+		if ctx.Event.IsToMe && ctx.Event.DetailType == "group" && slices.Contains(types, segmentToMe) {
+			return true
+		}
+
+		return slices.ContainsFunc(ctx.Event.Message, func(segment message.MessageSegment) bool {
+			return slices.Contains(types, segment.Type)
+		})
+	}
+}
+
+func matchEventType(types []string, primary, sub string) bool {
+	for _, expected := range types {
+		if expected == primary || (sub != "" && (expected == sub || expected == primary+"."+sub)) {
+			return true
+		}
+	}
+
+	return false
+}
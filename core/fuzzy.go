@@ -0,0 +1,242 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/FloatTech/ttl"
+	"github.com/longbridgeapp/opencc"
+	"github.com/mozillazg/go-pinyin"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// simplifiedConverter load the dictionaries of traditional to simplified conversion on first use
+var simplifiedConverter = sync.OnceValues(func() (*opencc.OpenCC, error) { return opencc.New("t2s") })
+
+const (
+	suggestionStateKey = "fuzzy_suggestion"
+
+	// suggestions are only made for words at least minSuggestLength runes, and the distance is at most
+	// maxSuggestRatio of the word length, so short messages are not answered with unrelated words
+	minSuggestLength = 2
+	maxSuggestRatio  = 0.5
+)
+
+// handledEvents record events handled by bound handlers, state of ctx is cleared between matchers,
+// so the event pointer shared by all matchers is used as key
+var handledEvents = ttl.NewCache[*zero.Event, bool](time.Minute)
+
+// MarkHandled wrap the handler, mark the event as handled when it is called
+func MarkHandled(handler func(*zero.Ctx)) func(*zero.Ctx) {
+	return func(ctx *zero.Ctx) {
+		handledEvents.Set(ctx.Event, true)
+		handler(ctx)
+	}
+}
+
+// FuzzyRule match messages close to full matches, commands or aliases of triggers, messages matched exactly
+// are left to the exact triggers, so the handler will not be called twice, commands only match messages
+// starting with the command prefix, so chat text is not taken as a command
+func FuzzyRule(triggers TriggerConfig, commandPrefix string) zero.Rule {
+	normalize := normalizerOf(triggers.Fuzzy.Normalizers)
+	return func(ctx *zero.Ctx) bool {
+		text := strings.TrimSpace(ctx.ExtractPlainText())
+		head, args, _ := strings.Cut(text, " ")
+		if text == "" || handledEvents.Get(ctx.Event) || exactlyMatched(triggers, commandPrefix, text) {
+			return false
+		}
+
+		for _, word := range append(triggers.FullMatches, triggers.Fuzzy.Aliases...) {
+			if editDistance(normalize(text), normalize(word)) <= triggers.Fuzzy.Threshold {
+				ctx.State["fuzzy_matched"] = word
+				return true
+			}
+		}
+		name, prefixed := strings.CutPrefix(normalize(head), normalize(commandPrefix))
+		for _, command := range triggers.Commands {
+			if prefixed && editDistance(name, normalize(command)) <= triggers.Fuzzy.Threshold {
+				ctx.State["fuzzy_matched"], ctx.State["command"], ctx.State["args"] = command, command, strings.TrimSpace(args)
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// SuggestTarget is the triggers of a handler can be suggested, enabled reports whether the handler
+// is available in the chat of event, nil means always available
+type SuggestTarget struct {
+	Triggers TriggerConfig
+	Enabled  zero.Rule
+}
+
+// SuggestRule match messages not handled by any bound handler but close to a trigger word of handlers
+// available in the chat, the closest word is stored in state and can be got by Suggestion
+func SuggestRule(targets []SuggestTarget, commandPrefix string) zero.Rule {
+	return func(ctx *zero.Ctx) bool {
+		if handledEvents.Get(ctx.Event) {
+			return false
+		}
+
+		text := strings.TrimSpace(ctx.ExtractPlainText())
+		head, _, _ := strings.Cut(text, " ")
+		best, bestDistance := "", -1
+		for _, target := range targets {
+			trigger := target.Triggers
+			if trigger.Fuzzy == nil || trigger.Fuzzy.Suggest <= 0 || (target.Enabled != nil && !target.Enabled(ctx)) {
+				continue
+			}
+
+			// candidates map the word to compare to the input, commands are compared without prefix,
+			// and only suggested for input with prefix
+			normalize := normalizerOf(trigger.Fuzzy.Normalizers)
+			name, prefixed := strings.CutPrefix(normalize(head), normalize(commandPrefix))
+			candidates := map[string][2]string{}
+			for _, word := range append(trigger.FullMatches, trigger.Fuzzy.Aliases...) {
+				candidates[word] = [2]string{word, text}
+			}
+			for _, command := range trigger.Commands {
+				if prefixed {
+					candidates[commandPrefix+command] = [2]string{command, name}
+				}
+			}
+			for suggestion, candidate := range candidates {
+				word, input := normalize(candidate[0]), normalize(candidate[1])
+				length := utf8.RuneCountInString(word)
+				if length < minSuggestLength || utf8.RuneCountInString(input) < minSuggestLength {
+					continue
+				}
+
+				distance := editDistance(input, word)
+				if distance > 0 && distance <= trigger.Fuzzy.Suggest && float64(distance) <= maxSuggestRatio*float64(length) &&
+					(bestDistance < 0 || distance < bestDistance) {
+					best, bestDistance = suggestion, distance
+				}
+			}
+		}
+
+		if bestDistance < 0 {
+			return false
+		}
+
+		ctx.State[suggestionStateKey] = best
+		return true
+	}
+}
+
+// Suggestion get the trigger word suggested by SuggestRule
+func Suggestion(ctx *zero.Ctx) string {
+	suggestion, _ := ctx.State[suggestionStateKey].(string)
+	return suggestion
+}
+
+// exactlyMatched report whether the exact triggers match the text, commands are matched by prefix
+// the same as the command rule of zero, so "/签到1" is left to the command 签到
+func exactlyMatched(triggers TriggerConfig, commandPrefix, text string) bool {
+	for _, word := range triggers.FullMatches {
+		if text == word {
+			return true
+		}
+	}
+	for _, command := range triggers.Commands {
+		if strings.HasPrefix(text, commandPrefix+command) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizerOf chain the registered normalizers, not registered normalizers are ignored
+func normalizerOf(names []string) func(string) string {
+	chain := []func(string) string{}
+	for _, name := range names {
+		if normalizer, exist := normalizers.Get(name); exist && normalizer != nil {
+			chain = append(chain, normalizer)
+		}
+	}
+
+	return func(text string) string {
+		for _, normalizer := range chain {
+			text = normalizer(text)
+		}
+
+		return text
+	}
+}
+
+// editDistance is the levenshtein distance counted by runes
+func editDistance(a, b string) int {
+	source, target := []rune(a), []rune(b)
+	previous, current := make([]int, len(target)+1), make([]int, len(target)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(source); i++ {
+		current[0] = i
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(target)]
+}
+
+// normalizeWidth convert full width characters to half width
+func normalizeWidth(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '　':
+			return ' '
+		case r >= '！' && r <= '～':
+			return r - 0xfee0
+		}
+
+		return r
+	}, text)
+}
+
+// normalizeSpace remove all spaces
+func normalizeSpace(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+
+		return r
+	}, text)
+}
+
+// normalizeSimplified convert traditional chinese to simplified chinese, text is kept if dictionaries failed to load
+func normalizeSimplified(text string) string {
+	converter, loadErr := simplifiedConverter()
+	if loadErr != nil {
+		return text
+	}
+
+	converted, convertErr := converter.Convert(text)
+	if convertErr != nil {
+		return text
+	}
+
+	return converted
+}
+
+// normalizePinyin convert chinese characters to pinyin without tones, other characters are kept,
+// so words with the same pronunciation are matched, such as 签到 and 签道
+func normalizePinyin(text string) string {
+	args := pinyin.NewArgs()
+	args.Fallback = func(r rune, _ pinyin.Args) []string { return []string{string(r)} }
+
+	return strings.Join(pinyin.LazyPinyin(text, args), "")
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"slices"
+
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// EnabledIn check if the group is enabled by the config, gid is negative user id for private chat
+func (g GroupsConfig) EnabledIn(gid int64, defaultEnabled *bool) bool {
+	switch {
+	case slices.Contains(g.Deny, gid):
+		return false
+	case slices.Contains(g.Allow, gid):
+		return true
+	}
+
+	return defaultEnabled == nil || *defaultEnabled
+}
+
+// Listed get all groups written in allow or deny
+func (g GroupsConfig) Listed() []int64 {
+	return append(slices.Clone(g.Allow), g.Deny...)
+}
+
+// HandlerEnabledRule match events from groups enabled for the handler, the config is looked up at every event,
+// so it is kept in sync with reloaded config, handler not found in config is always enabled
+func HandlerEnabledRule(plugin, handler string) zero.Rule {
+	return func(ctx *zero.Ctx) bool {
+		config, exist := PluginConfigOf(plugin)
+		if !exist {
+			return true
+		}
+
+		gid := ctx.Event.GroupID
+		if gid == 0 {
+			gid = -ctx.Event.UserID
+		}
+		for _, item := range config.Handlers {
+			if item.Name == handler {
+				return item.Groups.EnabledIn(gid, item.DefaultEnabled)
+			}
+		}
+
+		return true
+	}
+}
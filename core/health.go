@@ -0,0 +1,122 @@
+package core
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/infrastructure/logger"
+)
+
+const (
+	LifecycleConnect    = "connect"
+	LifecycleDisconnect = "disconnect"
+	LifecycleGiveUp     = "give_up"
+)
+
+var (
+	healthMu sync.Mutex
+	healths  = map[string]*BotHealth{}
+)
+
+// LifecycleEvent is the connection change of bot, self id is 0 if the bot never connected,
+// attempt is the count of failed reconnections before the event
+type LifecycleEvent struct {
+	Type    string    `json:"type"`
+	URL     string    `json:"url"`
+	SelfID  int64     `json:"self_id,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// BotHealth is the connection status of a onebot server
+type BotHealth struct {
+	URL            string    `json:"url"`
+	SelfID         int64     `json:"self_id,omitempty"`
+	Connected      bool      `json:"connected"`
+	ConnectedAt    time.Time `json:"connected_at,omitempty"`
+	DisconnectedAt time.Time `json:"disconnected_at,omitempty"`
+	LastHeartbeat  time.Time `json:"last_heartbeat,omitempty"`
+	Reconnects     int       `json:"reconnects"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+// HealthStatus is the connection status of all onebot servers
+type HealthStatus struct {
+	Connected int         `json:"connected"`
+	Bots      []BotHealth `json:"bots"`
+}
+
+// Health get the connection status of bots, sorted by url
+func Health() HealthStatus {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	status := HealthStatus{Bots: make([]BotHealth, 0, len(healths))}
+	for _, health := range healths {
+		status.Bots = append(status.Bots, *health)
+		if health.Connected {
+			status.Connected++
+		}
+	}
+	slices.SortFunc(status.Bots, func(a, b BotHealth) int {
+		switch {
+		case a.URL < b.URL:
+			return -1
+		case a.URL > b.URL:
+			return 1
+		}
+		return 0
+	})
+
+	return status
+}
+
+// heartbeat record the heartbeat of the server
+func heartbeat(url string, now time.Time) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	healthOf(url).LastHeartbeat = now
+}
+
+// changeLifecycle update the health and dispatch the event to lifecycle hooks of enabled plugins
+func changeLifecycle(event LifecycleEvent) {
+	healthMu.Lock()
+	health := healthOf(event.URL)
+	switch event.Type {
+	case LifecycleConnect:
+		if !health.ConnectedAt.IsZero() {
+			health.Reconnects++
+		}
+		health.SelfID, health.Connected, health.ConnectedAt, health.LastError = event.SelfID, true, event.Time, ""
+	case LifecycleDisconnect, LifecycleGiveUp:
+		if health.Connected {
+			health.DisconnectedAt = event.Time
+		}
+		health.Connected, health.LastError = false, event.Error
+	}
+	healthMu.Unlock()
+
+	if coreLogger != nil {
+		coreLogger.Info(logger.NewFields().WithMessage("bot connection changed").WithData(event))
+	}
+
+	for _, plugin := range Plugins() {
+		if opts, exist := plugins.Get(plugin.Name); plugin.Enable && exist && opts != nil {
+			opts.Lifecycle(event)
+		}
+	}
+}
+
+// healthOf get health of the server, the caller holds the lock
+func healthOf(url string) *BotHealth {
+	health, exist := healths[url]
+	if !exist {
+		health = &BotHealth{URL: url}
+		healths[url] = health
+	}
+
+	return health
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/infrastructure/logger"
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	defaultHTTPTimeout = 30 * time.Second
+	maxEventSize       = 16 << 20
+)
+
+// HTTPDriver is a driver of zero using onebot http api and http post, events are posted to the local endpoint
+// and checked by hmac signature, api calls are sent to the api url
+type HTTPDriver struct {
+	API         string
+	Addr        string
+	Path        string
+	Secret      string
+	AccessToken string
+	Header      http.Header
+	Reconnect   ReconnectConfig
+
+	client  *http.Client
+	mu      sync.Mutex
+	handler func([]byte, zero.APICaller)
+	server  *http.Server
+}
+
+// NewHTTPDriverWithConfig create a http driver from config, tls options and headers apply to api calls
+func NewHTTPDriverWithConfig(config WebsocketConfig) (*HTTPDriver, error) {
+	if config.HTTP.API == "" || config.HTTP.Listen == "" {
+		return nil, errors.New("api and listen are required in http mode")
+	}
+	tlsConfig, tlsErr := config.TLS.build()
+	if tlsErr != nil {
+		return nil, tlsErr
+	}
+
+	timeout := config.HTTP.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	driver := &HTTPDriver{
+		API:         strings.TrimSuffix(config.HTTP.API, "/"),
+		Addr:        config.HTTP.Listen,
+		Path:        config.HTTP.Path,
+		Secret:      config.HTTP.Secret,
+		AccessToken: config.AccessToken,
+		Header:      http.Header{},
+		Reconnect:   config.Reconnect,
+		client:      &http.Client{Timeout: timeout, Transport: transport},
+	}
+	for key, value := range config.Headers {
+		driver.Header.Set(key, value)
+	}
+
+	return driver, nil
+}
+
+// Connect get the account of bot from api until succeeded or max attempts reached,
+// the bot is registered as api caller once the account is known
+func (d *HTTPDriver) Connect() {
+	for failed := 0; ; failed++ {
+		if failed > 0 {
+			if d.Reconnect.MaxAttempts > 0 && failed >= d.Reconnect.MaxAttempts {
+				changeLifecycle(LifecycleEvent{Type: LifecycleGiveUp, URL: d.API, Attempt: failed, Time: time.Now()})
+				return
+			}
+			time.Sleep(d.Reconnect.backoff(failed, rand.Float64()))
+		}
+
+		response, callErr := d.CallApi(zero.APIRequest{Action: "get_login_info"})
+		if callErr == nil && response.RetCode != 0 {
+			callErr = fmt.Errorf("get_login_info failed: %d %s", response.RetCode, response.Msg)
+		}
+		if callErr != nil {
+			healthMu.Lock()
+			healthOf(d.API).LastError = callErr.Error()
+			healthMu.Unlock()
+			continue
+		}
+
+		selfID := response.Data.Get("user_id").Int()
+		zero.APICallers.Store(selfID, d)
+		changeLifecycle(LifecycleEvent{Type: LifecycleConnect, URL: d.API, SelfID: selfID, Attempt: failed, Time: time.Now()})
+		return
+	}
+}
+
+// Listen serve the http post endpoint on addr until Close is called
+func (d *HTTPDriver) Listen(handler func([]byte, zero.APICaller)) {
+	listener, listenErr := net.Listen("tcp", d.Addr)
+	if listenErr != nil {
+		coreLogger.Error(logger.NewFields().WithMessage("failed to listen http post endpoint").WithData(listenErr.Error()))
+		return
+	}
+
+	d.mu.Lock()
+	d.handler = handler
+	d.server = &http.Server{Handler: d, ReadHeaderTimeout: 10 * time.Second}
+	server := d.server
+	d.mu.Unlock()
+
+	if serveErr := server.Serve(listener); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		coreLogger.Error(logger.NewFields().WithMessage("http post endpoint stopped unexpectedly").WithData(serveErr.Error()))
+	}
+}
+
+// Close stop the http post endpoint
+func (d *HTTPDriver) Close() error {
+	d.mu.Lock()
+	server := d.server
+	d.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	return server.Shutdown(context.Background())
+}
+
+// ServeHTTP receive an event posted by onebot server, the body is signed by secret in X-Signature header,
+// quick operations in response are not supported, so the response is always empty
+func (d *HTTPDriver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || (d.Path != "" && r.URL.Path != d.Path) {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, readErr := io.ReadAll(io.LimitReader(r.Body, maxEventSize))
+	if readErr != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if !d.verify(body, r.Header.Get("X-Signature")) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	if gjson.GetBytes(body, "meta_event_type").Str == "heartbeat" {
+		heartbeat(d.API, time.Now())
+	}
+
+	d.mu.Lock()
+	handler := d.handler
+	d.mu.Unlock()
+	if handler != nil {
+		handler(body, d)
+	}
+}
+
+// CallApi post the params to api url of the action
+func (d *HTTPDriver) CallApi(request zero.APIRequest) (zero.APIResponse, error) {
+	if request.Params == nil {
+		request.Params = zero.Params{}
+	}
+	params, encodeErr := json.Marshal(request.Params)
+	if encodeErr != nil {
+		return zero.APIResponse{}, encodeErr
+	}
+
+	httpRequest, buildErr := http.NewRequest(http.MethodPost, d.API+"/"+request.Action, bytes.NewReader(params))
+	if buildErr != nil {
+		return zero.APIResponse{}, buildErr
+	}
+	for key, values := range d.Header {
+		httpRequest.Header[key] = values
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if d.AccessToken != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+d.AccessToken)
+	}
+
+	httpResponse, callErr := d.client.Do(httpRequest)
+	if callErr != nil {
+		return zero.APIResponse{}, callErr
+	}
+	defer func() { _ = httpResponse.Body.Close() }()
+
+	body, readErr := io.ReadAll(httpResponse.Body)
+	if readErr != nil {
+		return zero.APIResponse{}, readErr
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		return zero.APIResponse{}, fmt.Errorf("onebot http api responded %s", httpResponse.Status)
+	}
+
+	result := gjson.ParseBytes(body)
+	return zero.APIResponse{
+		Status:  result.Get("status").String(),
+		Data:    result.Get("data"),
+		Msg:     result.Get("msg").Str,
+		Wording: result.Get("wording").Str,
+		RetCode: result.Get("retcode").Int(),
+	}, nil
+}
+
+// verify check the hmac sha1 signature of body, empty secret accepts every request
+func (d *HTTPDriver) verify(body []byte, signature string) bool {
+	if d.Secret == "" {
+		return true
+	}
+
+	given, _ := strings.CutPrefix(signature, "sha1=")
+	mac := hmac.New(sha1.New, []byte(d.Secret))
+	mac.Write(body)
+
+	return hmac.Equal([]byte(given), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FloatTech/ttl"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/extension/rate"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	LimitedSilent   = "silent"
+	LimitedReply    = "reply"
+	LimitedCooldown = "cooldown"
+
+	// defaultLimitedWindow is the anti-spam window of notices, it is the token interval of built-in limiters
+	defaultLimitedWindow = builtinLimiterInterval
+)
+
+// ResolveOnLimited get the feedback config of handler, handler config takes precedence over limiter config,
+// nil means silent
+func ResolveOnLimited(handler HandlerConfig) *OnLimitedConfig {
+	if handler.OnLimited != nil {
+		return handler.OnLimited
+	}
+	if config, exist := coreConfig.Bot.OnLimited[handler.Limiter]; exist {
+		return &config
+	}
+
+	return nil
+}
+
+// LimitedNotifier create the function called when limiter denies a request, the notice is sent at most once
+// per window for each user in each group, so the notice itself will not flood the chat, notices are only sent
+// for message events, other events have no chat to reply, interval is the time a token of limiter refills,
+// it estimates the cooldown of limiters not declared in config, 0 means unknown
+func LimitedNotifier(config *OnLimitedConfig, limiter func(*zero.Ctx) *rate.Limiter, interval time.Duration) func(*zero.Ctx) {
+	if config == nil || config.Mode == "" || config.Mode == LimitedSilent {
+		return func(*zero.Ctx) {}
+	}
+
+	window := config.Window
+	if window <= 0 {
+		window = defaultLimitedWindow
+	}
+
+	notified := ttl.NewCache[string, bool](window)
+	return func(ctx *zero.Ctx) {
+		if ctx.Event.PostType != "message" {
+			return
+		}
+
+		key := strconv.FormatInt(ctx.Event.GroupID, 10) + "/" + strconv.FormatInt(ctx.Event.UserID, 10)
+		if notified.Get(key) {
+			return
+		}
+		notified.Set(key, true)
+
+		// limiters declared in config know the exact time to retry, no need to estimate
+		cooldown, exact := ctx.State[retryAfterStateKey].(time.Duration)
+		if !exact {
+			cooldown = cooldownOf(limiter(ctx), interval)
+		}
+
+		ctx.Send(message.Text(limitedText(config, cooldown.Round(time.Second))))
+	}
+}
+
+func limitedText(config *OnLimitedConfig, cooldown time.Duration) string {
+	text := config.Text
+	switch {
+	case text == "" && config.Mode == LimitedCooldown:
+		text = "too many requests, please retry after {cooldown}"
+	case text == "":
+		text = "too many requests, please slow down"
+	}
+
+	return strings.ReplaceAll(text, "{cooldown}", cooldown.String())
+}
+
+// cooldownOf estimate the time until next token by the token interval of limiter, the limiter is advanced
+// when the request is denied, so the tokens are up to date
+func cooldownOf(limiter *rate.Limiter, interval time.Duration) time.Duration {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	missing := 1 - limiter.Tokens()
+	if missing <= 0 || interval <= 0 {
+		return 0
+	}
+
+	return max(time.Duration(missing*float64(interval)).Round(time.Second), time.Second)
+}
@@ -0,0 +1,390 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/infrastructure/exit"
+	"github.com/alioth-center/infrastructure/logger"
+	"github.com/alioth-center/infrastructure/utils/concurrency"
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/extension/rate"
+)
+
+const (
+	LimitByUser      = "user"
+	LimitByGroup     = "group"
+	LimitByUserGroup = "user_group"
+
+	LimiterBackendMemory = "memory"
+	LimiterBackendFile   = "file"
+
+	retryAfterStateKey = "limiter_retry_after"
+
+	// limiterFlushInterval is how often the file backend writes changed states to storage
+	limiterFlushInterval = time.Second
+
+	// builtinLimiterInterval is the token interval of built-in user and group limiters of zbputils
+	builtinLimiterInterval = 10 * time.Second
+)
+
+var (
+	limiterBackends = concurrency.NewMap[string, LimiterBackend]()
+	limiterFileOnce sync.Once
+
+	// configLimiters is the names of limiters registered from config, reload registers only new ones
+	configLimiters = concurrency.NewMap[string, bool]()
+)
+
+// LimiterBackend store the state of limiters, so limits can survive restarting or be shared between bots
+type LimiterBackend interface {
+	// Take consume one request of key, return if it is allowed and the time until next request is allowed
+	Take(key string, limit LimiterConfig, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+	// Remaining get the requests left of key without consuming
+	Remaining(key string, limit LimiterConfig, now time.Time) (remaining float64, err error)
+}
+
+// RegisterLimiterBackend register a limiter backend, it can be used by backend field of limiter config
+func RegisterLimiterBackend(name string, backend LimiterBackend) {
+	_, exist := limiterBackends.Get(name)
+	if exist {
+		// cannot rewrite backend, it will replace built-in backends
+		panic("limiter backend already exist")
+	}
+
+	limiterBackends.Set(name, backend)
+}
+
+// NewStorageLimiterBackend create a limiter backend on storage, use memory storage for in-memory limiter
+// and file storage for persistent limiter
+func NewStorageLimiterBackend(storage Storage) LimiterBackend {
+	return &storageLimiterBackend{storage: storage}
+}
+
+// newBufferedLimiterBackend create a storage limiter backend keeping changed states in memory, they are written
+// to storage once per interval instead of every request, states changed in the last interval are lost on crash
+func newBufferedLimiterBackend(storage Storage, interval time.Duration) LimiterBackend {
+	return &storageLimiterBackend{storage: storage, interval: interval, pending: map[string]pendingLimiterState{}}
+}
+
+// registerLimiters register limiters declared in config, so they can be used by limiter field of handlers,
+// limiters registered by previous config are skipped, they read the live config by name on every request
+func registerLimiters() {
+	limits := liveLimiters()
+	if checkErr := checkLimiters(limits); checkErr != nil {
+		panic(checkErr.Error())
+	}
+
+	for _, limit := range limits {
+		if declared, _ := configLimiters.Get(limit.Name); declared {
+			continue
+		}
+
+		RegisterLimiter(limit.Name, liveLimiter(limit.Name))
+		configLimiters.Set(limit.Name, true)
+	}
+}
+
+// checkLimiters validate limiters declared in config, the names must be unique and not taken by limiters
+// registered by code
+func checkLimiters(limits []LimiterConfig) error {
+	names := map[string]bool{}
+	for _, limit := range limits {
+		if validateErr := limit.validate(); validateErr != nil {
+			return fmt.Errorf("invalid limiter %s: %w", limit.Name, validateErr)
+		}
+
+		_, registered := limiters.Get(limit.Name)
+		declared, _ := configLimiters.Get(limit.Name)
+		if names[limit.Name] || (registered && !declared) {
+			return fmt.Errorf("limiter %s already exist", limit.Name)
+		}
+
+		names[limit.Name] = true
+	}
+
+	return nil
+}
+
+// liveLimiters get limiters section of live config
+func liveLimiters() []LimiterConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return coreConfig.Limiters
+}
+
+// declaredLimiter find the limiter declared in live config by name
+func declaredLimiter(name string) (LimiterConfig, bool) {
+	for _, limit := range liveLimiters() {
+		if limit.Name == name {
+			return limit, true
+		}
+	}
+
+	return LimiterConfig{}, false
+}
+
+// liveLimiter create limiter of the limiter declared in config, reloaded config applies to the next request,
+// and all requests are allowed once it is removed from config
+func liveLimiter(name string) func(*zero.Ctx) *rate.Limiter {
+	return func(ctx *zero.Ctx) *rate.Limiter {
+		limit, declared := declaredLimiter(name)
+		if !declared {
+			return rate.NewLimiter(time.Second, 1)
+		}
+
+		return NewLimiter(limit)(ctx)
+	}
+}
+
+// NewLimiter create limiter from config, the returned rate limiter only tells if the request is allowed,
+// the real state is kept in the backend, and the time to retry is stored in state for on_limited notice
+func NewLimiter(limit LimiterConfig) func(*zero.Ctx) *rate.Limiter {
+	return func(ctx *zero.Ctx) *rate.Limiter {
+		allowed, retryAfter, takeErr := openLimiterBackend(limit.Backend).Take(limiterKey(limit, ctx.Event), limit, time.Now())
+		if takeErr != nil {
+			// backend failure should not stop the bot from working
+			coreLogger.Info(logger.NewFields().WithMessage("failed to take from limiter backend").WithData(map[string]any{"limiter": limit.Name, "error": takeErr.Error()}))
+			allowed = true
+		}
+		if !allowed {
+			ctx.State[retryAfterStateKey] = retryAfter
+		}
+
+		return rate.NewLimiter(time.Second, shortcut.Ternary(allowed, 1, 0))
+	}
+}
+
+// LimiterRemaining get the requests left of a limiter declared in config without consuming,
+// return false if the limiter is not declared in config
+func LimiterRemaining(name string, uid, gid int64) (float64, bool) {
+	limit, declared := declaredLimiter(name)
+	if !declared {
+		return 0, false
+	}
+
+	key := limiterKey(limit, &zero.Event{UserID: uid, GroupID: gid})
+	remaining, peekErr := openLimiterBackend(limit.Backend).Remaining(key, limit, time.Now())
+	return remaining, peekErr == nil
+}
+
+// LimiterInterval get the token interval of built-in limiters and limiters declared in config,
+// return 0 for other limiters and quota limiters
+func LimiterInterval(name string) time.Duration {
+	if name == "user" || name == "group" {
+		return builtinLimiterInterval
+	}
+	if limit, declared := declaredLimiter(name); declared && limit.Quota <= 0 {
+		return limit.Interval
+	}
+
+	return 0
+}
+
+func (l LimiterConfig) validate() error {
+	switch {
+	case l.Name == "":
+		return fmt.Errorf("name is required")
+	case l.Quota <= 0 && (l.Interval <= 0 || l.Burst <= 0):
+		return fmt.Errorf("quota or interval and burst is required")
+	case l.By != "" && l.By != LimitByUser && l.By != LimitByGroup && l.By != LimitByUserGroup:
+		return fmt.Errorf("unknown scope: %s", l.By)
+	}
+
+	_, parseErr := l.resetOffset()
+	return parseErr
+}
+
+// resetOffset parse reset_at as the offset from midnight
+func (l LimiterConfig) resetOffset() (time.Duration, error) {
+	if l.ResetAt == "" {
+		return 0, nil
+	}
+
+	resetAt, parseErr := time.Parse("15:04", l.ResetAt)
+	if parseErr != nil {
+		return 0, fmt.Errorf("invalid reset_at %s, expected HH:MM", l.ResetAt)
+	}
+
+	return time.Duration(resetAt.Hour())*time.Hour + time.Duration(resetAt.Minute())*time.Minute, nil
+}
+
+// period get the quota period containing now in local time
+func (l LimiterConfig) period(now time.Time) (start, end time.Time) {
+	offset, _ := l.resetOffset()
+	local := now.Local()
+	start = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location()).Add(offset)
+	if start.After(local) {
+		start = start.AddDate(0, 0, -1)
+	}
+
+	return start, start.AddDate(0, 0, 1)
+}
+
+func limiterKey(limit LimiterConfig, event *zero.Event) string {
+	scope := strconv.FormatInt(event.UserID, 10)
+	switch limit.By {
+	case LimitByGroup:
+		scope = strconv.FormatInt(event.GroupID, 10)
+	case LimitByUserGroup:
+		scope = strconv.FormatInt(event.GroupID, 10) + "/" + scope
+	}
+
+	return "limiter/" + limit.Name + "/" + scope
+}
+
+// openLimiterBackend get the registered backend, file backend is opened at first use, default is memory
+func openLimiterBackend(name string) LimiterBackend {
+	if _, registered := limiterBackends.Get(name); name == LimiterBackendFile && !registered {
+		limiterFileOnce.Do(func() {
+			storage, openErr := NewFileStorage(filepath.Join("data", "limiter", "limiter.storage.db"))
+			if openErr != nil {
+				coreLogger.Info(logger.NewFields().WithMessage("failed to open limiter storage, fallback to memory").WithData(openErr.Error()))
+				storage = NewMemoryStorage()
+			}
+			limiterBackends.Set(LimiterBackendFile, newBufferedLimiterBackend(storage, limiterFlushInterval))
+			exit.Register(flushFileLimiter, "limiter flush")
+		})
+	}
+
+	if backend, exist := limiterBackends.Get(name); exist && backend != nil {
+		return backend
+	}
+	if backend, exist := limiterBackends.Get(LimiterBackendMemory); exist && backend != nil {
+		return backend
+	}
+
+	return NewStorageLimiterBackend(NewMemoryStorage())
+}
+
+// flushFileLimiter write pending states of file backend, it runs on exit, so quota used before restarting is kept
+func flushFileLimiter(_ string) string {
+	if backend, exist := limiterBackends.Get(LimiterBackendFile); exist {
+		if buffered, ok := backend.(*storageLimiterBackend); ok {
+			buffered.flush()
+		}
+	}
+
+	return "limiter states flushed"
+}
+
+// limiterState is the state of quota or token bucket, Since is the start of quota period or last refill
+type limiterState struct {
+	Used   int     `json:"used,omitempty"`
+	Tokens float64 `json:"tokens,omitempty"`
+	Since  int64   `json:"since"`
+}
+
+// pendingLimiterState is the state not written to storage yet by buffered backend
+type pendingLimiterState struct {
+	encoded  []byte
+	expireAt time.Time
+}
+
+// storageLimiterBackend write states to storage on every request, or once per interval if interval is set
+type storageLimiterBackend struct {
+	mu        sync.Mutex
+	storage   Storage
+	interval  time.Duration
+	pending   map[string]pendingLimiterState
+	scheduled bool
+}
+
+func (b *storageLimiterBackend) Take(key string, limit LimiterConfig, now time.Time) (bool, time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ttl := b.load(key, limit, now)
+	allowed, retryAfter := false, time.Duration(0)
+	if limit.Quota > 0 {
+		_, end := limit.period(now)
+		allowed, retryAfter = state.Used < limit.Quota, end.Sub(now)
+		if allowed {
+			state.Used++
+		}
+	} else {
+		allowed = state.Tokens >= 1
+		if allowed {
+			state.Tokens--
+		} else {
+			retryAfter = time.Duration((1 - state.Tokens) * float64(limit.Interval))
+		}
+	}
+
+	encoded, _ := json.Marshal(state)
+	if b.interval <= 0 {
+		return allowed, retryAfter, b.storage.SetWithTTL(key, encoded, ttl)
+	}
+
+	b.pending[key] = pendingLimiterState{encoded: encoded, expireAt: now.Add(ttl)}
+	if !b.scheduled {
+		b.scheduled = true
+		time.AfterFunc(b.interval, b.flush)
+	}
+
+	return allowed, retryAfter, nil
+}
+
+// flush write pending states to storage, states expired before flushing are dropped
+func (b *storageLimiterBackend) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for key, state := range b.pending {
+		if ttl := state.expireAt.Sub(now); ttl > 0 {
+			if setErr := b.storage.SetWithTTL(key, state.encoded, ttl); setErr != nil {
+				coreLogger.Error(logger.NewFields().WithMessage("failed to write limiter state").WithData(map[string]any{"key": key, "error": setErr.Error()}))
+			}
+		}
+	}
+
+	b.pending, b.scheduled = map[string]pendingLimiterState{}, false
+}
+
+func (b *storageLimiterBackend) Remaining(key string, limit LimiterConfig, now time.Time) (float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, _ := b.load(key, limit, now)
+	if limit.Quota > 0 {
+		return float64(limit.Quota - state.Used), nil
+	}
+
+	return state.Tokens, nil
+}
+
+// load get the state refreshed to now, expired quota period is reset and tokens are refilled,
+// the ttl is the time the state can be dropped
+func (b *storageLimiterBackend) load(key string, limit LimiterConfig, now time.Time) (state limiterState, ttl time.Duration) {
+	pending, exist := b.pending[key]
+	raw := pending.encoded
+	if !exist {
+		raw, exist = b.storage.Get(key)
+	}
+	if !exist || json.Unmarshal(raw, &state) != nil {
+		state = limiterState{Tokens: float64(limit.Burst), Since: now.UnixNano()}
+	}
+
+	if limit.Quota > 0 {
+		start, end := limit.period(now)
+		if state.Since < start.UnixNano() {
+			state = limiterState{Since: start.UnixNano()}
+		}
+
+		return state, end.Sub(now)
+	}
+
+	elapsed := now.Sub(time.Unix(0, state.Since))
+	state.Tokens = math.Min(float64(limit.Burst), state.Tokens+elapsed.Seconds()/limit.Interval.Seconds())
+	state.Since = now.UnixNano()
+
+	return state, limit.Interval * time.Duration(limit.Burst)
+}
@@ -6,22 +6,34 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/alioth-center/infrastructure/config"
 	"github.com/alioth-center/infrastructure/logger"
 	"github.com/alioth-center/infrastructure/trace"
 	"github.com/sirupsen/logrus"
 	zero "github.com/wdvxdr1123/ZeroBot"
-	"github.com/wdvxdr1123/ZeroBot/driver"
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	botConfigPath = "./config/bot.yaml"
+
+	ModeWebsocket = "websocket"
+	ModeHTTP      = "http"
+	ModeConsole   = "console"
+)
+
 var (
 	coreLogger logger.Logger
 	tempLogger logger.Logger
 
 	coreConfig      = &Config{}
 	pluginConfigMap = map[string]*PluginConfig{}
+
+	// configMu guard the sections replaced by Reload, which are plugins, roles, blocklist and limiters of core config
+	// and the plugin config mapping, they must be read by accessors after bot started
+	configMu sync.RWMutex
 )
 
 // Logger return the logger for core
@@ -29,6 +41,48 @@ func Logger() logger.Logger {
 	return coreLogger
 }
 
+// Plugins get plugin section of live config, the slice is replaced as a whole by reload, do not modify it
+func Plugins() []PluginConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return coreConfig.Plugins
+}
+
+// PluginConfigOf get live config of the enabled plugin
+func PluginConfigOf(name string) (*PluginConfig, bool) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	plugin, exist := pluginConfigMap[name]
+	return plugin, exist
+}
+
+// ReadConfig run read while config receivers of plugins are not written by reload, plugins read their receivers
+// inside it if they can be read when reloading
+func ReadConfig(read func()) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	read()
+}
+
+// liveRoles get roles section of live config
+func liveRoles() []RoleConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return coreConfig.Roles
+}
+
+// liveBlocklist get blocklist section of live config
+func liveBlocklist() BlocklistConfig {
+	configMu.RLock()
+	defer configMu.RUnlock()
+
+	return coreConfig.Blocklist
+}
+
 // SetLogger set the logger for core, only set once when the logger is 'custom'
 func SetLogger(log logger.Logger) {
 	if log != nil && tempLogger == nil {
@@ -46,6 +100,29 @@ func Initialize() (ctx context.Context, cfg *Config, mapping map[string]*PluginC
 	return ctx, coreConfig, pluginConfigMap
 }
 
+// InitializeWithConfig initialize core with the config content instead of bot.yaml, it is used by testing
+// harness and embedding, plugin config files are still read from config folder
+func InitializeWithConfig(content []byte) (ctx context.Context, cfg *Config, mapping map[string]*PluginConfig, err error) {
+	// initialization panics on invalid config, recover it as error
+	defer func() {
+		if r := recover(); r != nil {
+			ctx, cfg, mapping, err = nil, nil, nil, fmt.Errorf("failed to initialize core: %v", r)
+		}
+	}()
+
+	if unmarshalErr := yaml.Unmarshal(content, coreConfig); unmarshalErr != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse core config: %w", unmarshalErr)
+	}
+
+	ctx = trace.NewContext()
+	initRegister()
+	registerLimiters()
+	initializeCore(ctx)
+	loadConfigs(ctx)
+
+	return ctx, coreConfig, pluginConfigMap, nil
+}
+
 func initPackage() {
 	// testing mode, skip initialization
 	if os.Getenv("ci") == "true" {
@@ -53,7 +130,7 @@ func initPackage() {
 	}
 
 	// config not found, created default config
-	configPath := filepath.Join("./config", "bot.yaml")
+	configPath := filepath.Clean(botConfigPath)
 	if _, statErr := os.Stat(configPath); errors.Is(statErr, os.ErrNotExist) {
 		_ = os.MkdirAll(filepath.Dir(configPath), os.ModePerm)
 		outBytes, _ := yaml.Marshal(&Config{
@@ -68,6 +145,12 @@ func initPackage() {
 				Port:        0,
 				AccessToken: "",
 			},
+			Admin: AdminConfig{
+				Enable: false,
+				Host:   "127.0.0.1",
+				Port:   0,
+				Token:  "",
+			},
 			Plugins: []PluginConfig{
 				{
 					Name:           "",
@@ -98,6 +181,23 @@ func initPackage() {
 
 	// register default rules and limiters
 	initRegister()
+
+	// register limiters declared in config
+	registerLimiters()
+}
+
+// newDriver create the driver of connection mode
+func newDriver(config WebsocketConfig) (zero.Driver, error) {
+	switch config.Mode {
+	case "", ModeWebsocket:
+		return NewWebsocketClientWithConfig(config)
+	case ModeHTTP:
+		return NewHTTPDriverWithConfig(config)
+	case ModeConsole:
+		return NewConsoleDriverWithConfig(config), nil
+	default:
+		return nil, fmt.Errorf("unknown mode: %s", config.Mode)
+	}
 }
 
 func initializeCore(ctx context.Context) {
@@ -143,16 +243,26 @@ func initializeCore(ctx context.Context) {
 		logrus.SetLevel(logrus.PanicLevel)
 	}
 
-	// init zero config and websocket driver
-	websocketClient := &driver.WSClient{
-		Url:         fmt.Sprintf("ws://%s:%d", coreConfig.Websocket.Host, coreConfig.Websocket.Port),
-		AccessToken: coreConfig.Websocket.AccessToken,
+	// init zero config and driver of connection mode
+	connection, driverErr := newDriver(coreConfig.Websocket)
+	if driverErr != nil {
+		panic("invalid websocket config: " + driverErr.Error())
 	}
 	coreConfig.ZeroConfig = &zero.Config{
 		NickName:      coreConfig.Bot.Nickname,
 		CommandPrefix: coreConfig.Bot.TriggerPrefix,
 		SuperUsers:    coreConfig.Bot.SupperUsers,
-		Driver:        []zero.Driver{websocketClient},
+		Driver:        []zero.Driver{connection},
+	}
+
+	// record events and actions if enabled, recording failure should not stop the bot
+	if coreConfig.Recorder.Enable {
+		recorder, openErr := NewRecorder(coreConfig.Recorder)
+		if openErr != nil {
+			coreLogger.Error(logger.NewFields(ctx).WithMessage("failed to open recorder, recording disabled").WithData(openErr.Error()))
+		} else {
+			coreConfig.ZeroConfig.Driver = []zero.Driver{NewRecordingDriver(connection, recorder)}
+		}
 	}
 
 	// mapping plugin config
@@ -167,6 +277,10 @@ func initializeCore(ctx context.Context) {
 			panic("duplicate plugin name: " + plugin.Name)
 		}
 
+		if compileErr := compileTriggers(&plugin); compileErr != nil {
+			panic(compileErr.Error())
+		}
+
 		pluginConfigMap[plugin.Name] = &plugin
 	}
 }
@@ -183,3 +297,65 @@ func loadConfigs(ctx context.Context) {
 		coreLogger.Debug(logger.NewFields(ctx).WithMessage("plugin config loaded").WithData(map[string]any{"plugin": plugin.Name}))
 	}
 }
+
+// Reload re-read bot.yaml and plugin config files, only plugin, role, blocklist and limiter sections will be applied,
+// bot, websocket and handler bindings take effect after restart, nothing is applied if any file is invalid
+func Reload(ctx context.Context) (cfg *Config, err error) {
+	reloaded := &Config{}
+	if loadErr := config.LoadConfig(reloaded, filepath.Clean(botConfigPath)); loadErr != nil {
+		return nil, fmt.Errorf("failed to reload core config: %w", loadErr)
+	}
+
+	mapping := map[string]*PluginConfig{}
+	for _, plugin := range reloaded.Plugins {
+		if !plugin.Enable {
+			// skip disabled plugin
+			continue
+		}
+
+		if _, existPlugin := mapping[plugin.Name]; existPlugin {
+			return nil, errors.New("duplicate plugin name: " + plugin.Name)
+		}
+
+		if compileErr := compileTriggers(&plugin); compileErr != nil {
+			return nil, compileErr
+		}
+
+		mapping[plugin.Name] = &plugin
+	}
+
+	// decode all plugin configs before applying any of them, so a failed reload keeps the live config
+	receivers, contents := []any{}, [][]byte{}
+	for _, plugin := range mapping {
+		if plugin.ConfigFile == "" {
+			continue
+		}
+
+		receiver, content, decodeErr := decodeConfig(plugin)
+		if decodeErr != nil {
+			return nil, fmt.Errorf("failed to reload plugin config: %w", decodeErr)
+		}
+
+		receivers, contents = append(receivers, receiver), append(contents, content)
+		coreLogger.Debug(logger.NewFields(ctx).WithMessage("plugin config reloaded").WithData(map[string]any{"plugin": plugin.Name}))
+	}
+
+	if checkErr := checkLimiters(reloaded.Limiters); checkErr != nil {
+		return nil, checkErr
+	}
+
+	configMu.Lock()
+	for i, receiver := range receivers {
+		// decode into the receiver as loading, so unexported fields and fields missing in file are kept
+		if applyErr := decodeInto(contents[i], receiver); applyErr != nil {
+			coreLogger.Error(logger.NewFields(ctx).WithMessage("failed to apply reloaded plugin config").WithData(applyErr.Error()))
+		}
+	}
+	coreConfig.Plugins, coreConfig.Roles, coreConfig.Blocklist, pluginConfigMap = reloaded.Plugins, reloaded.Roles, reloaded.Blocklist, mapping
+	coreConfig.Limiters = reloaded.Limiters
+	configMu.Unlock()
+	registerLimiters()
+	coreLogger.Info(logger.NewFields(ctx).WithMessage("config reloaded"))
+
+	return coreConfig, nil
+}
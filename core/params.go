@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/alioth-center/infrastructure/utils/concurrency"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const paramsStateKey = "regex_params"
+
+var regexes = concurrency.NewMap[string, *regexp.Regexp]()
+
+// CompileRegex compile regex trigger, compiled regex is cached by pattern
+func CompileRegex(pattern string) (*regexp.Regexp, error) {
+	if compiled, exist := regexes.Get(pattern); exist {
+		return compiled, nil
+	}
+
+	compiled, compileErr := regexp.Compile(pattern)
+	if compileErr != nil {
+		return nil, compileErr
+	}
+
+	regexes.Set(pattern, compiled)
+	return compiled, nil
+}
+
+// compileTriggers check all regex triggers and replies of plugin, so invalid config fails at loading config
+func compileTriggers(plugin *PluginConfig) error {
+	for _, handler := range plugin.Handlers {
+		if handler.Reply != nil {
+			if validateErr := handler.Reply.validate(); validateErr != nil {
+				return fmt.Errorf("invalid reply of handler %s in plugin %s: %w", handler.Name, plugin.Name, validateErr)
+			}
+		}
+		for _, pattern := range handler.Triggers.Regexes {
+			if _, compileErr := CompileRegex(pattern); compileErr != nil {
+				return fmt.Errorf("invalid regex of handler %s in plugin %s: %w", handler.Name, plugin.Name, compileErr)
+			}
+		}
+	}
+
+	return nil
+}
+
+// WithParams wrap the handler bound to regex trigger, named capture groups are extracted as params
+func WithParams(pattern string, handler func(*zero.Ctx)) func(*zero.Ctx) {
+	compiled, compileErr := CompileRegex(pattern)
+	if compileErr != nil {
+		panic("invalid regex: " + pattern)
+	}
+
+	return func(ctx *zero.Ctx) {
+		params := map[string]string{}
+		matched, _ := ctx.State["regex_matched"].([]string)
+		for i, name := range compiled.SubexpNames() {
+			if name != "" && i < len(matched) {
+				params[name] = matched[i]
+			}
+		}
+
+		ctx.State[paramsStateKey] = params
+		handler(ctx)
+	}
+}
+
+// Params get named capture groups of the regex trigger, if not triggered by regex, return empty map
+func Params(ctx *zero.Ctx) map[string]string {
+	params, exist := ctx.State[paramsStateKey].(map[string]string)
+	if !exist {
+		return map[string]string{}
+	}
+
+	return params
+}
+
+// RegisterRegexHandler register a handler with typed params, fields tagged by param:"name" are bound from
+// named capture groups with the same conversion as command arguments, unmatched groups use default tag
+func RegisterRegexHandler[T any](name string, handler func(ctx *zero.Ctx, params T)) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		panic("regex params must be a struct: " + typ.String())
+	}
+
+	specs := []argSpec{}
+	for i := 0; i < typ.NumField(); i++ {
+		tag, tagged := typ.Field(i).Tag.Lookup("param")
+		if tagged && typ.Field(i).IsExported() {
+			specs = append(specs, newArgSpec(i, typ.Field(i), strings.Split(tag, ",")))
+		}
+	}
+
+	RegisterHandler(name, func(ctx *zero.Ctx) {
+		var params T
+		if bindErr := bindParams(Params(ctx), specs, reflect.ValueOf(&params).Elem()); bindErr != nil {
+			ctx.Send(message.Text(bindErr.Error()))
+			return
+		}
+
+		handler(ctx, params)
+	})
+}
+
+func bindParams(params map[string]string, specs []argSpec, out reflect.Value) error {
+	for _, spec := range specs {
+		value := params[spec.name]
+		switch {
+		case value == "" && spec.hasDefault:
+			value = spec.fallback
+		case value == "" && spec.required:
+			return fmt.Errorf("missing param: %s", spec.name)
+		case value == "":
+			continue
+		}
+
+		if assignErr := spec.assign(out.Field(spec.field), value); assignErr != nil {
+			return assignErr
+		}
+	}
+
+	return nil
+}
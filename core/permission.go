@@ -0,0 +1,162 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alioth-center/infrastructure/logger"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	// grantPrefix is the key prefix of role grants, the key is user_grant/<user id>/<group id>/<role>,
+	// so grants of a user are scanned by prefix, group id 0 means the role is granted globally
+	grantPrefix = "user_grant/"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+
+	permissionStorageOnce sync.Once
+	permissionStorage     Storage
+)
+
+// RoleGrant is a role granted to a user, GroupID 0 means granted in all groups
+type RoleGrant struct {
+	Role    string `json:"role"`
+	UserID  int64  `json:"user_id"`
+	GroupID int64  `json:"group_id,omitempty"`
+}
+
+// Grant give the role to user in the group, gid 0 means globally
+func Grant(role string, uid, gid int64) error {
+	if !roleExist(role) {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, role)
+	}
+
+	return openPermissionStorage().Set(grantKey(role, uid, gid), []byte(role))
+}
+
+// Revoke take the role from user in the group, global grant is not affected by group revoking,
+// the role may be removed from config already, so its grants can still be cleaned up
+func Revoke(role string, uid, gid int64) error {
+	return openPermissionStorage().Delete(grantKey(role, uid, gid))
+}
+
+// Grants list grants of the user, both global and in the group, uid 0 means all users
+func Grants(uid, gid int64) (grants []RoleGrant) {
+	prefix := grantPrefix
+	if uid != 0 {
+		prefix += strconv.FormatInt(uid, 10) + "/"
+	}
+
+	for key := range openPermissionStorage().Scan(prefix) {
+		grant, valid := parseGrantKey(key)
+		if !valid || (grant.GroupID != 0 && grant.GroupID != gid) {
+			continue
+		}
+
+		grants = append(grants, grant)
+	}
+
+	slices.SortFunc(grants, func(a, b RoleGrant) int {
+		return strings.Compare(grantKey(a.Role, a.UserID, a.GroupID), grantKey(b.Role, b.UserID, b.GroupID))
+	})
+	return grants
+}
+
+// HasRole check if user holds the role in the group, directly or by an including role,
+// bot owners hold all roles
+func HasRole(uid, gid int64, role string) bool {
+	if slices.Contains(zero.BotConfig.SuperUsers, uid) {
+		return true
+	}
+
+	for _, grant := range Grants(uid, gid) {
+		if roleIncludes(grant.Role, role, map[string]bool{}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasRoleRule match events from users holding the role, used as has_role(name) in handler rules
+func HasRoleRule(role string) zero.Rule {
+	return func(ctx *zero.Ctx) bool {
+		return HasRole(ctx.Event.UserID, ctx.Event.GroupID, role)
+	}
+}
+
+// ParseRoleRule parse rule written as has_role(name), return the role name
+func ParseRoleRule(rule string) (role string, isRoleRule bool) {
+	role, isRoleRule = strings.CutPrefix(rule, "has_role(")
+	role, closed := strings.CutSuffix(role, ")")
+	return strings.TrimSpace(role), isRoleRule && closed
+}
+
+// roleIncludes check if held role is the expected role or includes it, visited prevents include cycles
+func roleIncludes(held, expected string, visited map[string]bool) bool {
+	if held == expected {
+		return true
+	}
+	if visited[held] {
+		return false
+	}
+
+	visited[held] = true
+	for _, role := range liveRoles() {
+		if role.Name != held {
+			continue
+		}
+
+		for _, included := range role.Includes {
+			if roleIncludes(included, expected, visited) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func roleExist(name string) bool {
+	return slices.ContainsFunc(liveRoles(), func(role RoleConfig) bool { return role.Name == name })
+}
+
+func grantKey(role string, uid, gid int64) string {
+	return fmt.Sprintf("%s%d/%d/%s", grantPrefix, uid, gid, role)
+}
+
+func parseGrantKey(key string) (grant RoleGrant, valid bool) {
+	parts := strings.SplitN(strings.TrimPrefix(key, grantPrefix), "/", 3)
+	if len(parts) != 3 {
+		return grant, false
+	}
+
+	uid, uidErr := strconv.ParseInt(parts[0], 10, 64)
+	gid, gidErr := strconv.ParseInt(parts[1], 10, 64)
+	if gidErr != nil || uidErr != nil {
+		return grant, false
+	}
+
+	return RoleGrant{Role: parts[2], UserID: uid, GroupID: gid}, true
+}
+
+func openPermissionStorage() Storage {
+	permissionStorageOnce.Do(func() {
+		storage, openErr := NewFileStorage(filepath.Join("data", "permission", "permission.storage.db"))
+		if openErr != nil {
+			coreLogger.Info(logger.NewFields().WithMessage("failed to open permission storage, fallback to memory").WithData(openErr.Error()))
+			storage = NewMemoryStorage()
+		}
+		permissionStorage = storage
+	})
+
+	return permissionStorage
+}
@@ -0,0 +1,344 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alioth-center/infrastructure/logger"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	PriorityNormal = iota
+	PriorityHigh
+	PriorityLow
+)
+
+const (
+	defaultQueueSize          = 1000
+	defaultQueueBotInterval   = 500 * time.Millisecond
+	defaultQueueGroupInterval = time.Second
+	defaultQueueMaxRetries    = 3
+	defaultQueueRetryBackoff  = 2 * time.Second
+	defaultQueueMaxBackoff    = time.Minute
+)
+
+var (
+	// ErrQueueFull is the error of messages dropped because the outbound queue reached its size
+	ErrQueueFull = errors.New("outbound queue is full")
+
+	// lanes are scanned in order, so high priority messages are sent first
+	lanes      = []int{PriorityHigh, PriorityNormal, PriorityLow}
+	laneNames  = map[int]string{PriorityHigh: "high", PriorityNormal: "normal", PriorityLow: "low"}
+	outbound   *outboundQueue
+	outboundMu sync.Mutex
+)
+
+// OutboundMessage is a message waiting in the outbound queue, it is sent to group if group id is set,
+// otherwise to user, priority is one of PriorityHigh, PriorityNormal and PriorityLow
+type OutboundMessage struct {
+	SelfID   int64
+	GroupID  int64
+	UserID   int64
+	Message  message.Message
+	Priority int
+}
+
+// SendResult is the result of a queued message, it is done after the message is sent, or failed after retries
+type SendResult struct {
+	done      chan struct{}
+	messageID int64
+	err       error
+}
+
+// Done get the channel closed when the message is sent or failed
+func (r *SendResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait block until the message is sent or failed, and return the message id or the last error
+func (r *SendResult) Wait() (int64, error) {
+	<-r.done
+	return r.messageID, r.err
+}
+
+func (r *SendResult) finish(messageID int64, err error) {
+	r.messageID, r.err = messageID, err
+	close(r.done)
+}
+
+// QueueMetrics is the status of outbound queue, depth is the count of waiting messages by priority lane
+type QueueMetrics struct {
+	Depth   map[string]int `json:"depth"`
+	Sent    uint64         `json:"sent"`
+	Retried uint64         `json:"retried"`
+	Failed  uint64         `json:"failed"`
+	Dropped uint64         `json:"dropped"`
+}
+
+// Send enqueue message to the chat of event with normal priority, it returns without waiting for sending,
+// so bursts are throttled by the queue instead of risk control of platform
+func Send(ctx *zero.Ctx, msg ...message.MessageSegment) *SendResult {
+	return SendWithPriority(ctx, PriorityNormal, msg...)
+}
+
+// SendWithPriority enqueue message to the chat of event in the priority lane
+func SendWithPriority(ctx *zero.Ctx, priority int, msg ...message.MessageSegment) *SendResult {
+	return Enqueue(OutboundMessage{SelfID: ctx.Event.SelfID, GroupID: ctx.Event.GroupID, UserID: ctx.Event.UserID, Message: msg, Priority: priority})
+}
+
+// Enqueue put message into the outbound queue, it is used to send messages outside handlers, such as cron jobs
+func Enqueue(msg OutboundMessage) *SendResult {
+	return outboundQueueOf().enqueue(msg)
+}
+
+// Metrics get the status of outbound queue
+func Metrics() QueueMetrics {
+	return outboundQueueOf().metrics()
+}
+
+// QueueSize get the most messages outbound queue holds, messages enqueued beyond it fail with ErrQueueFull
+func QueueSize() int {
+	return outboundQueueOf().config().withDefaults().Size
+}
+
+func outboundQueueOf() *outboundQueue {
+	outboundMu.Lock()
+	defer outboundMu.Unlock()
+
+	if outbound == nil {
+		outbound = newOutboundQueue(func() QueueConfig { return coreConfig.Queue })
+	}
+
+	return outbound
+}
+
+type (
+	outboundQueue struct {
+		config func() QueueConfig
+
+		mu     sync.Mutex
+		bots   map[int64]*botQueue
+		groups map[[2]int64]time.Time
+		depth  map[int]int
+
+		sent, retried, failed, dropped atomic.Uint64
+	}
+
+	botQueue struct {
+		selfID int64
+		lanes  map[int][]*queuedMessage
+		next   time.Time
+		wake   chan struct{}
+	}
+
+	queuedMessage struct {
+		OutboundMessage
+		attempt   int
+		notBefore time.Time
+		result    *SendResult
+	}
+)
+
+func newOutboundQueue(config func() QueueConfig) *outboundQueue {
+	return &outboundQueue{config: config, bots: map[int64]*botQueue{}, groups: map[[2]int64]time.Time{}, depth: map[int]int{}}
+}
+
+func (q *outboundQueue) enqueue(msg OutboundMessage) *SendResult {
+	result := &SendResult{done: make(chan struct{})}
+	if _, known := laneNames[msg.Priority]; !known {
+		msg.Priority = PriorityNormal
+	}
+	config := q.config().withDefaults()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	total := 0
+	for _, count := range q.depth {
+		total += count
+	}
+	if total >= config.Size {
+		q.dropped.Add(1)
+		result.finish(0, ErrQueueFull)
+		return result
+	}
+
+	bot, exist := q.bots[msg.SelfID]
+	if !exist {
+		bot = &botQueue{selfID: msg.SelfID, lanes: map[int][]*queuedMessage{}, wake: make(chan struct{}, 1)}
+		q.bots[msg.SelfID] = bot
+		go q.work(bot)
+	}
+	bot.lanes[msg.Priority] = append(bot.lanes[msg.Priority], &queuedMessage{OutboundMessage: msg, result: result})
+	q.depth[msg.Priority]++
+	bot.notify()
+
+	return result
+}
+
+// work send messages of the bot one by one, the bot interval is kept between two sends,
+// and groups waiting for their interval are skipped, so other groups are not blocked by them
+func (q *outboundQueue) work(bot *botQueue) {
+	for {
+		config := q.config().withDefaults()
+
+		q.mu.Lock()
+		next, wait := q.pick(bot, time.Now())
+		if next != nil {
+			now := time.Now()
+			bot.next = now.Add(config.BotInterval)
+			if next.GroupID != 0 {
+				q.groups[[2]int64{bot.selfID, next.GroupID}] = now.Add(config.GroupInterval)
+			}
+		}
+		q.mu.Unlock()
+
+		if next == nil {
+			bot.sleep(wait)
+			continue
+		}
+
+		messageID, sendErr := q.deliver(next)
+		if sendErr == nil {
+			q.sent.Add(1)
+			next.result.finish(messageID, nil)
+			continue
+		}
+
+		next.attempt++
+		if next.attempt > config.MaxRetries {
+			q.failed.Add(1)
+			next.result.finish(0, sendErr)
+			if coreLogger != nil {
+				coreLogger.Info(logger.NewFields().WithMessage("failed to send queued message").WithData(map[string]any{"self_id": bot.selfID, "group_id": next.GroupID, "user_id": next.UserID, "error": sendErr.Error()}))
+			}
+			continue
+		}
+
+		q.retried.Add(1)
+		backoff := ReconnectConfig{InitialBackoff: config.RetryBackoff, MaxBackoff: config.MaxRetryBackoff, Jitter: 0.2}
+		next.notBefore = time.Now().Add(backoff.backoff(next.attempt, rand.Float64()))
+
+		q.mu.Lock()
+		bot.lanes[next.Priority] = append([]*queuedMessage{next}, bot.lanes[next.Priority]...)
+		q.depth[next.Priority]++
+		q.mu.Unlock()
+	}
+}
+
+// pick remove the first message ready to send in priority order, if none is ready, it returns the time to wait,
+// zero wait means the bot has no message, the caller holds the lock
+func (q *outboundQueue) pick(bot *botQueue, now time.Time) (*queuedMessage, time.Duration) {
+	if wait := bot.next.Sub(now); wait > 0 {
+		return nil, wait
+	}
+
+	wait := time.Duration(0)
+	for _, lane := range lanes {
+		for i, queued := range bot.lanes[lane] {
+			ready := queued.notBefore
+			if groupReady := q.groups[[2]int64{bot.selfID, queued.GroupID}]; queued.GroupID != 0 && groupReady.After(ready) {
+				ready = groupReady
+			}
+			if !ready.After(now) {
+				bot.lanes[lane] = append(bot.lanes[lane][:i:i], bot.lanes[lane][i+1:]...)
+				q.depth[lane]--
+				return queued, 0
+			}
+
+			if until := ready.Sub(now); wait == 0 || until < wait {
+				wait = until
+			}
+		}
+	}
+
+	return nil, wait
+}
+
+// deliver call send action of the bot, a bot not connected is a failure, so the message is retried after reconnecting
+func (q *outboundQueue) deliver(queued *queuedMessage) (int64, error) {
+	caller, exist := zero.APICallers.Load(queued.SelfID)
+	if !exist {
+		return 0, fmt.Errorf("bot %d not connected", queued.SelfID)
+	}
+
+	request := zero.APIRequest{Action: "send_private_msg", Params: zero.Params{"user_id": queued.UserID, "message": queued.Message}}
+	if queued.GroupID != 0 {
+		request = zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": queued.GroupID, "message": queued.Message}}
+	}
+
+	response, callErr := caller.CallApi(request)
+	if callErr != nil {
+		return 0, callErr
+	}
+	if response.Status != "ok" {
+		return 0, fmt.Errorf("%s failed: %d %s", request.Action, response.RetCode, response.Msg)
+	}
+
+	return response.Data.Get("message_id").Int(), nil
+}
+
+func (q *outboundQueue) metrics() QueueMetrics {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	metrics := QueueMetrics{Depth: map[string]int{}, Sent: q.sent.Load(), Retried: q.retried.Load(), Failed: q.failed.Load(), Dropped: q.dropped.Load()}
+	for _, lane := range lanes {
+		metrics.Depth[laneNames[lane]] = q.depth[lane]
+	}
+
+	return metrics
+}
+
+// notify wake the worker of bot without blocking, the caller holds the lock
+func (b *botQueue) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sleep wait for new messages, or the time until a waiting message is ready
+func (b *botQueue) sleep(wait time.Duration) {
+	if wait <= 0 {
+		<-b.wake
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-b.wake:
+	case <-timer.C:
+	}
+}
+
+// withDefaults fill missing fields of queue config, negative intervals disable throttling
+func (c QueueConfig) withDefaults() QueueConfig {
+	if c.Size <= 0 {
+		c.Size = defaultQueueSize
+	}
+	if c.BotInterval == 0 {
+		c.BotInterval = defaultQueueBotInterval
+	}
+	if c.GroupInterval == 0 {
+		c.GroupInterval = defaultQueueGroupInterval
+	}
+	if c.MaxRetries == 0 {
+		c.MaxRetries = defaultQueueMaxRetries
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = defaultQueueRetryBackoff
+	}
+	if c.MaxRetryBackoff <= 0 {
+		c.MaxRetryBackoff = defaultQueueMaxBackoff
+	}
+
+	return c
+}
@@ -0,0 +1,306 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/infrastructure/logger"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	RecordEvent  = "event"
+	RecordAction = "action"
+
+	defaultRecordPath     = "./logs/record.jsonl"
+	defaultRecordMaxSize  = 64
+	defaultRecordMaxFiles = 5
+
+	// RedactedText is the mask of redacted strings
+	RedactedText = "[redacted]"
+)
+
+// Record is a line of recording, event is the raw event received, action is the api request sent by bot
+type Record struct {
+	Time   time.Time       `json:"time"`
+	Kind   string          `json:"kind"`
+	SelfID int64           `json:"self_id,omitempty"`
+	Action string          `json:"action,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Recorder write records to jsonl file, the file is rotated when it exceeds max size,
+// values of redacted keys are masked at any depth, numbers are hashed so the same id is still the same after masking
+type Recorder struct {
+	config  RecorderConfig
+	maxSize int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRecorder open the recording file of config, records are appended to the existing file
+func NewRecorder(config RecorderConfig) (*Recorder, error) {
+	if config.Path == "" {
+		config.Path = defaultRecordPath
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = defaultRecordMaxSize
+	}
+	if config.MaxFiles <= 0 {
+		config.MaxFiles = defaultRecordMaxFiles
+	}
+
+	recorder := &Recorder{config: config, maxSize: int64(config.MaxSize) << 20}
+	if openErr := recorder.open(); openErr != nil {
+		return nil, openErr
+	}
+
+	return recorder, nil
+}
+
+// RecordEvent record the raw event received from onebot server
+func (r *Recorder) RecordEvent(selfID int64, payload []byte) error {
+	return r.write(Record{Time: time.Now(), Kind: RecordEvent, SelfID: selfID, Data: payload})
+}
+
+// RecordAction record the api request sent by bot
+func (r *Recorder) RecordAction(selfID int64, request zero.APIRequest) error {
+	params, encodeErr := json.Marshal(request.Params)
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	return r.write(Record{Time: time.Now(), Kind: RecordAction, SelfID: selfID, Action: request.Action, Data: params})
+}
+
+// Close close the recording file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	closeErr := r.file.Close()
+	r.file = nil
+	return closeErr
+}
+
+func (r *Recorder) write(record Record) error {
+	if len(r.config.Redact) > 0 {
+		record.Data = redact(record.Data, r.config.Redact)
+	}
+	encoded, encodeErr := json.Marshal(record)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	encoded = append(encoded, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(encoded)) > r.maxSize {
+		if rotateErr := r.rotate(); rotateErr != nil {
+			return rotateErr
+		}
+	}
+
+	written, writeErr := r.file.Write(encoded)
+	r.size += int64(written)
+	return writeErr
+}
+
+func (r *Recorder) open() error {
+	if mkdirErr := os.MkdirAll(filepath.Dir(r.config.Path), os.ModePerm); mkdirErr != nil {
+		return fmt.Errorf("failed to create record directory: %w", mkdirErr)
+	}
+
+	file, openErr := os.OpenFile(r.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if openErr != nil {
+		return fmt.Errorf("failed to open record file: %w", openErr)
+	}
+	info, statErr := file.Stat()
+	if statErr != nil {
+		_ = file.Close()
+		return statErr
+	}
+
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// rotate shift record.jsonl to record.jsonl.1, record.jsonl.1 to record.jsonl.2 and so on,
+// the oldest file beyond max files is removed
+func (r *Recorder) rotate() error {
+	_ = r.file.Close()
+	r.file = nil
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.config.Path, r.config.MaxFiles))
+	for i := r.config.MaxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.config.Path, i), fmt.Sprintf("%s.%d", r.config.Path, i+1))
+	}
+	if renameErr := os.Rename(r.config.Path, r.config.Path+".1"); renameErr != nil {
+		return renameErr
+	}
+
+	return r.open()
+}
+
+// ReadRecords read records from jsonl file
+func ReadRecords(path string) ([]Record, error) {
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer func() { _ = file.Close() }()
+
+	return DecodeRecords(file)
+}
+
+// DecodeRecords decode records from jsonl stream, empty lines are skipped
+func DecodeRecords(reader io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		record := Record{}
+		if decodeErr := json.Unmarshal(scanner.Bytes(), &record); decodeErr != nil {
+			return nil, fmt.Errorf("invalid record at line %d: %w", line, decodeErr)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// redact mask values of keys in json, invalid json is replaced entirely
+func redact(data json.RawMessage, keys []string) json.RawMessage {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if decodeErr := decoder.Decode(&value); decodeErr != nil {
+		encoded, _ := json.Marshal(RedactedText)
+		return encoded
+	}
+
+	encoded, _ := json.Marshal(redactValue(value, keys, false))
+	return encoded
+}
+
+func redactValue(value any, keys []string, masked bool) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, field := range typed {
+			// segment types of array messages are kept, replay compares them when content is redacted
+			if masked && key == "type" {
+				continue
+			}
+			typed[key] = redactValue(field, keys, masked || slices.Contains(keys, key))
+		}
+		return typed
+	case []any:
+		for i, item := range typed {
+			typed[i] = redactValue(item, keys, masked)
+		}
+		return typed
+	case json.Number:
+		if !masked {
+			return typed
+		}
+
+		// keep ids consistent after masking, so replaying redacted records still works
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(typed.String()))
+		return json.Number(fmt.Sprint(hash.Sum64() % 1e10))
+	case string:
+		if !masked {
+			return typed
+		}
+		return RedactedText
+	default:
+		return value
+	}
+}
+
+// RecordingDriver wrap a driver, events received and api requests sent are written to recorder
+type RecordingDriver struct {
+	zero.Driver
+	recorder *Recorder
+}
+
+// NewRecordingDriver wrap the driver with recorder
+func NewRecordingDriver(driver zero.Driver, recorder *Recorder) *RecordingDriver {
+	return &RecordingDriver{Driver: driver, recorder: recorder}
+}
+
+// Connect connect the wrapped driver, then replace the api callers it registered with recording ones
+func (d *RecordingDriver) Connect() {
+	d.Driver.Connect()
+
+	zero.APICallers.Range(func(selfID int64, caller zero.APICaller) bool {
+		if _, recording := caller.(*recordingCaller); !recording {
+			zero.APICallers.Store(selfID, &recordingCaller{APICaller: caller, selfID: selfID, recorder: d.recorder})
+		}
+		return true
+	})
+}
+
+// Listen record events before handling, handlers call api by the recording caller
+func (d *RecordingDriver) Listen(handler func([]byte, zero.APICaller)) {
+	d.Driver.Listen(func(payload []byte, caller zero.APICaller) {
+		selfID := selfIDOf(payload)
+		if recordErr := d.recorder.RecordEvent(selfID, payload); recordErr != nil {
+			coreLogger.Info(logger.NewFields().WithMessage("failed to record event").WithData(recordErr.Error()))
+		}
+
+		// drivers register themselves again after reconnecting
+		recording := &recordingCaller{APICaller: caller, selfID: selfID, recorder: d.recorder}
+		if registered, exist := zero.APICallers.Load(selfID); exist && registered == caller {
+			zero.APICallers.Store(selfID, recording)
+		}
+
+		handler(payload, recording)
+	})
+}
+
+type recordingCaller struct {
+	zero.APICaller
+	selfID   int64
+	recorder *Recorder
+}
+
+func (c *recordingCaller) CallApi(request zero.APIRequest) (zero.APIResponse, error) {
+	if recordErr := c.recorder.RecordAction(c.selfID, request); recordErr != nil {
+		coreLogger.Info(logger.NewFields().WithMessage("failed to record action").WithData(recordErr.Error()))
+	}
+
+	return c.APICaller.CallApi(request)
+}
+
+func selfIDOf(payload []byte) int64 {
+	event := struct {
+		SelfID int64 `json:"self_id"`
+	}{}
+	_ = json.Unmarshal(payload, &event)
+
+	return event.SelfID
+}
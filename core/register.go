@@ -17,6 +17,7 @@ var (
 	limiters    = concurrency.NewMap[string, func(*zero.Ctx) *rate.Limiter]()
 	plugins     = concurrency.NewMap[string, *PluginOptions]()
 	interfaces  = concurrency.NewMap[string, any]()
+	normalizers = concurrency.NewMap[string, func(string) string]()
 )
 
 func RegisterHandler(name string, handler func(*zero.Ctx)) {
@@ -85,6 +86,17 @@ func RegisterInterface(name string, ifrace any) {
 	interfaces.Set(name, ifrace)
 }
 
+// RegisterNormalizer register a text normalizer used by fuzzy triggers, such as pinyin or traditional to simplified
+func RegisterNormalizer(name string, normalizer func(string) string) {
+	_, exist := normalizers.Get(name)
+	if exist {
+		// cannot rewrite normalizer, it will replace built-in normalizers
+		panic("normalizer already exist")
+	}
+
+	normalizers.Set(name, normalizer)
+}
+
 // GetIfrace get interface by name, if not exist, return nil interface
 func GetIfrace[T any](name string) T {
 	nilIfrace := values.Nil[T]()
@@ -111,7 +123,8 @@ func GetIfrace[T any](name string) T {
 
 type PluginOpts func(opt *PluginOptions)
 
-// WithConfig set plugin config, must be a pointer which can be unmarshalled from yaml
+// WithConfig set plugin config, must be a pointer which can be unmarshalled from yaml, config file is decoded
+// into it again on reload, plugins read it inside ReadConfig if it can be read while reloading
 func WithConfig(config any) PluginOpts {
 	return func(opt *PluginOptions) {
 		opt.config = config
@@ -139,11 +152,28 @@ func WithPriority(priority int) PluginOpts {
 	}
 }
 
+// WithStorage set plugin storage, it will replace the file storage in data folder
+func WithStorage(storage Storage) PluginOpts {
+	return func(opt *PluginOptions) {
+		opt.storage = storage
+	}
+}
+
+// WithLifecycle set plugin lifecycle hook, it will be called when bot connects, disconnects or gives up reconnecting,
+// the hook runs in connection goroutine, long works should be started in another goroutine
+func WithLifecycle(hook func(LifecycleEvent)) PluginOpts {
+	return func(opt *PluginOptions) {
+		opt.lifecycle = hook
+	}
+}
+
 type PluginOptions struct {
-	config   any
-	priority int
-	init     func()
-	initCtx  func(ctx context.Context)
+	config    any
+	priority  int
+	storage   Storage
+	init      func()
+	initCtx   func(ctx context.Context)
+	lifecycle func(LifecycleEvent)
 }
 
 func (opts PluginOptions) Config() any {
@@ -165,3 +195,9 @@ func (opts PluginOptions) InitCtx(ctx context.Context) {
 		opts.initCtx(ctx)
 	}
 }
+
+func (opts PluginOptions) Lifecycle(event LifecycleEvent) {
+	if opts.lifecycle != nil {
+		opts.lifecycle(event)
+	}
+}
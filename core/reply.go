@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"math/rand/v2"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/alioth-center/infrastructure/logger"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const resourceScheme = "resource://"
+
+// errReplyTextAndRandom is returned when both text and random are declared, only one of them can be replied
+var errReplyTextAndRandom = errors.New("text and random of reply cannot be used together")
+
+// replyData is the variables can be used in reply templates
+type replyData struct {
+	Nickname string
+	UserID   int64
+	GroupID  int64
+	Args     string
+	Matched  []string
+}
+
+type replyHandler struct {
+	texts []*template.Template
+	image string
+}
+
+// NewReplyHandler create a handler which replies fixed text or image declared in config,
+// the texts are go templates, they are parsed here so invalid template fails at binding
+func NewReplyHandler(plugin PluginConfig, reply ReplyConfig) (func(*zero.Ctx), error) {
+	if validateErr := reply.validate(); validateErr != nil {
+		return nil, validateErr
+	}
+	handler := &replyHandler{}
+
+	sources := reply.Random
+	if len(sources) == 0 && reply.Text != "" {
+		sources = []string{reply.Text}
+	}
+	for _, source := range sources {
+		parsed, parseErr := template.New(plugin.Name).Parse(source)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+
+		handler.texts = append(handler.texts, parsed)
+	}
+
+	// resource scheme is relative to plugin resource folder, which is the data folder of zbpctrl
+	handler.image = reply.Image
+	if resource, isResource := strings.CutPrefix(reply.Image, resourceScheme); isResource {
+		folder := plugin.ResourceFolder
+		if folder == "" {
+			folder = plugin.DataFolder
+		}
+		absolute, absErr := filepath.Abs(filepath.Join("data", folder, resource))
+		if absErr != nil {
+			return nil, absErr
+		}
+
+		handler.image = "file:///" + strings.TrimPrefix(filepath.ToSlash(absolute), "/")
+	}
+
+	return func(ctx *zero.Ctx) {
+		if reply := handler.render(ctx); len(reply) > 0 {
+			ctx.Send(reply)
+		}
+	}, nil
+}
+
+func (h *replyHandler) render(ctx *zero.Ctx) (reply message.Message) {
+	if len(h.texts) > 0 {
+		data := replyData{UserID: ctx.Event.UserID, GroupID: ctx.Event.GroupID}
+		if ctx.Event.Sender != nil {
+			data.Nickname = ctx.Event.Sender.NickName
+			if ctx.Event.Sender.Card != "" {
+				data.Nickname = ctx.Event.Sender.Card
+			}
+		}
+		data.Args, _ = ctx.State["args"].(string)
+		data.Matched, _ = ctx.State["regex_matched"].([]string)
+
+		rendered := strings.Builder{}
+		if executeErr := h.texts[rand.IntN(len(h.texts))].Execute(&rendered, data); executeErr != nil {
+			if coreLogger != nil {
+				coreLogger.Error(logger.NewFields().WithMessage("failed to render reply template").WithData(map[string]any{"group_id": data.GroupID, "user_id": data.UserID, "error": executeErr.Error()}))
+			}
+		} else {
+			reply = append(reply, message.Text(rendered.String()))
+		}
+	}
+
+	if h.image != "" {
+		reply = append(reply, message.Image(h.image))
+	}
+
+	return reply
+}
+
+// validate check the reply declared in config, text and random are exclusive
+func (r ReplyConfig) validate() error {
+	if r.Text != "" && len(r.Random) > 0 {
+		return errReplyTextAndRandom
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/infrastructure/logger"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	// sessionStateTTL is the max lifetime of session state, avoid leftovers of abandoned sessions
+	sessionStateTTL = 24 * time.Hour
+
+	// defaultAskTimeout is the time Ask waits when timeout is not positive
+	defaultAskTimeout = 5 * time.Minute
+
+	// sessionScopeStateKey is the plugin and handler running the session, sessions started outside
+	// bound handlers share the global namespace
+	sessionScopeStateKey = "session_scope"
+	globalSessionScope   = "global"
+)
+
+var (
+	ErrSessionTimeout  = errors.New("session timeout")
+	ErrSessionCanceled = errors.New("session canceled")
+	ErrSessionBusy     = errors.New("session already waiting for reply")
+	ErrTooManySessions = errors.New("too many sessions")
+)
+
+var (
+	sessionLock   sync.Mutex
+	sessionActive = map[string]struct{}{}
+
+	sessionStorageOnce sync.Once
+	sessionStorage     Storage
+)
+
+// Conversation is a multi-turn session started from a handler
+type Conversation struct {
+	ctx         *zero.Ctx
+	namespace   string
+	groupScope  bool
+	cancelWords []string
+}
+
+type SessionOpts func(conversation *Conversation)
+
+// WithGroupScope accept replies from anyone in the group, default only accept the user who triggered
+func WithGroupScope() SessionOpts {
+	return func(conversation *Conversation) {
+		conversation.groupScope = true
+	}
+}
+
+// WithCancelWords set the words to cancel session, it will replace cancel words in config file
+func WithCancelWords(words ...string) SessionOpts {
+	return func(conversation *Conversation) {
+		conversation.cancelWords = words
+	}
+}
+
+// WithSessionScope wrap the handler of plugin, sessions started by it are namespaced by plugin and handler,
+// so the same state key used by different handlers never collides
+func WithSessionScope(plugin, handler string, endpoint func(*zero.Ctx)) func(*zero.Ctx) {
+	scope := plugin + "/" + handler
+	return func(ctx *zero.Ctx) {
+		ctx.State[sessionScopeStateKey] = scope
+		endpoint(ctx)
+	}
+}
+
+// Session start a conversation with the sender of ctx, state is namespaced by the handler of ctx
+func Session(ctx *zero.Ctx, options ...SessionOpts) *Conversation {
+	namespace, _ := ctx.State[sessionScopeStateKey].(string)
+	if namespace == "" {
+		namespace = globalSessionScope
+	}
+
+	conversation := &Conversation{ctx: ctx, namespace: namespace, cancelWords: coreConfig.Bot.Session.CancelWords}
+	for _, o := range options {
+		o(conversation)
+	}
+
+	return conversation
+}
+
+// Ask send prompt and wait for the next reply in scope, prompt can be nil to wait without sending,
+// timeout not positive means the default timeout of 5 minutes
+func (c *Conversation) Ask(prompt any, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		timeout = defaultAskTimeout
+	}
+	if acquireErr := c.acquire(); acquireErr != nil {
+		return "", acquireErr
+	}
+	defer c.release()
+
+	if prompt != nil {
+		c.ctx.Send(prompt)
+	}
+
+	// block the reply, it should not trigger other handlers
+	replies, cancel := zero.NewFutureEvent("message", 0, true, c.inScope).Repeat()
+	defer cancel()
+
+	select {
+	case reply := <-replies:
+		answer := strings.TrimSpace(reply.ExtractPlainText())
+		if slices.Contains(c.cancelWords, answer) {
+			return "", ErrSessionCanceled
+		}
+
+		return answer, nil
+	case <-time.After(timeout):
+		return "", ErrSessionTimeout
+	}
+}
+
+// Value get the state stored in session, persisted across restarts when session persist is enabled
+func (c *Conversation) Value(key string) (string, bool) {
+	value, exist := openSessionStorage().Get(c.key() + "/" + key)
+	return string(value), exist
+}
+
+// Store save the state of session
+func (c *Conversation) Store(key, value string) error {
+	return openSessionStorage().SetWithTTL(c.key()+"/"+key, []byte(value), sessionStateTTL)
+}
+
+// Close clear all state stored in session
+func (c *Conversation) Close() error {
+	storage := openSessionStorage()
+	for key := range storage.Scan(c.key() + "/") {
+		if deleteErr := storage.Delete(key); deleteErr != nil {
+			return deleteErr
+		}
+	}
+
+	return nil
+}
+
+func (c *Conversation) key() string {
+	if c.groupScope && c.ctx.Event.GroupID != 0 {
+		return fmt.Sprintf("%s/g%d", c.namespace, c.ctx.Event.GroupID)
+	}
+
+	return fmt.Sprintf("%s/g%d:u%d", c.namespace, c.ctx.Event.GroupID, c.ctx.Event.UserID)
+}
+
+func (c *Conversation) inScope(reply *zero.Ctx) bool {
+	if c.groupScope && c.ctx.Event.GroupID != 0 {
+		return reply.Event.GroupID == c.ctx.Event.GroupID
+	}
+
+	return c.ctx.CheckSession()(reply)
+}
+
+func (c *Conversation) acquire() error {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	key := c.key()
+	if _, busy := sessionActive[key]; busy {
+		return ErrSessionBusy
+	}
+	if limit := coreConfig.Bot.Session.MaxConcurrent; limit > 0 && len(sessionActive) >= limit {
+		return ErrTooManySessions
+	}
+
+	sessionActive[key] = struct{}{}
+	return nil
+}
+
+func (c *Conversation) release() {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	delete(sessionActive, c.key())
+}
+
+func openSessionStorage() Storage {
+	sessionStorageOnce.Do(func() {
+		if !coreConfig.Bot.Session.Persist {
+			sessionStorage = NewMemoryStorage()
+			return
+		}
+
+		storage, openErr := NewFileStorage(filepath.Join("data", "session", "session.storage.db"))
+		if openErr != nil {
+			coreLogger.Info(logger.NewFields().WithMessage("failed to open session storage, fallback to memory").WithData(openErr.Error()))
+			storage = NewMemoryStorage()
+		}
+		sessionStorage = storage
+	})
+
+	return sessionStorage
+}
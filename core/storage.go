@@ -0,0 +1,290 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite "github.com/FloatTech/sqlite"
+	"github.com/alioth-center/infrastructure/utils/concurrency"
+)
+
+// Storage is a key-value store namespaced by plugin
+type Storage interface {
+	// Get value by key, expired key is treated as not exist
+	Get(key string) (value []byte, exist bool)
+	// Set value by key, it never expires
+	Set(key string, value []byte) error
+	// SetWithTTL set value by key, it expires after ttl
+	SetWithTTL(key string, value []byte, ttl time.Duration) error
+	// Delete value by key, deleting not exist key is not an error
+	Delete(key string) error
+	// Scan all values which key has the prefix
+	Scan(prefix string) map[string][]byte
+	// TTL get the time to live of key, 0 means never expire
+	TTL(key string) (ttl time.Duration, exist bool)
+}
+
+// memorySweepInterval is how often memory storage drops expired entries
+const memorySweepInterval = time.Minute
+
+type storageContextKey struct{}
+
+var storages = concurrency.NewMap[string, Storage]()
+
+// OpenStorage open the storage of plugin, the storage set by WithStorage takes precedence,
+// otherwise a sqlite storage in plugin data folder will be used
+func OpenStorage(metadata PluginConfig) (Storage, error) {
+	if opened, exist := storages.Get(metadata.Name); exist {
+		return opened, nil
+	}
+
+	if pluginOpts, exist := plugins.Get(metadata.Name); exist && pluginOpts != nil && pluginOpts.storage != nil {
+		storages.Set(metadata.Name, pluginOpts.storage)
+		return pluginOpts.storage, nil
+	}
+
+	// plugins without data folder share the default folder of zbpctrl, so name the file by plugin
+	folder := metadata.DataFolder
+	if folder == "" {
+		folder = "zbp"
+	}
+	opened, openErr := NewFileStorage(filepath.Join("data", folder, metadata.Name+".storage.db"))
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	storages.Set(metadata.Name, opened)
+	return opened, nil
+}
+
+// ContextWithStorage attach storage to plugin init context
+func ContextWithStorage(ctx context.Context, storage Storage) context.Context {
+	return context.WithValue(ctx, storageContextKey{}, storage)
+}
+
+// GetStorage get storage from plugin init context, if not attached, return nil
+func GetStorage(ctx context.Context) Storage {
+	storage, _ := ctx.Value(storageContextKey{}).(Storage)
+	return storage
+}
+
+type storageEntry struct {
+	Value    []byte
+	ExpireAt int64
+}
+
+func (e storageEntry) expired(now time.Time) bool {
+	return e.ExpireAt != 0 && e.ExpireAt <= now.UnixNano()
+}
+
+type mapStorage struct {
+	mu      sync.RWMutex
+	entries map[string]storageEntry
+	sweepAt time.Time
+}
+
+// NewMemoryStorage create a storage which only lives in memory, useful for testing
+func NewMemoryStorage() Storage {
+	return &mapStorage{entries: map[string]storageEntry{}}
+}
+
+func (s *mapStorage) Get(key string) (value []byte, exist bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, exist := s.entries[key]
+	if !exist || entry.expired(time.Now()) {
+		return nil, false
+	}
+
+	return entry.Value, true
+}
+
+func (s *mapStorage) Set(key string, value []byte) error {
+	return s.SetWithTTL(key, value, 0)
+}
+
+func (s *mapStorage) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := storageEntry{Value: value}
+	if ttl > 0 {
+		entry.ExpireAt = time.Now().Add(ttl).UnixNano()
+	}
+	s.entries[key] = entry
+
+	// drop expired entries once per interval, so keys with ttl do not pile up and writes stay cheap
+	now := time.Now()
+	if now.Before(s.sweepAt) {
+		return nil
+	}
+	for key, entry := range s.entries {
+		if entry.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+	s.sweepAt = now.Add(memorySweepInterval)
+
+	return nil
+}
+
+func (s *mapStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *mapStorage) Scan(prefix string) map[string][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now, result := time.Now(), map[string][]byte{}
+	for key, entry := range s.entries {
+		if strings.HasPrefix(key, prefix) && !entry.expired(now) {
+			result[key] = entry.Value
+		}
+	}
+
+	return result
+}
+
+func (s *mapStorage) TTL(key string) (ttl time.Duration, exist bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	entry, exist := s.entries[key]
+	if !exist || entry.expired(now) {
+		return 0, false
+	}
+	if entry.ExpireAt == 0 {
+		return 0, true
+	}
+
+	return time.Unix(0, entry.ExpireAt).Sub(now), true
+}
+
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+// NewFileStorage create a storage persisted to an embedded sqlite database, every write only touches its own key
+func NewFileStorage(path string) (Storage, error) {
+	if mkdirErr := os.MkdirAll(filepath.Dir(path), os.ModePerm); mkdirErr != nil {
+		return nil, mkdirErr
+	}
+
+	database := &sqlite.Sqlite{DBPath: path}
+	if openErr := database.Open(time.Minute); openErr != nil {
+		return nil, openErr
+	}
+
+	// sqlite allows one writer, serialize access instead of failing with busy errors
+	database.DB.SetMaxOpenConns(1)
+	for _, statement := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"CREATE TABLE IF NOT EXISTS storage (key TEXT PRIMARY KEY, value BLOB NOT NULL, expire_at INTEGER NOT NULL DEFAULT 0)",
+		"CREATE INDEX IF NOT EXISTS storage_expire_at ON storage (expire_at) WHERE expire_at != 0",
+	} {
+		if _, execErr := database.DB.Exec(statement); execErr != nil {
+			_ = database.Close()
+			return nil, fmt.Errorf("failed to open storage %s: %w", path, execErr)
+		}
+	}
+
+	return &sqliteStorage{db: database.DB}, nil
+}
+
+func (s *sqliteStorage) Get(key string) (value []byte, exist bool) {
+	entry, exist := s.entry(key)
+	return entry.Value, exist
+}
+
+func (s *sqliteStorage) Set(key string, value []byte) error {
+	return s.SetWithTTL(key, value, 0)
+}
+
+func (s *sqliteStorage) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	now, expireAt := time.Now(), int64(0)
+	if ttl > 0 {
+		expireAt = now.Add(ttl).UnixNano()
+	}
+
+	if _, execErr := s.db.Exec("INSERT OR REPLACE INTO storage (key, value, expire_at) VALUES (?, ?, ?)", key, value, expireAt); execErr != nil {
+		return execErr
+	}
+
+	// drop expired entries, so keys with ttl do not pile up, it is cheap with the index
+	_, execErr := s.db.Exec("DELETE FROM storage WHERE expire_at != 0 AND expire_at <= ?", now.UnixNano())
+	return execErr
+}
+
+func (s *sqliteStorage) Delete(key string) error {
+	_, execErr := s.db.Exec("DELETE FROM storage WHERE key = ?", key)
+	return execErr
+}
+
+func (s *sqliteStorage) Scan(prefix string) map[string][]byte {
+	result := map[string][]byte{}
+	// keys with the prefix are a range of primary key, so the scan is served by its index
+	query, args := "SELECT key, value FROM storage WHERE key >= ? AND (expire_at = 0 OR expire_at > ?)", []any{prefix, time.Now().UnixNano()}
+	if upper, bounded := prefixUpperBound(prefix); bounded {
+		query, args = query+" AND key < ?", append(args, upper)
+	}
+	rows, queryErr := s.db.Query(query, args...)
+	if queryErr != nil {
+		return result
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, value := "", []byte{}
+		if rows.Scan(&key, &value) == nil {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// prefixUpperBound get the least string greater than all strings with the prefix, empty prefix has no bound
+func prefixUpperBound(prefix string) (string, bool) {
+	upper := []byte(prefix)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xff {
+			upper[i]++
+			return string(upper[:i+1]), true
+		}
+	}
+
+	return "", false
+}
+
+func (s *sqliteStorage) TTL(key string) (ttl time.Duration, exist bool) {
+	entry, exist := s.entry(key)
+	if !exist || entry.ExpireAt == 0 {
+		return 0, exist
+	}
+
+	return time.Until(time.Unix(0, entry.ExpireAt)), true
+}
+
+// entry get the entry of key, expired entry is treated as not exist
+func (s *sqliteStorage) entry(key string) (entry storageEntry, exist bool) {
+	queryErr := s.db.QueryRow("SELECT value, expire_at FROM storage WHERE key = ?", key).Scan(&entry.Value, &entry.ExpireAt)
+	if queryErr != nil || entry.expired(time.Now()) {
+		return storageEntry{}, false
+	}
+
+	return entry, true
+}
@@ -2,15 +2,33 @@ package core
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/alioth-center/infrastructure/trace"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"text/template"
+	"time"
 
+	"github.com/alioth-center/ceobebot-core/devserver"
 	"github.com/alioth-center/infrastructure/logger"
 	"github.com/alioth-center/infrastructure/utils/concurrency"
+	"github.com/tidwall/gjson"
 	zero "github.com/wdvxdr1123/ZeroBot"
 	"github.com/wdvxdr1123/ZeroBot/extension/rate"
+	"github.com/wdvxdr1123/ZeroBot/message"
 	"gopkg.in/yaml.v3"
 )
 
@@ -21,6 +39,18 @@ func reset() {
 	limiters = concurrency.NewMap[string, func(*zero.Ctx) *rate.Limiter]()
 	plugins = concurrency.NewMap[string, *PluginOptions]()
 	interfaces = concurrency.NewMap[string, any]()
+	storages = concurrency.NewMap[string, Storage]()
+	sessionActive = map[string]struct{}{}
+	regexes = concurrency.NewMap[string, *regexp.Regexp]()
+	normalizers = concurrency.NewMap[string, func(string) string]()
+	sessionStorageOnce = sync.Once{}
+	permissionStorageOnce = sync.Once{}
+	blocklistStorageOnce = sync.Once{}
+	limiterBackends = concurrency.NewMap[string, LimiterBackend]()
+	limiterFileOnce = sync.Once{}
+	configLimiters = concurrency.NewMap[string, bool]()
+	healths = map[string]*BotHealth{}
+	outbound = nil
 	coreConfig = &Config{}
 	pluginConfigMap = map[string]*PluginConfig{}
 	coreLogger = nil
@@ -676,4 +706,1761 @@ key: value
 			t.Errorf("Expected context to be initialized, but it was not")
 		}
 	})
+	t.Run("ReloadConfig", func(t *testing.T) {
+		reset()
+
+		// Setup environment for this test
+		_ = os.Setenv("ci", "false")
+		_ = os.MkdirAll("config", os.ModePerm)
+		defer func() {
+			_ = os.RemoveAll("config")
+			_ = os.RemoveAll("logs")
+		}()
+
+		configPath := filepath.Join("config", "bot.yaml")
+		configContent := `
+bot:
+  nickname: ["小刻"]
+  logger: "console"
+plugins:
+  - name: "test-plugin"
+    enable: true
+limiters:
+  - name: "daily"
+    quota: 1
+`
+		_ = os.WriteFile(configPath, []byte(configContent), os.ModePerm)
+		ctx, _, _ := Initialize()
+
+		// disable plugin and add another one
+		reloadedContent := `
+bot:
+  nickname: ["小刻"]
+  logger: "console"
+plugins:
+  - name: "test-plugin"
+    enable: false
+  - name: "another-plugin"
+    enable: true
+limiters:
+  - name: "daily"
+    quota: 2
+  - name: "hourly"
+    interval: 1h
+    burst: 1
+`
+		_ = os.WriteFile(configPath, []byte(reloadedContent), os.ModePerm)
+		cfg, err := Reload(ctx)
+		if err != nil {
+			t.Fatalf("Expected config to be reloaded, but got error: %v", err)
+		}
+
+		if len(cfg.Plugins) != 2 || cfg.Plugins[0].Enable {
+			t.Errorf("Expected plugins to be reloaded, but it was not")
+		}
+
+		if _, exist := pluginConfigMap["another-plugin"]; !exist || len(pluginConfigMap) != 1 {
+			t.Errorf("Expected plugin mapping to be reloaded, but it was not")
+		}
+
+		if limit, declared := declaredLimiter("daily"); !declared || limit.Quota != 2 {
+			t.Errorf("Expected limiter to be reloaded, but got %v", limit)
+		}
+
+		if _, registered := limiters.Get("hourly"); !registered {
+			t.Errorf("Expected limiter added by reload to be registered, but it was not")
+		}
+	})
+
+	t.Run("ReloadInvalidConfig", func(t *testing.T) {
+		reset()
+
+		// Setup environment for this test
+		_ = os.Setenv("ci", "false")
+		_ = os.MkdirAll("config", os.ModePerm)
+		defer func() {
+			_ = os.RemoveAll("config")
+			_ = os.RemoveAll("logs")
+		}()
+
+		configPath := filepath.Join("config", "bot.yaml")
+		_ = os.WriteFile(configPath, []byte("bot:\n  logger: \"console\"\n"), os.ModePerm)
+		ctx, _, _ := Initialize()
+
+		// duplicate plugin name is not allowed
+		_ = os.WriteFile(configPath, []byte("plugins:\n  - name: a\n    enable: true\n  - name: a\n    enable: true\n"), os.ModePerm)
+		if _, err := Reload(ctx); err == nil {
+			t.Errorf("Expected reload to fail due to duplicate plugin, but it did not")
+		}
+
+		if len(coreConfig.Plugins) != 0 {
+			t.Errorf("Expected config to remain the same, but it was changed")
+		}
+
+		// config limiter cannot take the name of built-in limiter
+		_ = os.WriteFile(configPath, []byte("limiters:\n  - name: user\n    quota: 1\n"), os.ModePerm)
+		if _, err := Reload(ctx); err == nil {
+			t.Errorf("Expected reload to fail due to limiter conflict, but it did not")
+		}
+
+		if len(coreConfig.Limiters) != 0 {
+			t.Errorf("Expected limiters to remain the same, but they were changed")
+		}
+	})
+
+	t.Run("ReloadPartiallyInvalidPluginConfig", func(t *testing.T) {
+		reset()
+
+		// Setup environment for this test
+		_ = os.Setenv("ci", "false")
+		_ = os.MkdirAll("config", os.ModePerm)
+		defer func() {
+			_ = os.RemoveAll("config")
+			_ = os.RemoveAll("logs")
+		}()
+
+		configPath := filepath.Join("config", "bot.yaml")
+		_ = os.WriteFile(configPath, []byte("bot:\n  logger: \"console\"\nplugins:\n  - name: a\n    enable: true\n    config_file: a.yaml\n  - name: b\n    enable: true\n    config_file: b.yaml\n"), os.ModePerm)
+		_ = os.WriteFile(filepath.Join("config", "a.yaml"), []byte("key: first\nkept: default\n"), os.ModePerm)
+		_ = os.WriteFile(filepath.Join("config", "b.yaml"), []byte("key: first\n"), os.ModePerm)
+		configA, configB := map[string]string{}, map[string]string{}
+		RegisterPlugin("a", WithConfig(&configA))
+		RegisterPlugin("b", WithConfig(&configB))
+		ctx, _, _ := Initialize()
+
+		// b is invalid, so a must not be applied either
+		_ = os.WriteFile(filepath.Join("config", "a.yaml"), []byte("key: second\n"), os.ModePerm)
+		_ = os.WriteFile(filepath.Join("config", "b.yaml"), []byte("key: [invalid\n"), os.ModePerm)
+		if _, err := Reload(ctx); err == nil {
+			t.Errorf("Expected reload to fail due to invalid plugin config, but it did not")
+		}
+		if configA["key"] != "first" || configB["key"] != "first" {
+			t.Errorf("Expected plugin configs to remain the same, but got %v and %v", configA, configB)
+		}
+
+		_ = os.WriteFile(filepath.Join("config", "b.yaml"), []byte("key: second\n"), os.ModePerm)
+		if _, err := Reload(ctx); err != nil {
+			t.Fatalf("Expected config to be reloaded, but got error: %v", err)
+		}
+		if configA["key"] != "second" || configA["kept"] != "default" || configB["key"] != "second" {
+			t.Errorf("Expected plugin configs to be reloaded, but got %v and %v", configA, configB)
+		}
+	})
+
+	t.Run("ReloadKeepsRuntimeFields", func(t *testing.T) {
+		reset()
+
+		// Setup environment for this test
+		_ = os.Setenv("ci", "false")
+		_ = os.MkdirAll("config", os.ModePerm)
+		defer func() {
+			_ = os.RemoveAll("config")
+			_ = os.RemoveAll("logs")
+		}()
+
+		type runtimeConfig struct {
+			Key   string         `yaml:"key"`
+			Cache map[string]int `yaml:"-"`
+			count int
+		}
+
+		configPath := filepath.Join("config", "bot.yaml")
+		_ = os.WriteFile(configPath, []byte("bot:\n  logger: \"console\"\nplugins:\n  - name: a\n    enable: true\n    config_file: a.yaml\n"), os.ModePerm)
+		_ = os.WriteFile(filepath.Join("config", "a.yaml"), []byte("key: first\n"), os.ModePerm)
+		receiver := runtimeConfig{Cache: map[string]int{"hits": 1}, count: 2}
+		RegisterPlugin("a", WithConfig(&receiver))
+		ctx, _, _ := Initialize()
+
+		_ = os.WriteFile(filepath.Join("config", "a.yaml"), []byte("key: second\n"), os.ModePerm)
+		if _, err := Reload(ctx); err != nil {
+			t.Fatalf("Expected config to be reloaded, but got error: %v", err)
+		}
+
+		ReadConfig(func() {
+			if receiver.Key != "second" || receiver.Cache["hits"] != 1 || receiver.count != 2 {
+				t.Errorf("Expected runtime fields kept after reloading, but got %+v", receiver)
+			}
+		})
+	})
+}
+
+func TestStorage(t *testing.T) {
+	t.Run("MemoryStorageSweep", func(t *testing.T) {
+		storage := NewMemoryStorage().(*mapStorage)
+		_ = storage.Set("key", []byte("value"))
+		_ = storage.SetWithTTL("expired", []byte("value"), time.Nanosecond)
+		time.Sleep(time.Millisecond)
+
+		// the first write swept already, so expired entries stay until the next interval
+		_ = storage.Set("key", []byte("value"))
+		if _, exist := storage.entries["expired"]; !exist {
+			t.Errorf("Expected no sweep within interval, but expired entry was dropped")
+		}
+
+		storage.sweepAt = time.Now()
+		_ = storage.Set("key", []byte("value"))
+		if _, exist := storage.entries["expired"]; exist {
+			t.Errorf("Expected expired entry dropped by sweep, but it was kept")
+		}
+	})
+
+	t.Run("MemoryStorage", func(t *testing.T) {
+		storage := NewMemoryStorage()
+		_ = storage.Set("user:1", []byte("alice"))
+		_ = storage.Set("user:2", []byte("bob"))
+		_ = storage.Set("group:1", []byte("ceobe"))
+
+		if value, exist := storage.Get("user:1"); !exist || string(value) != "alice" {
+			t.Errorf("Expected value to be stored, but it was not")
+		}
+
+		if scanned := storage.Scan("user:"); len(scanned) != 2 {
+			t.Errorf("Expected 2 values to be scanned, but got %d", len(scanned))
+		}
+
+		_ = storage.Delete("user:1")
+		if _, exist := storage.Get("user:1"); exist {
+			t.Errorf("Expected value to be deleted, but it was not")
+		}
+
+		if ttl, exist := storage.TTL("user:2"); !exist || ttl != 0 {
+			t.Errorf("Expected value to never expire, but it was not")
+		}
+	})
+
+	t.Run("MemoryStorageExpired", func(t *testing.T) {
+		storage := NewMemoryStorage()
+		_ = storage.SetWithTTL("short", []byte("1"), time.Millisecond)
+		_ = storage.SetWithTTL("long", []byte("1"), time.Hour)
+
+		if ttl, exist := storage.TTL("long"); !exist || ttl <= 0 || ttl > time.Hour {
+			t.Errorf("Expected ttl to be set, but got %v", ttl)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		if _, exist := storage.Get("short"); exist {
+			t.Errorf("Expected value to be expired, but it was not")
+		}
+
+		if scanned := storage.Scan(""); len(scanned) != 1 {
+			t.Errorf("Expected expired value not to be scanned, but got %d values", len(scanned))
+		}
+	})
+
+	t.Run("FileStorage", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "plugin", "test.storage.db")
+		storage, openErr := NewFileStorage(path)
+		if openErr != nil {
+			t.Fatalf("Expected storage to be opened, but got error: %v", openErr)
+		}
+
+		_ = storage.Set("key", []byte("value"))
+
+		// reopen storage, value should be persisted
+		reopened, reopenErr := NewFileStorage(path)
+		if reopenErr != nil {
+			t.Fatalf("Expected storage to be reopened, but got error: %v", reopenErr)
+		}
+
+		if value, exist := reopened.Get("key"); !exist || string(value) != "value" {
+			t.Errorf("Expected value to be persisted, but it was not")
+		}
+
+		_ = reopened.SetWithTTL("user:1", []byte("alice"), time.Hour)
+		_ = reopened.SetWithTTL("user:2", []byte("bob"), time.Millisecond)
+		_ = reopened.Set("user_3", []byte("carol"))
+		_ = reopened.Set("user;4", []byte("dave"))
+		time.Sleep(5 * time.Millisecond)
+		if scanned := storage.Scan("user:"); len(scanned) != 1 || string(scanned["user:1"]) != "alice" {
+			t.Errorf("Expected only live values with prefix to be scanned, but got %v", scanned)
+		}
+		if ttl, exist := storage.TTL("user:1"); !exist || ttl <= 0 || ttl > time.Hour {
+			t.Errorf("Expected ttl to be persisted, but got %v", ttl)
+		}
+
+		_ = storage.Delete("key")
+		if _, exist := reopened.Get("key"); exist {
+			t.Errorf("Expected value to be deleted, but it was not")
+		}
+	})
+
+	t.Run("FileStorageInvalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "invalid.storage.db")
+		_ = os.WriteFile(path, []byte("invalid database"), os.ModePerm)
+
+		if _, openErr := NewFileStorage(path); openErr == nil {
+			t.Errorf("Expected error due to invalid storage file, but got nil")
+		}
+	})
+
+	t.Run("OpenStorageWithOption", func(t *testing.T) {
+		reset()
+
+		memory := NewMemoryStorage()
+		RegisterPlugin("storage-plugin", WithStorage(memory))
+
+		opened, openErr := OpenStorage(PluginConfig{Name: "storage-plugin"})
+		if openErr != nil || opened != memory {
+			t.Errorf("Expected storage set by option to be opened, but it was not")
+		}
+
+		ctx := ContextWithStorage(trace.NewContext(), opened)
+		if GetStorage(ctx) != memory {
+			t.Errorf("Expected storage to be attached to context, but it was not")
+		}
+
+		if GetStorage(trace.NewContext()) != nil {
+			t.Errorf("Expected storage to be nil, but it was not")
+		}
+	})
+}
+
+func TestSession(t *testing.T) {
+	newCtx := func(groupID, userID int64) *zero.Ctx {
+		return &zero.Ctx{Event: &zero.Event{GroupID: groupID, UserID: userID}, State: zero.State{}}
+	}
+
+	t.Run("Scope", func(t *testing.T) {
+		reset()
+
+		userSession, groupSession := Session(newCtx(1, 2)), Session(newCtx(1, 2), WithGroupScope())
+		if !userSession.inScope(newCtx(1, 2)) || userSession.inScope(newCtx(1, 3)) {
+			t.Errorf("Expected user session to accept only the same user, but it was not")
+		}
+
+		if !groupSession.inScope(newCtx(1, 3)) || groupSession.inScope(newCtx(4, 2)) {
+			t.Errorf("Expected group session to accept only the same group, but it was not")
+		}
+
+		if Session(newCtx(0, 2), WithGroupScope()).key() != "global/g0:u2" {
+			t.Errorf("Expected group scope to fallback to user scope in private chat, but it was not")
+		}
+	})
+
+	t.Run("Acquire", func(t *testing.T) {
+		reset()
+		coreConfig.Bot.Session.MaxConcurrent = 2
+
+		first, second, third := Session(newCtx(1, 1)), Session(newCtx(1, 2)), Session(newCtx(1, 3))
+		if first.acquire() != nil || second.acquire() != nil {
+			t.Errorf("Expected sessions to be acquired, but it was not")
+		}
+
+		if !errors.Is(Session(newCtx(1, 1)).acquire(), ErrSessionBusy) {
+			t.Errorf("Expected session to be busy, but it was not")
+		}
+
+		if !errors.Is(third.acquire(), ErrTooManySessions) {
+			t.Errorf("Expected too many sessions, but it was not")
+		}
+
+		first.release()
+		if third.acquire() != nil {
+			t.Errorf("Expected session to be acquired after release, but it was not")
+		}
+	})
+
+	t.Run("State", func(t *testing.T) {
+		reset()
+
+		conversation := Session(newCtx(1, 2), WithCancelWords("cancel"))
+		if len(conversation.cancelWords) != 1 {
+			t.Errorf("Expected cancel words to be set, but it was not")
+		}
+
+		_ = conversation.Store("step", "2")
+		if value, exist := Session(newCtx(1, 2)).Value("step"); !exist || value != "2" {
+			t.Errorf("Expected state to be shared in the same scope, but it was not")
+		}
+
+		if _, exist := Session(newCtx(1, 3)).Value("step"); exist {
+			t.Errorf("Expected state to be isolated by scope, but it was not")
+		}
+
+		_ = conversation.Close()
+		if _, exist := conversation.Value("step"); exist {
+			t.Errorf("Expected state to be cleared, but it was not")
+		}
+	})
+
+	t.Run("HandlerNamespace", func(t *testing.T) {
+		reset()
+
+		sessions := map[string]*Conversation{}
+		for _, handler := range []string{"a/order", "b/order"} {
+			plugin, name, _ := strings.Cut(handler, "/")
+			WithSessionScope(plugin, name, func(ctx *zero.Ctx) { sessions[handler] = Session(ctx) })(newCtx(1, 2))
+		}
+
+		_ = sessions["a/order"].Store("step", "1")
+		_ = sessions["b/order"].Store("step", "2")
+		if value, _ := sessions["a/order"].Value("step"); value != "1" {
+			t.Errorf("Expected state isolated by plugin, but got %s", value)
+		}
+
+		_ = sessions["b/order"].Close()
+		if _, exist := sessions["a/order"].Value("step"); !exist {
+			t.Errorf("Expected state of other plugin kept after closing, but it was cleared")
+		}
+
+		if sessions["a/order"].acquire() != nil || sessions["b/order"].acquire() != nil {
+			t.Errorf("Expected sessions of different handlers to be acquired together, but it was not")
+		}
+	})
+}
+
+func TestReply(t *testing.T) {
+	newCtx := func() *zero.Ctx {
+		return &zero.Ctx{
+			Event: &zero.Event{GroupID: 1, UserID: 2, Sender: &zero.User{NickName: "ceobe", Card: "小刻"}},
+			State: zero.State{"regex_matched": []string{"天气 北京", "北京"}},
+		}
+	}
+
+	t.Run("RenderTemplate", func(t *testing.T) {
+		handler, buildErr := NewReplyHandler(PluginConfig{Name: "reply"}, ReplyConfig{Text: "{{.Nickname}}@{{.GroupID}}: {{index .Matched 1}}"})
+		if buildErr != nil || handler == nil {
+			t.Fatalf("Expected reply handler to be built, but got error: %v", buildErr)
+		}
+
+		rendered := (&replyHandler{texts: mustParseTemplates(t, "{{.Nickname}}@{{.GroupID}}: {{index .Matched 1}}")}).render(newCtx())
+		if rendered.ExtractPlainText() != "小刻@1: 北京" {
+			t.Errorf("Expected reply to be rendered, but got %s", rendered.ExtractPlainText())
+		}
+	})
+
+	t.Run("RenderRandomAndImage", func(t *testing.T) {
+		handler := &replyHandler{texts: mustParseTemplates(t, "a", "b"), image: "https://example.com/x.png"}
+		rendered := handler.render(newCtx())
+		if len(rendered) != 2 || (rendered[0].Data["text"] != "a" && rendered[0].Data["text"] != "b") || rendered[1].Type != "image" {
+			t.Errorf("Expected random text and image to be rendered, but got %v", rendered)
+		}
+	})
+
+	t.Run("InvalidTemplate", func(t *testing.T) {
+		if _, buildErr := NewReplyHandler(PluginConfig{Name: "reply"}, ReplyConfig{Random: []string{"{{.Nickname"}}); buildErr == nil {
+			t.Errorf("Expected error due to invalid template, but got nil")
+		}
+	})
+
+	t.Run("TextWithRandom", func(t *testing.T) {
+		reply := ReplyConfig{Text: "a", Random: []string{"b", "c"}}
+		if _, buildErr := NewReplyHandler(PluginConfig{Name: "reply"}, reply); !errors.Is(buildErr, errReplyTextAndRandom) {
+			t.Errorf("Expected error due to text with random, but got %v", buildErr)
+		}
+
+		plugin := PluginConfig{Name: "reply", Handlers: []HandlerConfig{{Name: "hello", Reply: &reply}}}
+		if compileErr := compileTriggers(&plugin); !errors.Is(compileErr, errReplyTextAndRandom) {
+			t.Errorf("Expected config with text and random rejected at loading, but got %v", compileErr)
+		}
+	})
+
+	t.Run("ExecuteFailure", func(t *testing.T) {
+		rendered := (&replyHandler{texts: mustParseTemplates(t, "{{index .Matched 5}}")}).render(newCtx())
+		if len(rendered) != 0 {
+			t.Errorf("Expected nothing rendered when template fails, but got %v", rendered)
+		}
+	})
+}
+
+func mustParseTemplates(t *testing.T, sources ...string) (parsed []*template.Template) {
+	for _, source := range sources {
+		tmpl, parseErr := template.New("test").Parse(source)
+		if parseErr != nil {
+			t.Fatalf("Expected template to be parsed, but got error: %v", parseErr)
+		}
+
+		parsed = append(parsed, tmpl)
+	}
+
+	return parsed
+}
+
+func TestCommand(t *testing.T) {
+	type weatherArgs struct {
+		City   string        `arg:"city,pos,required"`
+		Target int64         `arg:"target,pos,mention"`
+		Days   int           `arg:"days" default:"1"`
+		Unit   string        `arg:"unit" enum:"c|f" default:"c"`
+		Every  time.Duration `arg:"every"`
+		Quiet  bool          `arg:"quiet"`
+		Ignore string
+	}
+	schema := newCommandSchema(reflect.TypeFor[weatherArgs]())
+
+	t.Run("ParseSuccess", func(t *testing.T) {
+		args := weatherArgs{}
+		parseErr := schema.parse("--days 3 北京 --unit=f --quiet --every 1h", []int64{114514}, reflect.ValueOf(&args).Elem())
+		if parseErr != nil {
+			t.Fatalf("Expected arguments to be parsed, but got error: %v", parseErr)
+		}
+
+		if args.City != "北京" || args.Target != 114514 || args.Days != 3 || args.Unit != "f" || args.Every != time.Hour || !args.Quiet {
+			t.Errorf("Expected arguments to be assigned, but got %+v", args)
+		}
+	})
+
+	t.Run("ParseDefault", func(t *testing.T) {
+		args := weatherArgs{}
+		if parseErr := schema.parse("北京 @1919810", nil, reflect.ValueOf(&args).Elem()); parseErr != nil {
+			t.Fatalf("Expected arguments to be parsed, but got error: %v", parseErr)
+		}
+
+		if args.Target != 1919810 || args.Days != 1 || args.Unit != "c" {
+			t.Errorf("Expected default arguments to be assigned, but got %+v", args)
+		}
+	})
+
+	t.Run("ParseInvalid", func(t *testing.T) {
+		for _, raw := range []string{"", "北京 --days x", "北京 --unit k", "北京 --unknown 1", "北京 --days", "北京 1 2"} {
+			if parseErr := schema.parse(raw, nil, reflect.ValueOf(&weatherArgs{}).Elem()); parseErr == nil {
+				t.Errorf("Expected error due to invalid arguments %q, but got nil", raw)
+			}
+		}
+	})
+
+	t.Run("RestText", func(t *testing.T) {
+		type echoArgs struct {
+			Text string `arg:"text,pos"`
+		}
+
+		args := echoArgs{}
+		_ = newCommandSchema(reflect.TypeFor[echoArgs]()).parse("hello  ceobe bot", nil, reflect.ValueOf(&args).Elem())
+		if args.Text != "hello ceobe bot" {
+			t.Errorf("Expected last string argument to take the rest text, but got %s", args.Text)
+		}
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		expected := "usage: /weather <city:string> [target:mention] [--days int=1] [--unit c|f=c] [--every duration] [--quiet]"
+		if usage := schema.usage("/weather"); usage != expected {
+			t.Errorf("Expected usage to be %s, but got %s", expected, usage)
+		}
+	})
+
+	t.Run("UnsupportedType", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Expected panic due to unsupported argument type, but did not panic")
+			}
+		}()
+
+		newCommandSchema(reflect.TypeFor[struct {
+			Values []string `arg:"values"`
+		}]())
+	})
+
+	t.Run("RegisterCommandHandler", func(t *testing.T) {
+		RegisterCommandHandler("weather-command", func(_ *zero.Ctx, _ weatherArgs) {})
+		if _, exist := handlers.Get("weather-command"); !exist {
+			t.Errorf("Expected command handler to be registered, but it was not")
+		}
+	})
+}
+
+func TestParams(t *testing.T) {
+	const pattern = `^(?P<city>\S+)天气(?:(?P<days>\d+)天)?$`
+
+	newCtx := func(raw string) *zero.Ctx {
+		return &zero.Ctx{Event: &zero.Event{}, State: zero.State{"regex_matched": regexp.MustCompile(pattern).FindStringSubmatch(raw)}}
+	}
+
+	t.Run("ExtractParams", func(t *testing.T) {
+		var params map[string]string
+		WithParams(pattern, func(ctx *zero.Ctx) { params = Params(ctx) })(newCtx("北京天气3天"))
+
+		if params["city"] != "北京" || params["days"] != "3" {
+			t.Errorf("Expected named groups to be extracted, but got %v", params)
+		}
+
+		if len(Params(newCtx("北京天气"))) != 0 {
+			t.Errorf("Expected params to be empty when not wrapped, but it was not")
+		}
+	})
+
+	t.Run("BindParams", func(t *testing.T) {
+		type weatherParams struct {
+			City string `param:"city,required"`
+			Days int    `param:"days" default:"1"`
+		}
+
+		specs := []argSpec{
+			newArgSpec(0, reflect.TypeFor[weatherParams]().Field(0), []string{"city", "required"}),
+			newArgSpec(1, reflect.TypeFor[weatherParams]().Field(1), []string{"days"}),
+		}
+
+		params := weatherParams{}
+		if bindErr := bindParams(map[string]string{"city": "北京"}, specs, reflect.ValueOf(&params).Elem()); bindErr != nil || params.City != "北京" || params.Days != 1 {
+			t.Errorf("Expected params to be bound with default, but got %+v, %v", params, bindErr)
+		}
+
+		if bindErr := bindParams(map[string]string{"city": "北京", "days": "x"}, specs, reflect.ValueOf(&weatherParams{}).Elem()); bindErr == nil {
+			t.Errorf("Expected error due to invalid param type, but got nil")
+		}
+
+		if bindErr := bindParams(map[string]string{}, specs, reflect.ValueOf(&weatherParams{}).Elem()); bindErr == nil {
+			t.Errorf("Expected error due to missing required param, but got nil")
+		}
+
+		RegisterRegexHandler("weather-regex", func(_ *zero.Ctx, _ weatherParams) {})
+	})
+
+	t.Run("CompileTriggers", func(t *testing.T) {
+		valid := &PluginConfig{Name: "valid", Handlers: []HandlerConfig{{Name: "h", Triggers: TriggerConfig{Regexes: []string{pattern}}}}}
+		if compileErr := compileTriggers(valid); compileErr != nil {
+			t.Errorf("Expected regex to be compiled, but got error: %v", compileErr)
+		}
+
+		invalid := &PluginConfig{Name: "invalid", Handlers: []HandlerConfig{{Name: "h", Triggers: TriggerConfig{Regexes: []string{"(?P<city"}}}}}
+		if compileErr := compileTriggers(invalid); compileErr == nil {
+			t.Errorf("Expected error due to invalid regex, but got nil")
+		}
+	})
+}
+
+func TestConflict(t *testing.T) {
+	plugins := []PluginConfig{
+		{Name: "sign", Handlers: []HandlerConfig{
+			{Name: "sign", Blocked: true, Triggers: TriggerConfig{Commands: []string{"签到"}, Prefixes: []string{"天气"}}},
+		}},
+		{Name: "another", Handlers: []HandlerConfig{
+			{Name: "sign", Triggers: TriggerConfig{Commands: []string{"签到"}}},
+			{Name: "weather", Triggers: TriggerConfig{FullMatches: []string{"天气预报"}, KeyWords: []string{"运势"}}},
+			{Name: "fortune", Triggers: TriggerConfig{Regexes: []string{`运势$`}, FullMatches: []string{"抽签"}}},
+		}},
+	}
+
+	conflicts := DetectConflicts(plugins, "/")
+	expected := []TriggerConflict{
+		{Kind: ConflictDuplicate, Trigger: "/签到", Winner: "sign/sign", Loser: "another/sign", Shadowed: true},
+		{Kind: ConflictOverlap, Trigger: "天气预报", Winner: "sign/sign", Loser: "another/weather", Shadowed: true},
+		{Kind: ConflictRegex, Trigger: "运势", Winner: "another/weather", Loser: "another/fortune"},
+	}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("Expected conflicts %v, but got %v", expected, conflicts)
+	}
+
+	if !strings.Contains(conflicts[0].String(), "never triggered") {
+		t.Errorf("Expected shadowed conflict to be described, but got %s", conflicts[0].String())
+	}
+}
+
+func TestEvent(t *testing.T) {
+	t.Run("NoticeRule", func(t *testing.T) {
+		poke := &zero.Ctx{Event: &zero.Event{NoticeType: "notify", SubType: "poke"}}
+		increase := &zero.Ctx{Event: &zero.Event{NoticeType: "group_increase", SubType: "approve"}}
+
+		if !NoticeRule("poke")(poke) || !NoticeRule("notify.poke")(poke) || NoticeRule("notify.lucky_king")(poke) {
+			t.Errorf("Expected notice rule to match sub type, but it was not")
+		}
+
+		if !NoticeRule("group_increase")(increase) || NoticeRule("group_recall")(increase) {
+			t.Errorf("Expected notice rule to match notice type, but it was not")
+		}
+	})
+
+	t.Run("RequestRule", func(t *testing.T) {
+		invite := &zero.Ctx{Event: &zero.Event{RequestType: "group", SubType: "invite"}}
+		if !RequestRule("group.invite")(invite) || RequestRule("group.add", "friend")(invite) {
+			t.Errorf("Expected request rule to match request type, but it was not")
+		}
+	})
+
+	t.Run("MetaRule", func(t *testing.T) {
+		heartbeat := &zero.Ctx{Event: &zero.Event{RawEvent: gjson.Parse(`{"post_type":"meta_event","meta_event_type":"heartbeat"}`)}}
+		if !MetaRule("heartbeat")(heartbeat) || MetaRule("lifecycle")(heartbeat) {
+			t.Errorf("Expected meta rule to match meta event type, but it was not")
+		}
+	})
+
+	t.Run("SegmentRule", func(t *testing.T) {
+		image := &zero.Ctx{Event: &zero.Event{DetailType: "group", Message: message.Message{message.Text("look"), message.Image("x.png")}}}
+		toMe := &zero.Ctx{Event: &zero.Event{DetailType: "group", IsToMe: true, Message: message.Message{message.Text("hi")}}}
+
+		if !SegmentRule("image")(image) || SegmentRule("reply")(image) {
+			t.Errorf("Expected segment rule to match segment type, but it was not")
+		}
+
+		if !SegmentRule("at_me")(toMe) || SegmentRule("at_me")(image) {
+			t.Errorf("Expected segment rule to match message sent to bot, but it was not")
+		}
+	})
+}
+
+func TestFuzzy(t *testing.T) {
+	reset()
+	initRegister()
+	newCtx := func(text string) *zero.Ctx {
+		return &zero.Ctx{Event: &zero.Event{DetailType: "group", Message: message.Message{message.Text(text)}}, State: zero.State{}}
+	}
+
+	t.Run("EditDistance", func(t *testing.T) {
+		cases := map[[2]string]int{{"签到", "签到"}: 0, {"签道", "签到"}: 1, {"今日运势", "运势"}: 2, {"", "abc"}: 3, {"kitten", "sitting"}: 3}
+		for pair, expected := range cases {
+			if distance := editDistance(pair[0], pair[1]); distance != expected {
+				t.Errorf("Expected distance of %v to be %d, but got %d", pair, expected, distance)
+			}
+		}
+	})
+
+	t.Run("Normalizers", func(t *testing.T) {
+		if normalized := normalizerOf([]string{"width", "lower", "space", "unknown"})("Ｈｅｌｌｏ　Ｗｏｒｌｄ"); normalized != "helloworld" {
+			t.Errorf("Expected normalized text to be helloworld, but got %s", normalized)
+		}
+		if normalized := normalizerOf([]string{"simplified"})("今日運勢"); normalized != "今日运势" {
+			t.Errorf("Expected traditional chinese to be simplified, but got %s", normalized)
+		}
+		if normalized := normalizerOf([]string{"pinyin"})("签道 ok"); normalized != normalizerOf([]string{"pinyin"})("签到 ok") || normalized != "qiandao ok" {
+			t.Errorf("Expected homophones to share pinyin, but got %s", normalized)
+		}
+
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Expected panic when registering duplicate normalizer, but it did not")
+			}
+		}()
+		RegisterNormalizer("lower", strings.ToLower)
+	})
+
+	t.Run("FuzzyRule", func(t *testing.T) {
+		triggers := TriggerConfig{
+			FullMatches: []string{"签到"},
+			Commands:    []string{"weather"},
+			Fuzzy:       &FuzzyConfig{Aliases: []string{"打卡"}, Threshold: 1, Normalizers: []string{"width", "lower"}},
+		}
+		rule := FuzzyRule(triggers, "/")
+
+		if rule(newCtx("签到")) || rule(newCtx("/weather 北京")) || rule(newCtx("/weather1")) {
+			t.Errorf("Expected exact matches to be left to exact triggers, but fuzzy rule matched")
+		}
+
+		handled := newCtx("签道")
+		MarkHandled(func(*zero.Ctx) {})(handled)
+		if rule(handled) {
+			t.Errorf("Expected handled events to be skipped, but fuzzy rule matched")
+		}
+
+		if !rule(newCtx("签道")) || !rule(newCtx("打卡")) || rule(newCtx("今日运势")) || rule(newCtx("")) {
+			t.Errorf("Expected fuzzy rule to match words within threshold, but it was not")
+		}
+
+		command := newCtx("／ＷＥＡＴＨＥＲ 北京")
+		if !rule(command) || command.State["command"] != "weather" || command.State["args"] != "北京" {
+			t.Errorf("Expected fuzzy command to set command and args, but got %v", command.State)
+		}
+
+		if rule(newCtx("weathr 北京")) || rule(newCtx("weather")) {
+			t.Errorf("Expected command without prefix not to be matched, but fuzzy rule matched")
+		}
+	})
+
+	t.Run("SuggestRule", func(t *testing.T) {
+		inGroup := func(groupID int64) zero.Rule {
+			return func(ctx *zero.Ctx) bool { return ctx.Event.GroupID == groupID }
+		}
+		targets := []SuggestTarget{
+			{Triggers: TriggerConfig{FullMatches: []string{"签到"}, Fuzzy: &FuzzyConfig{Suggest: 1}}},
+			{Triggers: TriggerConfig{FullMatches: []string{"今日运势"}, Fuzzy: &FuzzyConfig{Suggest: 2}}},
+			{Triggers: TriggerConfig{FullMatches: []string{"抽签"}}},
+			{Triggers: TriggerConfig{FullMatches: []string{"天气预报"}, Fuzzy: &FuzzyConfig{Suggest: 1}}, Enabled: inGroup(100)},
+		}
+		rule := SuggestRule(targets, "/")
+
+		suggested := newCtx("今日运")
+		if !rule(suggested) || Suggestion(suggested) != "今日运势" {
+			t.Errorf("Expected suggestion to be 今日运势, but got %s", Suggestion(suggested))
+		}
+
+		if rule(newCtx("签到")) || rule(newCtx("抽")) || rule(newCtx("你好呀朋友")) {
+			t.Errorf("Expected no suggestion for exact or distant messages, but it was suggested")
+		}
+
+		// single rune messages are too short to be suggested, even within the distance
+		if rule(newCtx("签")) {
+			t.Errorf("Expected no suggestion for too short messages, but it was suggested")
+		}
+
+		handled := newCtx("签道")
+		MarkHandled(func(*zero.Ctx) {})(handled)
+		if rule(handled) {
+			t.Errorf("Expected no suggestion for handled events, but it was suggested")
+		}
+
+		command := SuggestRule([]SuggestTarget{{Triggers: TriggerConfig{Commands: []string{"weather"}, Fuzzy: &FuzzyConfig{Suggest: 1}}}}, "/")
+		if prefixed := newCtx("/weathr"); !command(prefixed) || Suggestion(prefixed) != "/weather" {
+			t.Errorf("Expected command suggested with prefix, but got %s", Suggestion(prefixed))
+		}
+		if command(newCtx("weathr")) {
+			t.Errorf("Expected no command suggestion for message without prefix, but it was suggested")
+		}
+
+		disabled, enabled := newCtx("天气预"), newCtx("天气预")
+		enabled.Event.GroupID = 100
+		if rule(disabled) || !rule(enabled) || Suggestion(enabled) != "天气预报" {
+			t.Errorf("Expected suggestion only in chats where the handler is enabled, but got %s", Suggestion(enabled))
+		}
+	})
+}
+
+func TestGroups(t *testing.T) {
+	reset()
+	disabled := false
+
+	t.Run("EnabledIn", func(t *testing.T) {
+		groups := GroupsConfig{Allow: []int64{1, 2}, Deny: []int64{2, -10}}
+
+		if !groups.EnabledIn(1, &disabled) || groups.EnabledIn(2, nil) || groups.EnabledIn(-10, nil) {
+			t.Errorf("Expected listed groups to follow allow and deny, but it was not")
+		}
+
+		if !groups.EnabledIn(3, nil) || groups.EnabledIn(3, &disabled) {
+			t.Errorf("Expected other groups to follow default enabled, but it was not")
+		}
+
+		if listed := groups.Listed(); !reflect.DeepEqual(listed, []int64{1, 2, 2, -10}) {
+			t.Errorf("Expected listed groups to be [1 2 2 -10], but got %v", listed)
+		}
+	})
+
+	t.Run("HandlerEnabledRule", func(t *testing.T) {
+		pluginConfigMap["test"] = &PluginConfig{Name: "test", Handlers: []HandlerConfig{
+			{Name: "only_allowed", DefaultEnabled: &disabled, Groups: GroupsConfig{Allow: []int64{100}}},
+			{Name: "open"},
+		}}
+		inGroup := func(gid, uid int64) *zero.Ctx {
+			return &zero.Ctx{Event: &zero.Event{GroupID: gid, UserID: uid}}
+		}
+
+		rule := HandlerEnabledRule("test", "only_allowed")
+		if !rule(inGroup(100, 1)) || rule(inGroup(200, 1)) || rule(inGroup(0, 1)) {
+			t.Errorf("Expected handler to be enabled only in allowed group, but it was not")
+		}
+
+		if !HandlerEnabledRule("test", "open")(inGroup(200, 1)) || !HandlerEnabledRule("missing", "open")(inGroup(200, 1)) {
+			t.Errorf("Expected handler without group config to be enabled, but it was not")
+		}
+
+		// reloaded config takes effect without rebinding
+		pluginConfigMap["test"].Handlers[0].Groups.Allow = []int64{200}
+		if rule(inGroup(100, 1)) || !rule(inGroup(200, 1)) {
+			t.Errorf("Expected handler to follow updated config, but it was not")
+		}
+	})
+}
+
+func TestPermission(t *testing.T) {
+	reset()
+	permissionStorageOnce.Do(func() { permissionStorage = NewMemoryStorage() })
+	coreConfig.Roles = []RoleConfig{
+		{Name: "admin", Includes: []string{"moderator"}},
+		{Name: "moderator", Includes: []string{"helper", "admin"}},
+		{Name: "helper"},
+	}
+
+	t.Run("ParseRoleRule", func(t *testing.T) {
+		if role, isRoleRule := ParseRoleRule("has_role( moderator )"); !isRoleRule || role != "moderator" {
+			t.Errorf("Expected role rule of moderator, but got %s, %v", role, isRoleRule)
+		}
+
+		if _, isRoleRule := ParseRoleRule("only_group"); isRoleRule {
+			t.Errorf("Expected only_group not to be a role rule, but it was")
+		}
+	})
+
+	t.Run("GrantAndRevoke", func(t *testing.T) {
+		if grantErr := Grant("unknown", 1, 0); !errors.Is(grantErr, ErrRoleNotFound) {
+			t.Errorf("Expected role not found error, but got %v", grantErr)
+		}
+
+		_ = Grant("helper", 1, 100)
+		_ = Grant("admin", 2, 0)
+		if !HasRole(1, 100, "helper") || HasRole(1, 200, "helper") || HasRole(1, 100, "moderator") {
+			t.Errorf("Expected group grant to take effect only in the group, but it was not")
+		}
+
+		if !HasRole(2, 300, "helper") || !HasRole(2, 0, "moderator") {
+			t.Errorf("Expected global admin to hold included roles in any group, but it was not")
+		}
+
+		if grants := Grants(0, 100); len(grants) != 2 {
+			t.Errorf("Expected 2 grants visible in group 100, but got %v", grants)
+		}
+
+		_ = Revoke("helper", 1, 100)
+		if HasRole(1, 100, "helper") {
+			t.Errorf("Expected role to be revoked, but it was not")
+		}
+
+		// grants of roles removed from config can still be revoked
+		_ = openPermissionStorage().Set(grantKey("removed", 3, 0), []byte("removed"))
+		if revokeErr := Revoke("removed", 3, 0); revokeErr != nil || len(Grants(3, 0)) != 0 {
+			t.Errorf("Expected grant of removed role revoked, but got %v, %v", revokeErr, Grants(3, 0))
+		}
+	})
+
+	t.Run("GrantsByUser", func(t *testing.T) {
+		_ = Grant("helper", 12, 0)
+		_ = Grant("helper", 120, 0)
+		if grants := Grants(12, 0); len(grants) != 1 || grants[0].UserID != 12 {
+			t.Errorf("Expected only grants of user 12, but got %v", grants)
+		}
+	})
+
+	t.Run("HasRoleRule", func(t *testing.T) {
+		zero.BotConfig.SuperUsers = []int64{9}
+		defer func() { zero.BotConfig.SuperUsers = nil }()
+
+		rule := HasRoleRule("helper")
+		if !rule(&zero.Ctx{Event: &zero.Event{UserID: 9, GroupID: 1}}) || rule(&zero.Ctx{Event: &zero.Event{UserID: 3, GroupID: 1}}) {
+			t.Errorf("Expected bot owner to hold all roles and others not, but it was not")
+		}
+	})
+}
+
+func TestBlocklist(t *testing.T) {
+	reset()
+	blocklistStorageOnce.Do(func() { blocklistStorage, blocked = NewMemoryStorage(), map[string]time.Time{} })
+	coreConfig.Blocklist = BlocklistConfig{Users: []int64{1}, Groups: []int64{100}}
+	zero.BotConfig.SuperUsers = []int64{9}
+	defer func() { zero.BotConfig.SuperUsers = nil }()
+
+	t.Run("Seeded", func(t *testing.T) {
+		if !IsBlocked(1, 0) || !IsBlocked(2, 100) || IsBlocked(2, 200) || IsBlocked(9, 100) {
+			t.Errorf("Expected seeded entries to be blocked except bot owners, but it was not")
+		}
+	})
+
+	t.Run("BlockAndUnblock", func(t *testing.T) {
+		if blockErr := Block("channel", 1, 0); blockErr == nil {
+			t.Errorf("Expected error when blocking invalid kind, but got nil")
+		}
+
+		_ = Block(BlockUser, 3, 0)
+		_ = Block(BlockGroup, 300, 50*time.Millisecond)
+		if NotBlocked(&zero.Ctx{Event: &zero.Event{UserID: 3}}) || !IsBlocked(4, 300) {
+			t.Errorf("Expected stored entries to be blocked, but it was not")
+		}
+
+		entries := Blocks()
+		if len(entries) != 4 || !entries[0].Seeded || entries[2].Kind != BlockGroup || entries[2].ExpiresIn <= 0 {
+			t.Errorf("Expected seeded and stored entries listed, but got %v", entries)
+		}
+
+		if unblockErr := Unblock(BlockUser, 1); !errors.Is(unblockErr, ErrSeededBlock) || !IsBlocked(1, 0) {
+			t.Errorf("Expected seeded entry not removable, but got %v", unblockErr)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		_ = Unblock(BlockUser, 3)
+		if IsBlocked(3, 0) || IsBlocked(4, 300) {
+			t.Errorf("Expected unblocked and expired entries not to be blocked, but it was")
+		}
+	})
+
+	t.Run("LoadStored", func(t *testing.T) {
+		storage := NewMemoryStorage()
+		_ = storage.Set(blockKey(BlockUser, 5), []byte(BlockUser))
+		_ = storage.SetWithTTL(blockKey(BlockGroup, 500), []byte(BlockGroup), time.Hour)
+		_ = storage.Set("other", []byte("value"))
+
+		loaded := loadBlocked(storage)
+		if len(loaded) != 2 || !loaded[blockKey(BlockUser, 5)].IsZero() || time.Until(loaded[blockKey(BlockGroup, 500)]) <= 0 {
+			t.Errorf("Expected stored entries loaded with expiry, but got %v", loaded)
+		}
+	})
+}
+
+func TestLimited(t *testing.T) {
+	reset()
+	coreConfig.Bot.OnLimited = map[string]OnLimitedConfig{"user": {Mode: LimitedReply, Text: "slow down"}}
+
+	t.Run("ResolveOnLimited", func(t *testing.T) {
+		handlerConfig := &OnLimitedConfig{Mode: LimitedSilent}
+		if resolved := ResolveOnLimited(HandlerConfig{Limiter: "user", OnLimited: handlerConfig}); resolved != handlerConfig {
+			t.Errorf("Expected handler config to take precedence, but got %v", resolved)
+		}
+
+		if resolved := ResolveOnLimited(HandlerConfig{Limiter: "user"}); resolved == nil || resolved.Text != "slow down" {
+			t.Errorf("Expected limiter config to be used, but got %v", resolved)
+		}
+
+		if resolved := ResolveOnLimited(HandlerConfig{Limiter: "group"}); resolved != nil {
+			t.Errorf("Expected nil config for limiter without feedback, but got %v", resolved)
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		if text := limitedText(&OnLimitedConfig{Mode: LimitedCooldown}, 3*time.Second); text != "too many requests, please retry after 3s" {
+			t.Errorf("Expected default cooldown text, but got %s", text)
+		}
+
+		if text := limitedText(&OnLimitedConfig{Mode: LimitedReply, Text: "wait {cooldown}"}, time.Minute); text != "wait 1m0s" {
+			t.Errorf("Expected placeholder replaced, but got %s", text)
+		}
+	})
+
+	t.Run("Cooldown", func(t *testing.T) {
+		limiter := rate.NewLimiter(10*time.Second, 1)
+		if cooldown := cooldownOf(limiter, 10*time.Second); cooldown != 0 {
+			t.Errorf("Expected no cooldown with tokens left, but got %s", cooldown)
+		}
+
+		limiter.Acquire()
+		if cooldown := cooldownOf(limiter, 10*time.Second); cooldown < 9*time.Second || cooldown > 10*time.Second {
+			t.Errorf("Expected cooldown about 10s, but got %s", cooldown)
+		}
+
+		if cooldown := cooldownOf(limiter, 0); cooldown != 0 {
+			t.Errorf("Expected no cooldown of unknown interval, but got %s", cooldown)
+		}
+	})
+
+	t.Run("LimiterInterval", func(t *testing.T) {
+		coreConfig.Limiters = []LimiterConfig{{Name: "bucket", Interval: 2 * time.Second, Burst: 3}, {Name: "daily", Quota: 1}}
+		defer func() { coreConfig.Limiters = nil }()
+
+		if LimiterInterval("user") != builtinLimiterInterval || LimiterInterval("bucket") != 2*time.Second {
+			t.Errorf("Expected intervals of built-in and declared limiters, but got %s and %s", LimiterInterval("user"), LimiterInterval("bucket"))
+		}
+		if LimiterInterval("daily") != 0 || LimiterInterval("custom") != 0 {
+			t.Errorf("Expected no interval of quota and unknown limiters, but it was not")
+		}
+	})
+
+	t.Run("MessageOnly", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("Expected no notice sent for non message events, but it was sent: %v", r)
+			}
+		}()
+
+		// ctx without api caller panics when sending
+		notify := LimitedNotifier(&OnLimitedConfig{Mode: LimitedReply, Text: "slow down"}, func(*zero.Ctx) *rate.Limiter { return rate.NewLimiter(time.Second, 1) }, time.Second)
+		notify(&zero.Ctx{Event: &zero.Event{PostType: "notice", GroupID: 1, UserID: 2}, State: zero.State{}})
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	reset()
+	initRegister()
+
+	t.Run("Validate", func(t *testing.T) {
+		invalids := []LimiterConfig{
+			{Quota: 3},
+			{Name: "empty"},
+			{Name: "scope", Quota: 3, By: "channel"},
+			{Name: "reset", Quota: 3, ResetAt: "25:00"},
+		}
+		for _, invalid := range invalids {
+			if invalid.validate() == nil {
+				t.Errorf("Expected limiter %v to be invalid, but it was not", invalid)
+			}
+		}
+
+		if validateErr := (LimiterConfig{Name: "daily", Quota: 3, ResetAt: "04:00"}).validate(); validateErr != nil {
+			t.Errorf("Expected limiter to be valid, but got %v", validateErr)
+		}
+	})
+
+	t.Run("Period", func(t *testing.T) {
+		limit := LimiterConfig{Name: "daily", Quota: 3, ResetAt: "04:00"}
+		now := time.Date(2024, 6, 2, 3, 0, 0, 0, time.Local)
+		start, end := limit.period(now)
+		if !start.Equal(time.Date(2024, 6, 1, 4, 0, 0, 0, time.Local)) || !end.Equal(time.Date(2024, 6, 2, 4, 0, 0, 0, time.Local)) {
+			t.Errorf("Expected period from yesterday 04:00 to today 04:00, but got %s - %s", start, end)
+		}
+	})
+
+	t.Run("Quota", func(t *testing.T) {
+		limit := LimiterConfig{Name: "daily", Quota: 2}
+		backend := NewStorageLimiterBackend(NewMemoryStorage())
+		now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.Local)
+		for i := 0; i < 2; i++ {
+			if allowed, _, _ := backend.Take("k", limit, now); !allowed {
+				t.Errorf("Expected request %d to be allowed, but it was not", i)
+			}
+		}
+
+		allowed, retryAfter, _ := backend.Take("k", limit, now)
+		if allowed || retryAfter != 12*time.Hour {
+			t.Errorf("Expected request to be denied until midnight, but got %v, %s", allowed, retryAfter)
+		}
+
+		if allowed, _, _ = backend.Take("k", limit, now.Add(12*time.Hour)); !allowed {
+			t.Errorf("Expected quota to be reset in next day, but it was not")
+		}
+	})
+
+	t.Run("Bucket", func(t *testing.T) {
+		limit := LimiterConfig{Name: "bucket", Interval: time.Second, Burst: 1}
+		backend := NewStorageLimiterBackend(NewMemoryStorage())
+		now := time.Now()
+		if allowed, _, _ := backend.Take("k", limit, now); !allowed {
+			t.Errorf("Expected first request to be allowed, but it was not")
+		}
+
+		if allowed, retryAfter, _ := backend.Take("k", limit, now); allowed || retryAfter != time.Second {
+			t.Errorf("Expected request to be denied for 1s, but got %v, %s", allowed, retryAfter)
+		}
+
+		if remaining, _ := backend.Remaining("k", limit, now.Add(time.Second)); remaining != 1 {
+			t.Errorf("Expected token refilled after interval, but got %f", remaining)
+		}
+	})
+
+	t.Run("Persistence", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "limiter.storage.db")
+		limit := LimiterConfig{Name: "daily", Quota: 1}
+		storage, _ := NewFileStorage(path)
+		_, _, _ = NewStorageLimiterBackend(storage).Take("k", limit, time.Now())
+
+		// reopen the file as restarting the bot
+		reopened, _ := NewFileStorage(path)
+		if allowed, _, _ := NewStorageLimiterBackend(reopened).Take("k", limit, time.Now()); allowed {
+			t.Errorf("Expected quota to survive restarting, but it was reset")
+		}
+	})
+
+	t.Run("NewLimiter", func(t *testing.T) {
+		coreConfig.Limiters = []LimiterConfig{{Name: "daily", Quota: 1, By: LimitByUserGroup}}
+		registerLimiters()
+		limiter, registered := limiters.Get("daily")
+		if !registered {
+			t.Fatalf("Expected limiter declared in config to be registered, but it was not")
+		}
+
+		ctx := &zero.Ctx{Event: &zero.Event{UserID: 1, GroupID: 2}, State: zero.State{}}
+		if !limiter(ctx).Acquire() || limiter(ctx).Acquire() {
+			t.Errorf("Expected only first request to be allowed, but it was not")
+		}
+
+		if _, exist := ctx.State[retryAfterStateKey].(time.Duration); !exist {
+			t.Errorf("Expected retry after stored in state, but it was not")
+		}
+
+		if remaining, declared := LimiterRemaining("daily", 1, 3); !declared || remaining != 1 {
+			t.Errorf("Expected quota of other group untouched, but got %f, %v", remaining, declared)
+		}
+	})
+
+	t.Run("LiveConfig", func(t *testing.T) {
+		coreConfig.Limiters = []LimiterConfig{{Name: "live", Quota: 1}}
+		registerLimiters()
+		limiter, _ := limiters.Get("live")
+		ctx := &zero.Ctx{Event: &zero.Event{UserID: 1}, State: zero.State{}}
+		if !limiter(ctx).Acquire() || limiter(ctx).Acquire() {
+			t.Errorf("Expected only first request to be allowed, but it was not")
+		}
+
+		// raise the quota as reloading, the registered limiter must follow it
+		coreConfig.Limiters = []LimiterConfig{{Name: "live", Quota: 2}}
+		registerLimiters()
+		if !limiter(ctx).Acquire() || limiter(ctx).Acquire() {
+			t.Errorf("Expected raised quota to be applied, but it was not")
+		}
+
+		coreConfig.Limiters = nil
+		if !limiter(ctx).Acquire() {
+			t.Errorf("Expected removed limiter to allow requests, but it did not")
+		}
+	})
+
+	t.Run("CheckLimiters", func(t *testing.T) {
+		if checkLimiters([]LimiterConfig{{Name: "user", Quota: 1}}) == nil {
+			t.Errorf("Expected limiter named as built-in limiter to be rejected, but it was not")
+		}
+
+		if checkLimiters([]LimiterConfig{{Name: "twice", Quota: 1}, {Name: "twice", Quota: 2}}) == nil {
+			t.Errorf("Expected duplicate limiter to be rejected, but it was not")
+		}
+
+		if checkErr := checkLimiters([]LimiterConfig{{Name: "live", Quota: 3}}); checkErr != nil {
+			t.Errorf("Expected limiter registered from config to be redeclared, but got %v", checkErr)
+		}
+	})
+
+	t.Run("Buffered", func(t *testing.T) {
+		limit := LimiterConfig{Name: "daily", Quota: 1}
+		storage := NewMemoryStorage()
+		backend := newBufferedLimiterBackend(storage, 10*time.Millisecond)
+		if allowed, _, _ := backend.Take("k", limit, time.Now()); !allowed {
+			t.Errorf("Expected first request to be allowed, but it was not")
+		}
+
+		if allowed, _, _ := backend.Take("k", limit, time.Now()); allowed {
+			t.Errorf("Expected pending state to be used before flushing, but it was not")
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for _, exist := storage.Get("k"); !exist && time.Now().Before(deadline); _, exist = storage.Get("k") {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if _, exist := storage.Get("k"); !exist {
+			t.Errorf("Expected state to be flushed to storage, but it was not")
+		}
+	})
+
+	t.Run("FlushOnExit", func(t *testing.T) {
+		limit := LimiterConfig{Name: "daily", Quota: 1}
+		storage := NewMemoryStorage()
+		limiterBackends.Set(LimiterBackendFile, newBufferedLimiterBackend(storage, time.Hour))
+		_, _, _ = openLimiterBackend(LimiterBackendFile).Take("k", limit, time.Now())
+		if _, exist := storage.Get("k"); exist {
+			t.Fatalf("Expected state pending before exit, but it was written")
+		}
+
+		flushFileLimiter("")
+		if _, exist := storage.Get("k"); !exist {
+			t.Errorf("Expected pending state to be flushed on exit, but it was not")
+		}
+	})
+}
+
+// stubDriver deliver events pushed to it and answer every api call with ok
+type stubDriver struct {
+	selfID  int64
+	handler func([]byte, zero.APICaller)
+}
+
+func (d *stubDriver) Connect() {
+	zero.APICallers.Store(d.selfID, d)
+}
+
+func (d *stubDriver) Listen(handler func([]byte, zero.APICaller)) {
+	d.handler = handler
+}
+
+func (d *stubDriver) CallApi(zero.APIRequest) (zero.APIResponse, error) {
+	return zero.APIResponse{Status: "ok"}, nil
+}
+
+func TestRecorder(t *testing.T) {
+	reset()
+	coreLogger = logger.New()
+
+	t.Run("Redact", func(t *testing.T) {
+		redacted := redact([]byte(`{"user_id":2,"group_id":100,"message":"secret","sender":{"user_id":2,"nickname":"alice"}}`), []string{"user_id", "message", "sender"})
+		result := gjson.ParseBytes(redacted)
+		if result.Get("message").String() != RedactedText || result.Get("sender.nickname").String() != RedactedText {
+			t.Errorf("Expected message and sender redacted, but got %s", redacted)
+		}
+		if result.Get("user_id").Int() == 2 || result.Get("user_id").Int() != result.Get("sender.user_id").Int() {
+			t.Errorf("Expected user id masked consistently, but got %s", redacted)
+		}
+		if result.Get("group_id").Int() != 100 {
+			t.Errorf("Expected group id kept, but got %s", redacted)
+		}
+
+		segments := gjson.ParseBytes(redact([]byte(`{"message":[{"type":"at","data":{"qq":"5"}},{"type":"text","data":{"text":"secret"}}]}`), []string{"message"}))
+		if segments.Get("message.0.type").String() != "at" || segments.Get("message.1.type").String() != "text" {
+			t.Errorf("Expected segment types kept, but got %s", segments.Raw)
+		}
+		if segments.Get("message.0.data.qq").String() != RedactedText || segments.Get("message.1.data.text").String() != RedactedText {
+			t.Errorf("Expected segment data redacted, but got %s", segments.Raw)
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "record.jsonl")
+		recorder, openErr := NewRecorder(RecorderConfig{Path: path, MaxFiles: 2})
+		if openErr != nil {
+			t.Fatalf("Expected recorder opened, but got %v", openErr)
+		}
+		defer func() { _ = recorder.Close() }()
+
+		// rotate after every record
+		recorder.maxSize = 1
+		for i := 0; i < 4; i++ {
+			_ = recorder.RecordEvent(1, []byte(`{"post_type":"message"}`))
+		}
+
+		for _, rotated := range []string{path, path + ".1", path + ".2"} {
+			if records, readErr := ReadRecords(rotated); readErr != nil || len(records) != 1 {
+				t.Errorf("Expected 1 record in %s, but got %d, %v", rotated, len(records), readErr)
+			}
+		}
+		if _, statErr := os.Stat(path + ".3"); !errors.Is(statErr, os.ErrNotExist) {
+			t.Errorf("Expected files beyond max files removed, but got %v", statErr)
+		}
+	})
+
+	t.Run("Driver", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "record.jsonl")
+		recorder, _ := NewRecorder(RecorderConfig{Path: path, Redact: []string{"message"}})
+		defer func() { _ = recorder.Close() }()
+
+		stub := &stubDriver{selfID: 20000}
+		recording := NewRecordingDriver(stub, recorder)
+		recording.Connect()
+		recording.Listen(func(payload []byte, caller zero.APICaller) {
+			_, _ = caller.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": 100, "message": "pong"}})
+		})
+		stub.handler([]byte(`{"self_id":20000,"post_type":"message","message":"ping"}`), stub)
+
+		records, _ := ReadRecords(path)
+		if len(records) != 2 || records[0].Kind != RecordEvent || records[1].Kind != RecordAction || records[1].Action != "send_group_msg" {
+			t.Fatalf("Expected event and action recorded, but got %v", records)
+		}
+		if records[0].SelfID != 20000 || gjson.GetBytes(records[1].Data, "message").String() != RedactedText {
+			t.Errorf("Expected self id recorded and message redacted, but got %s", records[1].Data)
+		}
+		if caller, _ := zero.APICallers.Load(20000); reflect.TypeOf(caller) != reflect.TypeOf(&recordingCaller{}) {
+			t.Errorf("Expected api caller wrapped, but got %T", caller)
+		}
+	})
+}
+
+func TestWebsocket(t *testing.T) {
+	reset()
+	coreLogger = logger.New()
+
+	events := make(chan LifecycleEvent, 8)
+	RegisterPlugin("lifecycle", WithLifecycle(func(event LifecycleEvent) { events <- event }))
+	coreConfig.Plugins = []PluginConfig{{Name: "lifecycle", Enable: true}}
+	expectEvent := func(t *testing.T, eventType string) LifecycleEvent {
+		t.Helper()
+
+		select {
+		case event := <-events:
+			if event.Type != eventType {
+				t.Fatalf("Expected %s event, but got %v", eventType, event)
+			}
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %s event, but timeout", eventType)
+			return LifecycleEvent{}
+		}
+	}
+
+	t.Run("Backoff", func(t *testing.T) {
+		reconnect := ReconnectConfig{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: 0.5}
+		if delay := reconnect.backoff(1, 0.5); delay != time.Second {
+			t.Errorf("Expected first backoff 1s, but got %s", delay)
+		}
+		if delay := reconnect.backoff(3, 0.5); delay != 4*time.Second {
+			t.Errorf("Expected third backoff 4s, but got %s", delay)
+		}
+		if delay := reconnect.backoff(10, 0.5); delay != 5*time.Second {
+			t.Errorf("Expected backoff limited to 5s, but got %s", delay)
+		}
+		if delay := reconnect.backoff(1, 0); delay != 500*time.Millisecond {
+			t.Errorf("Expected jitter to reduce backoff by half, but got %s", delay)
+		}
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		server := devserver.NewServer(30000, "")
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+
+		url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+		client := NewWebsocketClient(url, "", ReconnectConfig{InitialBackoff: 10 * time.Millisecond})
+		metas := make(chan string, 8)
+		expectMeta := func(t *testing.T, metaType string) {
+			t.Helper()
+
+			select {
+			case handled := <-metas:
+				if handled != metaType {
+					t.Fatalf("Expected %s meta event handled, but got %s", metaType, handled)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Expected %s meta event handled, but timeout", metaType)
+			}
+		}
+
+		client.Connect()
+		go client.Listen(func(payload []byte, _ zero.APICaller) {
+			if event := gjson.ParseBytes(payload); event.Get("post_type").Str == "meta_event" {
+				metas <- event.Get("meta_event_type").Str
+			}
+		})
+		if event := expectEvent(t, LifecycleConnect); event.SelfID != 30000 {
+			t.Errorf("Expected bot 30000 connected, but got %d", event.SelfID)
+		}
+		expectMeta(t, "lifecycle")
+
+		_ = server.Inject(map[string]any{"post_type": "meta_event", "meta_event_type": "heartbeat"})
+		expectMeta(t, "heartbeat")
+		if health := Health(); health.Connected != 1 || health.Bots[0].LastHeartbeat.IsZero() {
+			t.Errorf("Expected connected bot with heartbeat, but got %v", health)
+		}
+
+		// drop the connection, the server is still serving
+		_ = server.Close()
+		expectEvent(t, LifecycleDisconnect)
+		expectEvent(t, LifecycleConnect)
+		expectMeta(t, "lifecycle")
+		if health := Health(); health.Connected != 1 || health.Bots[0].Reconnects != 1 {
+			t.Errorf("Expected bot reconnected once, but got %v", health)
+		}
+	})
+
+	t.Run("Endpoint", func(t *testing.T) {
+		cases := []struct {
+			config   WebsocketConfig
+			expected string
+		}{
+			{WebsocketConfig{Host: "127.0.0.1", Port: 6700}, "ws://127.0.0.1:6700"},
+			{WebsocketConfig{Scheme: "wss", Host: "bot.example.com", Port: 443, Path: "onebot"}, "wss://bot.example.com:443/onebot"},
+			{WebsocketConfig{URL: "wss://bot.example.com/onebot/ws", Host: "127.0.0.1", Port: 6700}, "wss://bot.example.com/onebot/ws"},
+		}
+		for _, c := range cases {
+			if endpoint, endpointErr := c.config.Endpoint(); endpoint != c.expected || endpointErr != nil {
+				t.Errorf("Expected endpoint %s, but got %s, %v", c.expected, endpoint, endpointErr)
+			}
+		}
+
+		if _, endpointErr := (WebsocketConfig{URL: "http://bot.example.com"}).Endpoint(); endpointErr == nil {
+			t.Errorf("Expected http url to be invalid, but it was not")
+		}
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		server := devserver.NewServer(30002, "")
+		httpServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/onebot" || r.Header.Get("X-Proxy-Token") != "proxy" {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			server.ServeHTTP(w, r)
+		}))
+		defer httpServer.Close()
+
+		// trust the certificate of test server by ca file
+		caPath := filepath.Join(t.TempDir(), "ca.pem")
+		_ = os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpServer.Certificate().Raw}), 0o644)
+
+		client, clientErr := NewWebsocketClientWithConfig(WebsocketConfig{
+			URL:       "wss" + strings.TrimPrefix(httpServer.URL, "https") + "/onebot",
+			Headers:   map[string]string{"X-Proxy-Token": "proxy"},
+			TLS:       TLSConfig{CA: caPath, ServerName: "example.com"},
+			Reconnect: ReconnectConfig{InitialBackoff: time.Millisecond, MaxAttempts: 1},
+		})
+		if clientErr != nil {
+			t.Fatalf("Expected client created, but got %v", clientErr)
+		}
+
+		client.Connect()
+		if event := expectEvent(t, LifecycleConnect); event.SelfID != 30002 {
+			t.Errorf("Expected bot 30002 connected over tls, but got %d", event.SelfID)
+		}
+
+		if _, tlsErr := (TLSConfig{CA: filepath.Join(t.TempDir(), "missing.pem")}).build(); tlsErr == nil {
+			t.Errorf("Expected missing ca to be invalid, but it was not")
+		}
+	})
+
+	t.Run("GiveUp", func(t *testing.T) {
+		httpServer := httptest.NewServer(devserver.NewServer(30001, ""))
+		url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+		httpServer.Close()
+
+		client := NewWebsocketClient(url, "", ReconnectConfig{InitialBackoff: time.Millisecond, MaxAttempts: 2})
+		client.Connect()
+		if event := expectEvent(t, LifecycleGiveUp); event.Attempt != 2 {
+			t.Errorf("Expected give up after 2 attempts, but got %d", event.Attempt)
+		}
+
+		if _, callErr := client.CallApi(zero.APIRequest{Action: "get_login_info"}); callErr == nil {
+			t.Errorf("Expected api call failed after giving up, but it succeeded")
+		}
+	})
+}
+
+func TestHTTPDriver(t *testing.T) {
+	reset()
+	coreLogger = logger.New()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		params := gjson.ParseBytes(body)
+		switch r.URL.Path {
+		case "/get_login_info":
+			_, _ = w.Write([]byte(`{"status":"ok","retcode":0,"data":{"user_id":40000,"nickname":"ceobe"}}`))
+		case "/send_group_msg":
+			_, _ = w.Write([]byte(`{"status":"ok","retcode":0,"data":{"message_id":` + params.Get("group_id").String() + `}}`))
+		default:
+			_, _ = w.Write([]byte(`{"status":"failed","retcode":1404,"msg":"API_NOT_FOUND"}`))
+		}
+	}))
+	defer api.Close()
+
+	if _, configErr := newDriver(WebsocketConfig{Mode: ModeHTTP}); configErr == nil {
+		t.Errorf("Expected http mode without api to be invalid, but it was not")
+	}
+	if _, configErr := newDriver(WebsocketConfig{Mode: "grpc"}); configErr == nil {
+		t.Errorf("Expected unknown mode to be invalid, but it was not")
+	}
+
+	connection, driverErr := newDriver(WebsocketConfig{Mode: ModeHTTP, AccessToken: "token", HTTP: HTTPConfig{API: api.URL, Listen: "127.0.0.1:0", Path: "/event", Secret: "secret"}})
+	if driverErr != nil {
+		t.Fatalf("Expected http driver created, but got %v", driverErr)
+	}
+	driver := connection.(*HTTPDriver)
+
+	t.Run("CallApi", func(t *testing.T) {
+		driver.Connect()
+		if caller, exist := zero.APICallers.Load(40000); !exist || caller != driver {
+			t.Fatalf("Expected bot 40000 registered, but it was not")
+		}
+
+		response, callErr := driver.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": 100, "message": "pong"}})
+		if callErr != nil || response.Data.Get("message_id").Int() != 100 {
+			t.Errorf("Expected api called over http, but got %s, %v", response.Data.Raw, callErr)
+		}
+		if response, _ = driver.CallApi(zero.APIRequest{Action: "unknown"}); response.RetCode != 1404 {
+			t.Errorf("Expected retcode of failed call, but got %d", response.RetCode)
+		}
+	})
+
+	t.Run("Event", func(t *testing.T) {
+		received := [][]byte{}
+		driver.handler = func(payload []byte, _ zero.APICaller) { received = append(received, payload) }
+		post := func(path, body, signature string) int {
+			request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			request.Header.Set("X-Signature", signature)
+			recorder := httptest.NewRecorder()
+			driver.ServeHTTP(recorder, request)
+			return recorder.Code
+		}
+
+		body := `{"post_type":"message","message":"ping"}`
+		mac := hmac.New(sha1.New, []byte("secret"))
+		mac.Write([]byte(body))
+		signature := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+
+		if code := post("/event", body, "sha1=0000"); code != http.StatusForbidden {
+			t.Errorf("Expected forged event rejected, but got %d", code)
+		}
+		if code := post("/other", body, signature); code != http.StatusNotFound {
+			t.Errorf("Expected event on other path rejected, but got %d", code)
+		}
+		if code := post("/event", body, signature); code != http.StatusNoContent || len(received) != 1 || string(received[0]) != body {
+			t.Errorf("Expected signed event handled, but got %d, %d events", code, len(received))
+		}
+
+		heartbeatBody := `{"post_type":"meta_event","meta_event_type":"heartbeat"}`
+		mac.Reset()
+		mac.Write([]byte(heartbeatBody))
+		if code := post("/event", heartbeatBody, "sha1="+hex.EncodeToString(mac.Sum(nil))); code != http.StatusNoContent || len(received) != 2 {
+			t.Errorf("Expected heartbeat handled after recorded, but got %d, %d events", code, len(received))
+		}
+		if health := Health(); len(health.Bots) == 0 || health.Bots[0].LastHeartbeat.IsZero() {
+			t.Errorf("Expected heartbeat recorded, but got %v", health)
+		}
+	})
+}
+
+func TestConsoleDriver(t *testing.T) {
+	reset()
+
+	connection, driverErr := newDriver(WebsocketConfig{Mode: ModeConsole, Console: ConsoleConfig{UserID: 2, Nickname: "alice"}})
+	if driverErr != nil {
+		t.Fatalf("Expected console driver created, but got %v", driverErr)
+	}
+	if connection.(*ConsoleDriver).SelfID != defaultConsoleSelfID || connection.(*ConsoleDriver).Role != "member" {
+		t.Errorf("Expected defaults filled, but got %+v", connection)
+	}
+
+	out := &strings.Builder{}
+	in := strings.NewReader("ping\n:group 100\n:role admin\n[CQ:at,qq=10000] hi\n:unknown\n")
+	driver := NewConsoleDriver(ConsoleConfig{UserID: 2, Nickname: "alice"}, in, out)
+	driver.Connect()
+	if caller, exist := zero.APICallers.Load(defaultConsoleSelfID); !exist || caller != driver {
+		t.Fatalf("Expected console bot registered, but it was not")
+	}
+
+	events := []gjson.Result{}
+	driver.Listen(func(payload []byte, caller zero.APICaller) {
+		event := gjson.ParseBytes(payload)
+		events = append(events, event)
+
+		params := zero.Params{"message_type": event.Get("message_type").Str, "user_id": event.Get("user_id").Int(), "group_id": event.Get("group_id").Int()}
+		params["message"] = message.Message{message.Text("pong "), message.At(2), message.Image("file:///tmp/a.png")}
+		_, _ = caller.CallApi(zero.APIRequest{Action: "send_msg", Params: params})
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		if len(events) != 2 {
+			t.Fatalf("Expected 2 events, but got %d", len(events))
+		}
+		if events[0].Get("message_type").Str != "private" || events[0].Get("user_id").Int() != 2 || events[0].Get("sender.nickname").Str != "alice" {
+			t.Errorf("Expected private message of alice, but got %s", events[0].Raw)
+		}
+		if events[1].Get("group_id").Int() != 100 || events[1].Get("sender.role").Str != "admin" || events[1].Get("message").Str != "[CQ:at,qq=10000] hi" {
+			t.Errorf("Expected group message of admin with cq code, but got %s", events[1].Raw)
+		}
+	})
+
+	t.Run("Output", func(t *testing.T) {
+		printed := out.String()
+		for _, expected := range []string{
+			"bot -> user 2: pong [CQ:at,qq=2][image: file:///tmp/a.png]\n",
+			"bot -> group 100: pong [CQ:at,qq=2][image: file:///tmp/a.png]\n",
+			"error: unknown command unknown",
+		} {
+			if !strings.Contains(printed, expected) {
+				t.Errorf("Expected output containing %q, but got %q", expected, printed)
+			}
+		}
+	})
+
+	t.Run("LoginInfo", func(t *testing.T) {
+		if response, _ := driver.CallApi(zero.APIRequest{Action: "get_login_info"}); response.Data.Get("user_id").Int() != defaultConsoleSelfID {
+			t.Errorf("Expected self id of console, but got %s", response.Data.Raw)
+		}
+	})
+}
+
+// queueCaller record send actions, the first failures calls fail
+type queueCaller struct {
+	mu       sync.Mutex
+	failures int
+	calls    []zero.APIRequest
+	times    []time.Time
+}
+
+func (c *queueCaller) CallApi(request zero.APIRequest) (zero.APIResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		return zero.APIResponse{Status: "failed", RetCode: 100, Msg: "risk controlled"}, nil
+	}
+	c.calls, c.times = append(c.calls, request), append(c.times, time.Now())
+	return zero.APIResponse{Status: "ok", Data: gjson.Parse(fmt.Sprintf(`{"message_id":%d}`, len(c.calls)))}, nil
+}
+
+func TestQueue(t *testing.T) {
+	reset()
+
+	t.Run("Throttle", func(t *testing.T) {
+		caller := &queueCaller{}
+		zero.APICallers.Store(50001, caller)
+		defer zero.APICallers.Delete(50001)
+		queue := newOutboundQueue(func() QueueConfig {
+			return QueueConfig{BotInterval: 20 * time.Millisecond, GroupInterval: 100 * time.Millisecond}
+		})
+
+		results := []*SendResult{}
+		for _, groupID := range []int64{100, 100, 200} {
+			results = append(results, queue.enqueue(OutboundMessage{SelfID: 50001, GroupID: groupID, Message: message.Message{message.Text("hi")}}))
+		}
+		for _, result := range results {
+			if _, sendErr := result.Wait(); sendErr != nil {
+				t.Fatalf("Expected message sent, but got %v", sendErr)
+			}
+		}
+
+		caller.mu.Lock()
+		defer caller.mu.Unlock()
+		if caller.calls[1].Params["group_id"] != int64(200) {
+			t.Errorf("Expected group 200 sent while group 100 throttled, but got %v", caller.calls[1].Params)
+		}
+		if gap := caller.times[2].Sub(caller.times[0]); gap < 100*time.Millisecond {
+			t.Errorf("Expected group interval kept, but got %v", gap)
+		}
+		if gap := caller.times[1].Sub(caller.times[0]); gap < 20*time.Millisecond {
+			t.Errorf("Expected bot interval kept, but got %v", gap)
+		}
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		caller := &queueCaller{}
+		zero.APICallers.Store(50002, caller)
+		defer zero.APICallers.Delete(50002)
+		queue := newOutboundQueue(func() QueueConfig { return QueueConfig{BotInterval: 50 * time.Millisecond, GroupInterval: -1} })
+
+		// the first message starts the bot interval, so the others wait in their lanes
+		_, _ = queue.enqueue(OutboundMessage{SelfID: 50002, UserID: 1, Message: message.Message{message.Text("first")}}).Wait()
+		low := queue.enqueue(OutboundMessage{SelfID: 50002, UserID: 1, Message: message.Message{message.Text("low")}, Priority: PriorityLow})
+		high := queue.enqueue(OutboundMessage{SelfID: 50002, UserID: 1, Message: message.Message{message.Text("high")}, Priority: PriorityHigh})
+
+		if depth := queue.metrics().Depth; depth["high"] != 1 || depth["low"] != 1 {
+			t.Errorf("Expected waiting messages counted in depth, but got %v", depth)
+		}
+		_, _ = low.Wait()
+		if _, sendErr := high.Wait(); sendErr != nil {
+			t.Fatalf("Expected message sent, but got %v", sendErr)
+		}
+
+		caller.mu.Lock()
+		defer caller.mu.Unlock()
+		order := []string{}
+		for _, call := range caller.calls {
+			order = append(order, call.Params["message"].(message.Message)[0].Data["text"])
+		}
+		if strings.Join(order, ",") != "first,high,low" {
+			t.Errorf("Expected high priority sent before low, but got %v", order)
+		}
+		if depth := queue.metrics().Depth; depth["high"]+depth["normal"]+depth["low"] != 0 {
+			t.Errorf("Expected empty queue, but got %v", depth)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		caller := &queueCaller{failures: 2}
+		zero.APICallers.Store(50003, caller)
+		defer zero.APICallers.Delete(50003)
+		queue := newOutboundQueue(func() QueueConfig {
+			return QueueConfig{BotInterval: -1, GroupInterval: -1, MaxRetries: 2, RetryBackoff: 10 * time.Millisecond}
+		})
+
+		if messageID, sendErr := queue.enqueue(OutboundMessage{SelfID: 50003, GroupID: 100, Message: message.Message{message.Text("hi")}}).Wait(); sendErr != nil || messageID != 1 {
+			t.Errorf("Expected message sent after retries, but got %d, %v", messageID, sendErr)
+		}
+		if metrics := queue.metrics(); metrics.Retried != 2 || metrics.Sent != 1 {
+			t.Errorf("Expected 2 retries and 1 sent, but got %+v", metrics)
+		}
+
+		caller.failures = 5
+		if _, sendErr := queue.enqueue(OutboundMessage{SelfID: 50003, GroupID: 100, Message: message.Message{message.Text("hi")}}).Wait(); sendErr == nil {
+			t.Errorf("Expected message failed after max retries, but it was sent")
+		}
+		if metrics := queue.metrics(); metrics.Failed != 1 {
+			t.Errorf("Expected 1 failed message, but got %+v", metrics)
+		}
+	})
+
+	t.Run("Full", func(t *testing.T) {
+		caller := &queueCaller{}
+		zero.APICallers.Store(50004, caller)
+		defer zero.APICallers.Delete(50004)
+		queue := newOutboundQueue(func() QueueConfig { return QueueConfig{Size: 1, BotInterval: time.Hour} })
+
+		// the second message waits for the bot interval and fills the queue
+		_, _ = queue.enqueue(OutboundMessage{SelfID: 50004, UserID: 1, Message: message.Message{message.Text("hi")}}).Wait()
+		_ = queue.enqueue(OutboundMessage{SelfID: 50004, UserID: 1, Message: message.Message{message.Text("hi")}})
+		if _, sendErr := queue.enqueue(OutboundMessage{SelfID: 50004, UserID: 1, Message: message.Message{message.Text("hi")}}).Wait(); !errors.Is(sendErr, ErrQueueFull) {
+			t.Errorf("Expected message dropped by full queue, but got %v", sendErr)
+		}
+		if metrics := queue.metrics(); metrics.Dropped != 1 {
+			t.Errorf("Expected 1 dropped message, but got %+v", metrics)
+		}
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		caller := &queueCaller{}
+		zero.APICallers.Store(50006, caller)
+		defer zero.APICallers.Delete(50006)
+		coreConfig.Queue = QueueConfig{BotInterval: -1, GroupInterval: -1}
+
+		ctx := &zero.Ctx{Event: &zero.Event{SelfID: 50006, UserID: 2}}
+		if _, sendErr := Send(ctx, message.Text("pong")).Wait(); sendErr != nil {
+			t.Fatalf("Expected message sent, but got %v", sendErr)
+		}
+		if caller.calls[0].Action != "send_private_msg" || caller.calls[0].Params["user_id"] != int64(2) {
+			t.Errorf("Expected private message to user 2, but got %+v", caller.calls[0])
+		}
+		if Metrics().Sent != 1 {
+			t.Errorf("Expected sent message counted, but got %+v", Metrics())
+		}
+	})
 }
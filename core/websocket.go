@@ -0,0 +1,315 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RomiChan/websocket"
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+
+	apiCallTimeout = time.Minute
+)
+
+// errGaveUp is returned by api calls after the client gave up reconnecting
+var errGaveUp = errors.New("gave up reconnecting to onebot server")
+
+// WebsocketClient is a forward websocket driver of zero, it reconnects with exponential backoff,
+// reports connection changes to lifecycle hooks and records heartbeats for health status
+type WebsocketClient struct {
+	URL         string
+	AccessToken string
+	Reconnect   ReconnectConfig
+	Header      http.Header
+	TLSConfig   *tls.Config
+
+	mu        sync.Mutex
+	writeMu   sync.Mutex
+	conn      *websocket.Conn
+	selfID    int64
+	gaveUp    bool
+	lifecycle []byte
+	seq       atomic.Uint64
+	pending   sync.Map
+}
+
+// NewWebsocketClient create a websocket driver connecting to endpoint
+func NewWebsocketClient(endpoint, accessToken string, reconnect ReconnectConfig) *WebsocketClient {
+	return &WebsocketClient{URL: endpoint, AccessToken: accessToken, Reconnect: reconnect}
+}
+
+// NewWebsocketClientWithConfig create a websocket driver from config, including tls options and handshake headers
+func NewWebsocketClientWithConfig(config WebsocketConfig) (*WebsocketClient, error) {
+	endpoint, endpointErr := config.Endpoint()
+	if endpointErr != nil {
+		return nil, endpointErr
+	}
+	tlsConfig, tlsErr := config.TLS.build()
+	if tlsErr != nil {
+		return nil, tlsErr
+	}
+
+	client := NewWebsocketClient(endpoint, config.AccessToken, config.Reconnect)
+	client.TLSConfig, client.Header = tlsConfig, http.Header{}
+	for key, value := range config.Headers {
+		client.Header.Set(key, value)
+	}
+
+	return client, nil
+}
+
+// Endpoint get the websocket url of onebot server, the scheme is ws by default
+func (w WebsocketConfig) Endpoint() (string, error) {
+	if w.URL != "" {
+		parsed, parseErr := url.Parse(w.URL)
+		if parseErr != nil {
+			return "", fmt.Errorf("invalid websocket url: %w", parseErr)
+		}
+		if parsed.Scheme != "ws" && parsed.Scheme != "wss" {
+			return "", fmt.Errorf("invalid websocket url scheme: %s", parsed.Scheme)
+		}
+
+		return w.URL, nil
+	}
+
+	scheme := shortcut.Ternary(w.Scheme == "", "ws", w.Scheme)
+	if scheme != "ws" && scheme != "wss" {
+		return "", fmt.Errorf("invalid websocket scheme: %s", scheme)
+	}
+	endpoint := url.URL{Scheme: scheme, Host: net.JoinHostPort(w.Host, strconv.Itoa(w.Port)), Path: w.Path}
+	if w.Path != "" && !strings.HasPrefix(w.Path, "/") {
+		endpoint.Path = "/" + w.Path
+	}
+
+	return endpoint.String(), nil
+}
+
+// build get tls config of the options, nil means default tls config
+func (t TLSConfig) build() (*tls.Config, error) {
+	if t.CA == "" && t.Cert == "" && t.Key == "" && t.ServerName == "" && !t.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify, MinVersion: tls.VersionTLS12}
+	if t.CA != "" {
+		content, readErr := os.ReadFile(t.CA)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read ca: %w", readErr)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found in ca %s", t.CA)
+		}
+	}
+	if t.Cert != "" || t.Key != "" {
+		certificate, loadErr := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if loadErr != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", loadErr)
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// Connect dial the server until connected or max attempts reached, the bot is registered as api caller once connected
+func (c *WebsocketClient) Connect() {
+	c.connect(false)
+}
+
+// Listen read events from server and reconnect on failure, heartbeats are recorded before handled,
+// and the lifecycle event of handshake is handled after each connection, it returns after the client
+// gave up reconnecting
+func (c *WebsocketClient) Listen(handler func([]byte, zero.APICaller)) {
+	for {
+		c.mu.Lock()
+		conn, selfID, gaveUp, lifecycle := c.conn, c.selfID, c.gaveUp, c.lifecycle
+		c.lifecycle = nil
+		c.mu.Unlock()
+		if gaveUp || conn == nil {
+			return
+		}
+		if lifecycle != nil {
+			handler(lifecycle, c)
+		}
+
+		messageType, payload, readErr := conn.ReadMessage()
+		if readErr != nil {
+			_ = conn.Close()
+			zero.APICallers.Delete(selfID)
+			c.closePending()
+			changeLifecycle(LifecycleEvent{Type: LifecycleDisconnect, URL: c.URL, SelfID: selfID, Error: readErr.Error(), Time: time.Now()})
+			c.connect(true)
+			continue
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		result := gjson.ParseBytes(payload)
+		if result.Get("echo").Exists() {
+			// response of api call
+			if pending, exist := c.pending.LoadAndDelete(result.Get("echo").Uint()); exist {
+				pending.(chan zero.APIResponse) <- zero.APIResponse{
+					Status:  result.Get("status").String(),
+					Data:    result.Get("data"),
+					Msg:     result.Get("msg").Str,
+					Wording: result.Get("wording").Str,
+					RetCode: result.Get("retcode").Int(),
+					Echo:    result.Get("echo").Uint(),
+				}
+			}
+			continue
+		}
+		if result.Get("meta_event_type").Str == "heartbeat" {
+			heartbeat(c.URL, time.Now())
+		}
+
+		handler(payload, c)
+	}
+}
+
+// CallApi send the request and wait for the response
+func (c *WebsocketClient) CallApi(request zero.APIRequest) (zero.APIResponse, error) {
+	c.mu.Lock()
+	conn, gaveUp := c.conn, c.gaveUp
+	c.mu.Unlock()
+	if gaveUp || conn == nil {
+		return zero.APIResponse{}, errGaveUp
+	}
+
+	response := make(chan zero.APIResponse, 1)
+	request.Echo = c.seq.Add(1)
+	c.pending.Store(request.Echo, response)
+
+	c.writeMu.Lock()
+	writeErr := conn.WriteJSON(&request)
+	c.writeMu.Unlock()
+	if writeErr != nil {
+		c.pending.Delete(request.Echo)
+		return zero.APIResponse{}, writeErr
+	}
+
+	select {
+	case result, ok := <-response:
+		if !ok {
+			return zero.APIResponse{}, io.ErrClosedPipe
+		}
+		return result, nil
+	case <-time.After(apiCallTimeout):
+		c.pending.Delete(request.Echo)
+		return zero.APIResponse{}, os.ErrDeadlineExceeded
+	}
+}
+
+// connect dial until connected or max attempts reached, reconnecting waits for backoff before the first attempt
+func (c *WebsocketClient) connect(reconnecting bool) {
+	header := c.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("User-Agent") == "" {
+		header.Set("User-Agent", "CeobeBot")
+	}
+	header.Set("X-Client-Role", "Universal")
+	if c.AccessToken != "" {
+		header.Set("Authorization", "Bearer "+c.AccessToken)
+	}
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 45 * time.Second, TLSClientConfig: c.TLSConfig}
+
+	for failed := 0; ; failed++ {
+		if reconnecting || failed > 0 {
+			if c.Reconnect.MaxAttempts > 0 && failed >= c.Reconnect.MaxAttempts {
+				c.mu.Lock()
+				c.conn, c.gaveUp = nil, true
+				c.mu.Unlock()
+				changeLifecycle(LifecycleEvent{Type: LifecycleGiveUp, URL: c.URL, Attempt: failed, Time: time.Now()})
+				return
+			}
+			time.Sleep(c.Reconnect.backoff(failed+1, rand.Float64()))
+		}
+
+		conn, response, dialErr := dialer.Dial(c.URL, header)
+		if dialErr != nil {
+			c.recordFailure(dialErr)
+			continue
+		}
+		_ = response.Body.Close()
+
+		// the first frame is the lifecycle event of onebot containing self id, it is handled by listen later
+		_, handshake, readErr := conn.ReadMessage()
+		if readErr == nil && !gjson.ValidBytes(handshake) {
+			readErr = errors.New("invalid handshake frame")
+		}
+		if readErr != nil {
+			_ = conn.Close()
+			c.recordFailure(readErr)
+			continue
+		}
+		selfID := gjson.GetBytes(handshake, "self_id").Int()
+
+		c.mu.Lock()
+		c.conn, c.selfID, c.lifecycle = conn, selfID, nil
+		if gjson.GetBytes(handshake, "post_type").Str == "meta_event" {
+			c.lifecycle = handshake
+		}
+		c.mu.Unlock()
+		zero.APICallers.Store(selfID, c)
+		changeLifecycle(LifecycleEvent{Type: LifecycleConnect, URL: c.URL, SelfID: selfID, Attempt: failed, Time: time.Now()})
+		return
+	}
+}
+
+// closePending fail api calls waiting for responses of the broken connection
+func (c *WebsocketClient) closePending() {
+	c.pending.Range(func(key, value any) bool {
+		c.pending.Delete(key)
+		close(value.(chan zero.APIResponse))
+		return true
+	})
+}
+
+func (c *WebsocketClient) recordFailure(err error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	healthOf(c.URL).LastError = err.Error()
+}
+
+// backoff get the delay before the attempt, it doubles from initial backoff up to max backoff,
+// and is randomized by jitter, jitter 0.2 means ±20%, random is in [0, 1)
+func (r ReconnectConfig) backoff(attempt int, random float64) time.Duration {
+	initial, maximum, jitter := r.InitialBackoff, r.MaxBackoff, r.Jitter
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	if maximum <= 0 {
+		maximum = defaultMaxBackoff
+	}
+	jitter = math.Max(math.Min(jitter, 1), 0)
+
+	delay := math.Min(float64(initial)*math.Pow(2, float64(max(attempt-1, 0))), float64(maximum))
+	return time.Duration(delay * (1 + jitter*(2*random-1)))
+}
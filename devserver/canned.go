@@ -0,0 +1,106 @@
+package devserver
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// registerCanned register responders of common api calls, the data is built from senders seen in injected messages
+func (s *Server) registerCanned() {
+	sent := func(gjson.Result) any { return map[string]any{"message_id": s.messageID.Add(1)} }
+	for _, action := range []string{"send_msg", "send_group_msg", "send_private_msg", "send_group_forward_msg", "send_private_forward_msg"} {
+		s.responders[action] = sent
+	}
+
+	s.responders["get_login_info"] = func(gjson.Result) any {
+		return map[string]any{"user_id": s.SelfID, "nickname": s.Nickname}
+	}
+	s.responders["get_status"] = func(gjson.Result) any {
+		return map[string]any{"online": true, "good": true}
+	}
+	s.responders["get_version_info"] = func(gjson.Result) any {
+		return map[string]any{"app_name": "ceobebot-devserver", "app_version": "dev", "protocol_version": "v11"}
+	}
+	s.responders["can_send_image"] = func(gjson.Result) any { return map[string]any{"yes": true} }
+	s.responders["can_send_record"] = func(gjson.Result) any { return map[string]any{"yes": true} }
+
+	s.responders["get_stranger_info"] = func(params gjson.Result) any {
+		userID := params.Get("user_id").Int()
+		return map[string]any{"user_id": userID, "nickname": s.member(0, userID).Nickname, "sex": "unknown", "age": 0}
+	}
+	s.responders["get_friend_list"] = func(gjson.Result) any {
+		return []any{}
+	}
+	s.responders["get_group_info"] = func(params gjson.Result) any {
+		return s.groupInfo(params.Get("group_id").Int())
+	}
+	s.responders["get_group_list"] = func(gjson.Result) any {
+		groups := s.groups()
+		sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+
+		infos := make([]any, 0, len(groups))
+		for _, groupID := range groups {
+			infos = append(infos, s.groupInfo(groupID))
+		}
+
+		return infos
+	}
+	s.responders["get_group_member_info"] = func(params gjson.Result) any {
+		return s.memberInfo(params.Get("group_id").Int(), params.Get("user_id").Int())
+	}
+	s.responders["get_group_member_list"] = func(params gjson.Result) any {
+		groupID := params.Get("group_id").Int()
+
+		s.mu.Lock()
+		userIDs := []int64{}
+		for key := range s.members {
+			if key[0] == groupID {
+				userIDs = append(userIDs, key[1])
+			}
+		}
+		s.mu.Unlock()
+		sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+		infos := make([]any, 0, len(userIDs)+1)
+		infos = append(infos, s.memberInfo(groupID, s.SelfID))
+		for _, userID := range userIDs {
+			infos = append(infos, s.memberInfo(groupID, userID))
+		}
+
+		return infos
+	}
+}
+
+func (s *Server) groupInfo(groupID int64) map[string]any {
+	s.mu.Lock()
+	count := 1
+	for key := range s.members {
+		if key[0] == groupID {
+			count++
+		}
+	}
+	s.mu.Unlock()
+
+	return map[string]any{"group_id": groupID, "group_name": "group " + strconv.FormatInt(groupID, 10), "member_count": count, "max_member_count": 500}
+}
+
+func (s *Server) memberInfo(groupID, userID int64) map[string]any {
+	known := s.member(groupID, userID)
+	if userID == s.SelfID {
+		known = member{Nickname: s.Nickname, Role: "member"}
+	}
+
+	return map[string]any{
+		"group_id":  groupID,
+		"user_id":   userID,
+		"nickname":  known.Nickname,
+		"card":      known.Nickname,
+		"role":      known.Role,
+		"join_time": time.Now().Unix(),
+		"sex":       "unknown",
+		"level":     "1",
+	}
+}
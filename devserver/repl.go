@@ -0,0 +1,188 @@
+package devserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// replCommandPrefix is the prefix of repl commands, slash is left for triggers of bot
+const replCommandPrefix = ":"
+
+const replHelp = `:group <id>            send messages in group
+:private               send messages in private chat
+:user <id> [nickname]  send messages as user
+:role <role>           set role of user in group, such as owner, admin or member
+:help                  show this help
+:quit                  exit
+other lines are sent to bot as message, CQ code is supported
+`
+
+// REPL read lines from terminal and send them to bot as the current user, replies of bot are printed,
+// lines starting with colon are commands switching the user and chat
+type REPL struct {
+	Server   *Server
+	GroupID  int64
+	UserID   int64
+	Nickname string
+	Role     string
+
+	in  io.Reader
+	out io.Writer
+	mu  sync.Mutex
+}
+
+// NewREPL create a repl on the server, it prints every message sent by bot to out
+func NewREPL(server *Server, in io.Reader, out io.Writer) *REPL {
+	repl := &REPL{Server: server, UserID: 1, Nickname: "user", Role: "member", in: in, out: out}
+	server.OnCall = repl.printCall
+
+	return repl
+}
+
+// Run read lines until input ends or quit command
+func (r *REPL) Run() error {
+	r.print("type :help for commands\n")
+	r.prompt()
+
+	scanner := bufio.NewScanner(r.in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case line == replCommandPrefix+"quit":
+			return nil
+		case strings.HasPrefix(line, replCommandPrefix):
+			r.mu.Lock()
+			commandErr := r.command(strings.Fields(strings.TrimPrefix(line, replCommandPrefix)))
+			r.mu.Unlock()
+			if commandErr != nil {
+				r.print("error: " + commandErr.Error() + "\n")
+			}
+		default:
+			r.mu.Lock()
+			groupID, userID, nickname, role := r.GroupID, r.UserID, r.Nickname, r.Role
+			r.mu.Unlock()
+			if sendErr := r.Server.Send(groupID, userID, nickname, role, line); sendErr != nil {
+				r.print("error: " + sendErr.Error() + "\n")
+			}
+		}
+		r.prompt()
+	}
+
+	return scanner.Err()
+}
+
+// command run the repl command, the caller holds the lock
+func (r *REPL) command(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	switch fields[0] {
+	case "help":
+		_, _ = io.WriteString(r.out, replHelp)
+	case "private":
+		r.GroupID = 0
+	case "group":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: :group <id>")
+		}
+		groupID, parseErr := strconv.ParseInt(fields[1], 10, 64)
+		if parseErr != nil || groupID <= 0 {
+			return fmt.Errorf("invalid group id: %s", fields[1])
+		}
+		r.GroupID = groupID
+	case "user":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: :user <id> [nickname]")
+		}
+		userID, parseErr := strconv.ParseInt(fields[1], 10, 64)
+		if parseErr != nil || userID <= 0 {
+			return fmt.Errorf("invalid user id: %s", fields[1])
+		}
+		r.UserID, r.Nickname = userID, "user"
+		if len(fields) > 2 {
+			r.Nickname = strings.Join(fields[2:], " ")
+		}
+	case "role":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: :role <role>")
+		}
+		r.Role = fields[1]
+	default:
+		return fmt.Errorf("unknown command %s, type :help for commands", fields[0])
+	}
+
+	return nil
+}
+
+// printCall print messages sent by bot, other api calls are printed with params, except polling calls
+func (r *REPL) printCall(call Call) {
+	target := ""
+	switch {
+	case call.Params.Get("group_id").Int() != 0:
+		target = "group " + call.Params.Get("group_id").String()
+	case call.Params.Get("user_id").Int() != 0:
+		target = "user " + call.Params.Get("user_id").String()
+	}
+
+	switch call.Action {
+	case "send_msg", "send_group_msg", "send_private_msg":
+		r.print(fmt.Sprintf("\nbot -> %s: %s\n", target, render(messageOf(call.Params.Get("message")))))
+	case "get_login_info", "get_status", "get_version_info":
+		// called by framework periodically, not interesting
+		return
+	default:
+		r.print(fmt.Sprintf("\nbot called %s %s\n", call.Action, call.Params.Raw))
+	}
+	r.prompt()
+}
+
+func (r *REPL) prompt() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat := "private"
+	if r.GroupID != 0 {
+		chat = "group " + strconv.FormatInt(r.GroupID, 10)
+	}
+
+	_, _ = fmt.Fprintf(r.out, "[%s | %s(%d) %s] > ", chat, r.Nickname, r.UserID, r.Role)
+}
+
+func (r *REPL) print(text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, _ = io.WriteString(r.out, text)
+}
+
+// messageOf parse message param, which is a cq string, an array of segments, or a single segment
+func messageOf(param gjson.Result) message.Message {
+	if param.IsObject() {
+		return message.ParseMessageFromArray(gjson.Parse("[" + param.Raw + "]"))
+	}
+
+	return message.ParseMessage([]byte(param.Raw))
+}
+
+// render get readable text of message, text is kept as is and other segments are shown as CQ code
+func render(msg message.Message) string {
+	sb := strings.Builder{}
+	for _, segment := range msg {
+		if segment.Type == "text" {
+			sb.WriteString(segment.Data["text"])
+		} else {
+			sb.WriteString(segment.String())
+		}
+	}
+
+	return sb.String()
+}
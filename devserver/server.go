@@ -0,0 +1,315 @@
+package devserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RomiChan/websocket"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DefaultSelfID is the account of bot served by fake server
+	DefaultSelfID int64 = 10000
+
+	// DefaultNickname is the nickname of bot served by fake server
+	DefaultNickname = "ceobe"
+)
+
+// Responder build the data of api response by request params, return nil for null data
+type Responder func(params gjson.Result) any
+
+// Call is an api call received from bot
+type Call struct {
+	Action string       `json:"action"`
+	Params gjson.Result `json:"params"`
+}
+
+// Server is a fake OneBot v11 websocket server, bots connect to it by forward websocket like go-cqhttp,
+// events are pushed by Send or Inject, api calls are answered by canned responders which can be replaced by Respond
+type Server struct {
+	SelfID      int64
+	Nickname    string
+	AccessToken string
+
+	// OnCall is called before every api call is answered, it is used to print replies of bot,
+	// it must be set before bots connect
+	OnCall func(Call)
+
+	mu         sync.Mutex
+	conns      map[*websocket.Conn]*sync.Mutex
+	responders map[string]Responder
+	members    map[[2]int64]member
+	messageID  atomic.Int64
+	connected  chan struct{}
+	listener   net.Listener
+	upgrader   websocket.Upgrader
+}
+
+// member is the sender seen in injected messages, group 0 is the user in private chat
+type member struct {
+	Nickname string
+	Role     string
+}
+
+// NewServer create a fake server of the bot account with canned responders, empty access token disables authentication
+func NewServer(selfID int64, accessToken string) *Server {
+	server := &Server{
+		SelfID:      selfID,
+		Nickname:    DefaultNickname,
+		AccessToken: accessToken,
+		conns:       map[*websocket.Conn]*sync.Mutex{},
+		responders:  map[string]Responder{},
+		members:     map[[2]int64]member{},
+		connected:   make(chan struct{}),
+		upgrader:    websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+	}
+	server.registerCanned()
+
+	return server
+}
+
+// ListenAndServe listen on the address and serve bots until Close is called
+func (s *Server) ListenAndServe(addr string) error {
+	listener, listenErr := net.Listen("tcp", addr)
+	if listenErr != nil {
+		return listenErr
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	serveErr := http.Serve(listener, s)
+	if errors.Is(serveErr, net.ErrClosed) {
+		return nil
+	}
+
+	return serveErr
+}
+
+// Close stop listening and close connections of bots
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+
+	return nil
+}
+
+// Connected return a channel closed when the first bot connects
+func (s *Server) Connected() <-chan struct{} {
+	return s.connected
+}
+
+// ServeHTTP upgrade the request to websocket, then send the lifecycle event and answer api calls of bot
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, upgradeErr := s.upgrader.Upgrade(w, r, nil)
+	if upgradeErr != nil {
+		return
+	}
+	defer s.disconnect(conn)
+
+	// clients read self id from the first frame, which is the lifecycle event of onebot
+	writeMu := &sync.Mutex{}
+	if writeErr := conn.WriteJSON(s.event("meta_event", map[string]any{"meta_event_type": "lifecycle", "sub_type": "connect"})); writeErr != nil {
+		return
+	}
+	s.connect(conn, writeMu)
+
+	for {
+		_, payload, readErr := conn.ReadMessage()
+		if readErr != nil {
+			return
+		}
+
+		request := gjson.ParseBytes(payload)
+		call := Call{Action: request.Get("action").String(), Params: request.Get("params")}
+		response := map[string]any{"status": "ok", "retcode": 0, "data": s.answer(call), "echo": request.Get("echo").Value()}
+		if s.OnCall != nil {
+			s.OnCall(call)
+		}
+
+		writeMu.Lock()
+		writeErr := conn.WriteJSON(response)
+		writeMu.Unlock()
+		if writeErr != nil {
+			return
+		}
+	}
+}
+
+// Respond set the responder of action, it replaces the canned one
+func (s *Server) Respond(action string, responder Responder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responders[action] = responder
+}
+
+// Send push a message event sent by user, group id 0 means private message, the sender is remembered,
+// so member info api calls get the nickname and role
+func (s *Server) Send(groupID, userID int64, nickname, role, text string) error {
+	if nickname == "" {
+		nickname = "user"
+	}
+	if role == "" {
+		role = "member"
+	}
+
+	s.mu.Lock()
+	s.members[[2]int64{groupID, userID}] = member{Nickname: nickname, Role: role}
+	s.mu.Unlock()
+
+	messageType, subType := "group", "normal"
+	if groupID == 0 {
+		messageType, subType = "private", "friend"
+	}
+
+	return s.Inject(s.event("message", map[string]any{
+		"message_type": messageType,
+		"sub_type":     subType,
+		"message_id":   s.messageID.Add(1),
+		"group_id":     groupID,
+		"user_id":      userID,
+		"message":      text,
+		"raw_message":  text,
+		"font":         0,
+		"sender":       map[string]any{"user_id": userID, "nickname": nickname, "role": role},
+	}))
+}
+
+// Inject push a raw event to all connected bots, such as notice or request
+func (s *Server) Inject(event map[string]any) error {
+	if _, exist := event["self_id"]; !exist {
+		event["self_id"] = s.SelfID
+	}
+	if _, exist := event["time"]; !exist {
+		event["time"] = time.Now().Unix()
+	}
+	encoded, encodeErr := json.Marshal(event)
+	if encodeErr != nil {
+		return encodeErr
+	}
+
+	// copy connections, so a slow connection does not block connecting and answering while writing
+	s.mu.Lock()
+	conns := make(map[*websocket.Conn]*sync.Mutex, len(s.conns))
+	for conn, writeMu := range s.conns {
+		conns[conn] = writeMu
+	}
+	s.mu.Unlock()
+
+	if len(conns) == 0 {
+		return errors.New("no bot connected")
+	}
+	for conn, writeMu := range conns {
+		writeMu.Lock()
+		_ = conn.WriteMessage(websocket.TextMessage, encoded)
+		writeMu.Unlock()
+	}
+
+	return nil
+}
+
+func (s *Server) event(postType string, fields map[string]any) map[string]any {
+	fields["post_type"] = postType
+	fields["self_id"] = s.SelfID
+	fields["time"] = time.Now().Unix()
+
+	return fields
+}
+
+func (s *Server) answer(call Call) any {
+	s.mu.Lock()
+	responder, exist := s.responders[call.Action]
+	s.mu.Unlock()
+
+	if !exist {
+		return nil
+	}
+
+	return responder(call.Params)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.AccessToken == "" {
+		return true
+	}
+
+	given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if given == "" {
+		given = r.URL.Query().Get("access_token")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.AccessToken)) == 1
+}
+
+func (s *Server) connect(conn *websocket.Conn, writeMu *sync.Mutex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conns[conn] = writeMu
+	select {
+	case <-s.connected:
+	default:
+		close(s.connected)
+	}
+}
+
+func (s *Server) disconnect(conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+	_ = conn.Close()
+}
+
+// member get the remembered sender, unknown users get default nickname
+func (s *Server) member(groupID, userID int64) member {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if known, exist := s.members[[2]int64{groupID, userID}]; exist {
+		return known
+	}
+	if known, exist := s.members[[2]int64{0, userID}]; exist {
+		return member{Nickname: known.Nickname, Role: "member"}
+	}
+
+	return member{Nickname: "user", Role: "member"}
+}
+
+// groups get the groups seen in injected messages
+func (s *Server) groups() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen, groups := map[int64]bool{}, []int64{}
+	for key := range s.members {
+		if key[0] != 0 && !seen[key[0]] {
+			seen[key[0]] = true
+			groups = append(groups, key[0])
+		}
+	}
+
+	return groups
+}
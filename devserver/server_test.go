@@ -0,0 +1,129 @@
+package devserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RomiChan/websocket"
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/driver"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// syncBuffer is the repl output written by server goroutines
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buffer.String()
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer(DefaultSelfID, "secret")
+	out := &syncBuffer{}
+	repl := NewREPL(server, strings.NewReader(":group 200\n:user 3 bob\n:unknown\nhello\n"), out)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	events := make(chan []byte, 8)
+	client := driver.NewWebSocketClient("ws"+strings.TrimPrefix(httpServer.URL, "http"), "secret")
+	client.Connect()
+	go client.Listen(func(payload []byte, _ zero.APICaller) { events <- payload })
+
+	select {
+	case <-server.Connected():
+	case <-time.After(time.Second):
+		t.Fatal("Expected bot connected, but timeout")
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		header := http.Header{"Authorization": []string{"Bearer wrong"}}
+		_, response, dialErr := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), header)
+		if dialErr == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected unauthorized, but got %v", dialErr)
+		}
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		if sendErr := server.Send(100, 2, "alice", "admin", "ping"); sendErr != nil {
+			t.Fatalf("Expected message sent, but got %v", sendErr)
+		}
+
+		select {
+		case payload := <-events:
+			event := gjson.ParseBytes(payload)
+			if event.Get("group_id").Int() != 100 || event.Get("raw_message").String() != "ping" || event.Get("sender.nickname").String() != "alice" {
+				t.Errorf("Expected group message event, but got %s", payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected event received, but timeout")
+		}
+	})
+
+	t.Run("Canned", func(t *testing.T) {
+		response, callErr := client.CallApi(zero.APIRequest{Action: "get_group_member_info", Params: zero.Params{"group_id": 100, "user_id": 2}})
+		if callErr != nil || response.Data.Get("nickname").String() != "alice" || response.Data.Get("role").String() != "admin" {
+			t.Errorf("Expected member seen in message, but got %s, %v", response.Data.Raw, callErr)
+		}
+
+		response, callErr = client.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": 100, "message": "pong"}})
+		if callErr != nil || response.Data.Get("message_id").Int() == 0 {
+			t.Errorf("Expected message id, but got %s, %v", response.Data.Raw, callErr)
+		}
+
+		response, _ = client.CallApi(zero.APIRequest{Action: "unknown_action"})
+		if response.Status != "ok" || response.Data.Exists() && response.Data.Type != gjson.Null {
+			t.Errorf("Expected null data of unknown action, but got %s", response.Data.Raw)
+		}
+	})
+
+	t.Run("REPL", func(t *testing.T) {
+		if runErr := repl.Run(); runErr != nil {
+			t.Fatalf("Expected repl finished, but got %v", runErr)
+		}
+		if repl.GroupID != 200 || repl.UserID != 3 || repl.Nickname != "bob" {
+			t.Errorf("Expected repl switched to group 200 user 3, but got %d %d %s", repl.GroupID, repl.UserID, repl.Nickname)
+		}
+		if !strings.Contains(out.String(), "unknown command") {
+			t.Errorf("Expected unknown command error, but got %s", out.String())
+		}
+
+		select {
+		case payload := <-events:
+			if event := gjson.ParseBytes(payload); event.Get("group_id").Int() != 200 || event.Get("user_id").Int() != 3 {
+				t.Errorf("Expected message from repl user, but got %s", payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected event received, but timeout")
+		}
+
+		_, _ = client.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": 200, "message": "hi bob"}})
+		if !strings.Contains(out.String(), "bot -> group 200: hi bob") {
+			t.Errorf("Expected reply printed, but got %s", out.String())
+		}
+
+		// ctx.Send sends a single segment as object
+		_, _ = client.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": 200, "message": message.Text("bye bob")}})
+		if !strings.Contains(out.String(), "bot -> group 200: bye bob") {
+			t.Errorf("Expected single segment printed, but got %s", out.String())
+		}
+	})
+}
@@ -0,0 +1,375 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/logger"
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// Adapter connect the bot to a chat platform, events of the platform are normalized to Event,
+// and messages sent by handlers are normalized to Outgoing, so the same bindings run on every platform
+type Adapter interface {
+	// Start connect to the platform and deliver events until ctx is done, it returns the account of bot once connected
+	Start(ctx context.Context, deliver func(Event)) (selfID string, err error)
+	// Send send the message to target, it returns the id of message sent
+	Send(ctx context.Context, target Target, outgoing Outgoing) (messageID string, err error)
+}
+
+// Event is a message received from platform, channel is the group, chat or guild channel, private messages
+// have no channel on some platforms, role is owner, admin or member
+type Event struct {
+	ChannelID string
+	UserID    string
+	Nickname  string
+	Role      string
+	Text      string
+	Private   bool
+	ToMe      bool
+}
+
+// Target is where to send a message, channel is empty if the platform sends private messages by user
+type Target struct {
+	ChannelID string
+	UserID    string
+	Private   bool
+}
+
+// Outgoing is a message sent by bot, at segments are rendered as mentions in text, images are urls or files
+type Outgoing struct {
+	Text   string
+	Images []string
+}
+
+// Mentioner is implemented by adapters able to mention users in text, at segments are rendered as @ and
+// the platform id of user for other adapters
+type Mentioner interface {
+	// Mention get the text mentioning the user
+	Mention(userID string) string
+}
+
+// hashedIDBase is the start of onebot ids hashed from platform ids, ids of onebot accounts are far below it
+const hashedIDBase = 1 << 62
+
+var (
+	adaptersMu sync.Mutex
+	adapters   = map[string]func(core.AdapterConfig) (Adapter, error){}
+
+	adapterIDsOnce  sync.Once
+	adapterIDsStore core.Storage
+)
+
+// RegisterAdapter register adapter factory of platform, it can be used by platform field of adapter config
+func RegisterAdapter(platform string, factory func(core.AdapterConfig) (Adapter, error)) {
+	adaptersMu.Lock()
+	defer adaptersMu.Unlock()
+
+	if _, exist := adapters[platform]; exist {
+		// cannot rewrite adapter, it will replace built-in adapters
+		panic("adapter already exist")
+	}
+
+	adapters[platform] = factory
+}
+
+// NewAdapterDriver create a zero driver running the adapter, platform ids are mapped to numeric ids of onebot,
+// the mapping is persisted, so messages can be sent to ids seen before restarting
+func NewAdapterDriver(platform string, adapter Adapter) *AdapterDriver {
+	return &AdapterDriver{
+		Platform:  platform,
+		adapter:   adapter,
+		ids:       map[int64]string{},
+		privates:  map[int64]string{},
+		listening: make(chan struct{}),
+	}
+}
+
+// AdapterDriver bridge an adapter to zero, events are converted to onebot message events, and send actions
+// are converted back to the platform, other actions are not supported by platforms and fail
+type AdapterDriver struct {
+	Platform string
+
+	adapter    Adapter
+	selfID     int64
+	mu         sync.Mutex
+	ids        map[int64]string
+	privates   map[int64]string
+	handler    func([]byte, zero.APICaller)
+	listening  chan struct{}
+	listenOnce sync.Once
+	messageSeq int64
+}
+
+// Connect start the adapter and register the bot as api caller
+func (d *AdapterDriver) Connect() {
+	selfID, startErr := d.adapter.Start(context.Background(), d.deliver)
+	if startErr != nil {
+		if log := core.Logger(); log != nil {
+			log.Error(logger.NewFields().WithMessage("failed to start adapter").WithData(map[string]any{"platform": d.Platform, "error": startErr.Error()}))
+		}
+		return
+	}
+
+	d.mu.Lock()
+	d.selfID = d.idOf(selfID)
+	d.mu.Unlock()
+	zero.APICallers.Store(d.selfID, d)
+	if log := core.Logger(); log != nil {
+		log.Infof(logger.NewFields(), "adapter %s connected, account: %s", d.Platform, selfID)
+	}
+}
+
+// Listen keep the event handler of zero, events are delivered by adapter
+func (d *AdapterDriver) Listen(handler func([]byte, zero.APICaller)) {
+	d.mu.Lock()
+	d.handler = handler
+	d.mu.Unlock()
+
+	d.listenOnce.Do(func() { close(d.listening) })
+}
+
+// CallApi send messages by adapter, the bot info is answered locally
+func (d *AdapterDriver) CallApi(request zero.APIRequest) (zero.APIResponse, error) {
+	switch request.Action {
+	case "send_msg", "send_group_msg", "send_private_msg":
+		target, resolveErr := d.targetOf(request.Params)
+		if resolveErr != nil {
+			return failedResponse(resolveErr), nil
+		}
+
+		messageID, sendErr := d.adapter.Send(context.Background(), target, outgoingOf(request.Params["message"], d.mention))
+		if sendErr != nil {
+			return failedResponse(sendErr), nil
+		}
+
+		return okResponse(map[string]any{"message_id": numericID(d.Platform, messageID)}), nil
+	case "get_login_info":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		return okResponse(map[string]any{"user_id": d.selfID, "nickname": d.Platform}), nil
+	default:
+		return failedResponse(fmt.Errorf("action %s is not supported by %s", request.Action, d.Platform)), nil
+	}
+}
+
+// deliver convert the event to onebot message event, the text is sent as text segment, so cq codes in text
+// of other platforms are not parsed
+func (d *AdapterDriver) deliver(event Event) {
+	<-d.listening
+
+	d.mu.Lock()
+	selfID, userID, channelID := d.selfID, d.idOf(event.UserID), d.idOf(event.ChannelID)
+	if event.Private && event.ChannelID != "" {
+		d.privates[userID] = event.ChannelID
+	}
+	d.messageSeq++
+	messageID, handler := d.messageSeq, d.handler
+	d.mu.Unlock()
+
+	segments := message.Message{message.Text(event.Text)}
+	if event.ToMe && !event.Private {
+		segments = append(message.Message{message.At(selfID)}, segments...)
+	}
+	role := event.Role
+	if role == "" {
+		role = "member"
+	}
+
+	payload := map[string]any{
+		"time":         time.Now().Unix(),
+		"self_id":      selfID,
+		"post_type":    "message",
+		"message_type": "group",
+		"sub_type":     "normal",
+		"message_id":   messageID,
+		"group_id":     channelID,
+		"user_id":      userID,
+		"message":      segments,
+		"raw_message":  event.Text,
+		"font":         0,
+		"sender":       map[string]any{"user_id": userID, "nickname": event.Nickname, "role": role},
+	}
+	if event.Private {
+		payload["message_type"], payload["sub_type"] = "private", "friend"
+		delete(payload, "group_id")
+	}
+
+	encoded, _ := json.Marshal(payload)
+	handler(encoded, d)
+}
+
+// targetOf resolve the platform target of send action params
+func (d *AdapterDriver) targetOf(params zero.Params) (Target, error) {
+	groupID, _ := toInt64(params["group_id"])
+	userID, _ := toInt64(params["user_id"])
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case groupID != 0 && params["message_type"] != "private":
+		return Target{ChannelID: d.platformID(groupID)}, nil
+	case userID != 0:
+		return Target{ChannelID: d.privates[userID], UserID: d.platformID(userID), Private: true}, nil
+	default:
+		return Target{}, fmt.Errorf("group_id or user_id is required")
+	}
+}
+
+// idOf map platform id to onebot id and remember it for sending, the caller holds the lock
+func (d *AdapterDriver) idOf(platformID string) int64 {
+	id := numericID(d.Platform, platformID)
+	if _, known := d.ids[id]; id != 0 && !known {
+		d.ids[id] = platformID
+		if saveErr := adapterIDsStorage().Set(d.idKey(id), []byte(platformID)); saveErr != nil {
+			if log := core.Logger(); log != nil {
+				log.Error(logger.NewFields().WithMessage("failed to save platform id").WithData(map[string]any{"platform": d.Platform, "error": saveErr.Error()}))
+			}
+		}
+	}
+
+	return id
+}
+
+// platformID map onebot id back to platform id, ids below hashed range are taken as platform ids,
+// the caller holds the lock
+func (d *AdapterDriver) platformID(id int64) string {
+	if platformID, exist := d.ids[id]; exist {
+		return platformID
+	}
+	if stored, exist := adapterIDsStorage().Get(d.idKey(id)); exist {
+		d.ids[id] = string(stored)
+		return string(stored)
+	}
+
+	return strconv.FormatInt(id, 10)
+}
+
+func (d *AdapterDriver) idKey(id int64) string {
+	return d.Platform + "/" + strconv.FormatInt(id, 10)
+}
+
+// mention render at segment by the adapter, qq is the onebot id of user or all
+func (d *AdapterDriver) mention(qq string) string {
+	id, parseErr := strconv.ParseInt(qq, 10, 64)
+	if parseErr != nil {
+		return "@" + qq
+	}
+
+	d.mu.Lock()
+	userID := d.platformID(id)
+	d.mu.Unlock()
+	if mentioner, ok := d.adapter.(Mentioner); ok {
+		return mentioner.Mention(userID)
+	}
+
+	return "@" + userID
+}
+
+// outgoingOf convert message param, which is a cq string, a segment or segments, to outgoing message,
+// at segments are rendered by mention
+func outgoingOf(param any, mention func(qq string) string) Outgoing {
+	encoded, _ := json.Marshal(param)
+	if parsed := gjson.ParseBytes(encoded); parsed.IsObject() {
+		encoded = []byte("[" + parsed.Raw + "]")
+	}
+
+	outgoing, text := Outgoing{}, strings.Builder{}
+	for _, segment := range message.ParseMessage(encoded) {
+		switch segment.Type {
+		case "text":
+			text.WriteString(segment.Data["text"])
+		case "at":
+			text.WriteString(mention(segment.Data["qq"]) + " ")
+		case "image":
+			outgoing.Images = append(outgoing.Images, segment.Data["file"])
+		}
+	}
+	outgoing.Text = text.String()
+
+	return outgoing
+}
+
+// numericID convert platform id to onebot id, ids are hashed with platform into the range from hashedIDBase,
+// so they do not collide with ids of other platforms or onebot accounts such as super users
+func numericID(platform, platformID string) int64 {
+	if platformID == "" {
+		return 0
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(platform + ":" + platformID))
+	return int64(hash.Sum64()>>2) | hashedIDBase
+}
+
+func adapterIDsStorage() core.Storage {
+	adapterIDsOnce.Do(func() {
+		storage, openErr := core.NewFileStorage(filepath.Join("data", "adapter", "ids.storage.db"))
+		if openErr != nil {
+			if log := core.Logger(); log != nil {
+				log.Error(logger.NewFields().WithMessage("failed to open platform ids storage, fallback to memory").WithData(openErr.Error()))
+			}
+			storage = core.NewMemoryStorage()
+		}
+		adapterIDsStore = storage
+	})
+
+	return adapterIDsStore
+}
+
+func okResponse(data any) zero.APIResponse {
+	encoded, _ := json.Marshal(data)
+	return zero.APIResponse{Status: "ok", Data: gjson.ParseBytes(encoded)}
+}
+
+func failedResponse(err error) zero.APIResponse {
+	return zero.APIResponse{Status: "failed", RetCode: 100, Msg: err.Error(), Data: gjson.Parse("null")}
+}
+
+func toInt64(value any) (int64, bool) {
+	switch number := value.(type) {
+	case int64:
+		return number, true
+	case int:
+		return int64(number), true
+	case float64:
+		return int64(number), true
+	case json.Number:
+		parsed, parseErr := number.Int64()
+		return parsed, parseErr == nil
+	}
+
+	return 0, false
+}
+
+// bindAdapters append drivers of adapters in config to zero config
+func bindAdapters(ctx context.Context, coreConfig *core.Config) {
+	for _, config := range coreConfig.Adapters {
+		adaptersMu.Lock()
+		factory, exist := adapters[config.Platform]
+		adaptersMu.Unlock()
+		if !exist {
+			panic("unknown adapter platform: " + config.Platform)
+		}
+
+		adapter, createErr := factory(config)
+		if createErr != nil {
+			panic("invalid adapter " + config.Platform + ": " + createErr.Error())
+		}
+
+		coreConfig.ZeroConfig.Driver = append(coreConfig.ZeroConfig.Driver, NewAdapterDriver(config.Platform, adapter))
+		core.Logger().Infof(logger.NewFields(ctx), "adapter %s bound", config.Platform)
+	}
+}
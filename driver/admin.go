@@ -0,0 +1,385 @@
+package driver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FloatTech/zbputils/control"
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/exit"
+	"github.com/alioth-center/infrastructure/logger"
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+type (
+	adminResponse struct {
+		Data  any    `json:"data,omitempty"`
+		Error string `json:"error,omitempty"`
+	}
+
+	adminPlugin struct {
+		Name        string    `json:"name"`
+		Description string    `json:"description,omitempty"`
+		Priority    int       `json:"priority"`
+		Enable      bool      `json:"enable"`
+		Bound       bool      `json:"bound"`
+		EnabledIn   *bool     `json:"enabled_in,omitempty"`
+		Handlers    []binding `json:"handlers,omitempty"`
+	}
+
+	// adminLimiter is the limiter of handler, tokens is omitted for limiters not declared in config,
+	// whose state cannot be read without creating it
+	adminLimiter struct {
+		Plugin  string   `json:"plugin"`
+		Handler string   `json:"handler"`
+		Limiter string   `json:"limiter"`
+		Tokens  *float64 `json:"tokens,omitempty"`
+	}
+
+	adminToggleRequest struct {
+		GroupID int64 `json:"group_id"`
+	}
+
+	adminMessageRequest struct {
+		SelfID  int64  `json:"self_id"`
+		GroupID int64  `json:"group_id"`
+		UserID  int64  `json:"user_id"`
+		Message string `json:"message"`
+	}
+)
+
+type adminServer struct {
+	ctx context.Context
+}
+
+func serveAdmin(ctx context.Context, coreConfig *core.Config) {
+	if !coreConfig.Admin.Enable {
+		return
+	}
+
+	if coreConfig.Admin.Token == "" {
+		// refuse to expose management api without authentication
+		core.Logger().Info(logger.NewFields(ctx).WithMessage("admin api token is empty, admin api disabled"))
+		return
+	}
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", coreConfig.Admin.Host, coreConfig.Admin.Port),
+		Handler:           adminHandler(ctx, coreConfig.Admin.Token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if serveErr := server.ListenAndServe(); serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			core.Logger().Error(logger.NewFields(ctx).WithMessage("admin api stopped unexpectedly").WithData(serveErr.Error()))
+		}
+	}()
+	core.Logger().Infof(logger.NewFields(ctx), "admin api listening on %s", server.Addr)
+
+	// register exit event
+	exit.Register(func(_ string) string {
+		_ = server.Shutdown(context.Background())
+		return "admin api exit"
+	}, "admin api exit")
+}
+
+// adminHandler route admin api requests authenticated by the token
+func adminHandler(ctx context.Context, token string) http.Handler {
+	admin := &adminServer{ctx: ctx}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/plugins", admin.listPlugins)
+	mux.HandleFunc("GET /api/plugins/{name}", admin.getPlugin)
+	mux.HandleFunc("POST /api/plugins/{name}/enable", admin.togglePlugin(true))
+	mux.HandleFunc("POST /api/plugins/{name}/disable", admin.togglePlugin(false))
+	mux.HandleFunc("GET /api/limiters", admin.listLimiters)
+	mux.HandleFunc("GET /api/health", admin.health)
+	mux.HandleFunc("GET /api/metrics", admin.metrics)
+	mux.HandleFunc("POST /api/reload", admin.reload)
+	mux.HandleFunc("POST /api/messages", admin.sendMessage)
+	mux.HandleFunc("POST /api/broadcast", admin.broadcast)
+
+	return authenticate(token, mux)
+}
+
+// authenticate check the bearer token of every admin request
+func authenticate(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeAdmin(w, http.StatusUnauthorized, nil, errors.New("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *adminServer) listPlugins(w http.ResponseWriter, r *http.Request) {
+	groupID, parseErr := queryInt(r, "group_id")
+	if parseErr != nil {
+		writeAdmin(w, http.StatusBadRequest, nil, parseErr)
+		return
+	}
+
+	plugins := core.Plugins()
+	result := make([]adminPlugin, 0, len(plugins))
+	for _, plugin := range plugins {
+		result = append(result, a.describePlugin(plugin, groupID))
+	}
+
+	writeAdmin(w, http.StatusOK, result, nil)
+}
+
+func (a *adminServer) getPlugin(w http.ResponseWriter, r *http.Request) {
+	groupID, parseErr := queryInt(r, "group_id")
+	if parseErr != nil {
+		writeAdmin(w, http.StatusBadRequest, nil, parseErr)
+		return
+	}
+
+	for _, plugin := range core.Plugins() {
+		if plugin.Name == r.PathValue("name") {
+			writeAdmin(w, http.StatusOK, a.describePlugin(plugin, groupID), nil)
+			return
+		}
+	}
+
+	writeAdmin(w, http.StatusNotFound, nil, errors.New("plugin not found"))
+}
+
+// togglePlugin enable or disable a bound plugin, group id 0 operates globally,
+// negative group id is a private chat of user, which is the convention of zbpctrl
+func (a *adminServer) togglePlugin(enable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := adminToggleRequest{}
+		if r.ContentLength != 0 {
+			if decodeErr := json.NewDecoder(r.Body).Decode(&request); decodeErr != nil {
+				writeAdmin(w, http.StatusBadRequest, nil, decodeErr)
+				return
+			}
+		}
+
+		manager, bound := control.Lookup(r.PathValue("name"))
+		if !bound {
+			writeAdmin(w, http.StatusNotFound, nil, errors.New("plugin not bound"))
+			return
+		}
+
+		if enable {
+			manager.Enable(request.GroupID)
+		} else {
+			manager.Disable(request.GroupID)
+		}
+
+		core.Logger().Info(logger.NewFields(a.ctx).WithMessage("plugin toggled by admin api").WithData(map[string]any{"plugin": manager.Service, "group": request.GroupID, "enable": enable}))
+		writeAdmin(w, http.StatusOK, map[string]any{"plugin": manager.Service, "group_id": request.GroupID, "enable": enable}, nil)
+	}
+}
+
+// listLimiters show the tokens left of each handler limiter for given user and group, only limiters declared
+// in config are peeked, other limiters create their state on lookup, so their tokens are not shown
+func (a *adminServer) listLimiters(w http.ResponseWriter, r *http.Request) {
+	userID, parseUserErr := queryInt(r, "user_id")
+	groupID, parseGroupErr := queryInt(r, "group_id")
+	if parseErr := errors.Join(parseUserErr, parseGroupErr); parseErr != nil {
+		writeAdmin(w, http.StatusBadRequest, nil, parseErr)
+		return
+	}
+
+	result := make([]adminLimiter, 0, len(bindings))
+	for _, bound := range bindings {
+		if bound.limit == nil {
+			continue
+		}
+
+		limiter := adminLimiter{Plugin: bound.Plugin, Handler: bound.Handler, Limiter: bound.Limiter}
+		if tokens, declared := core.LimiterRemaining(bound.Limiter, userID, groupID); declared {
+			limiter.Tokens = &tokens
+		}
+
+		result = append(result, limiter)
+	}
+
+	writeAdmin(w, http.StatusOK, result, nil)
+}
+
+// health show the connection status of bots, the status is 503 if no bot is connected
+func (a *adminServer) health(w http.ResponseWriter, _ *http.Request) {
+	status := core.Health()
+	writeAdmin(w, shortcut.Ternary(status.Connected > 0, http.StatusOK, http.StatusServiceUnavailable), status, nil)
+}
+
+// metrics write the outbound queue metrics in prometheus text format, so it can be scraped with the admin token
+func (a *adminServer) metrics(w http.ResponseWriter, _ *http.Request) {
+	metrics := core.Metrics()
+
+	sb := strings.Builder{}
+	sb.WriteString("# HELP ceobebot_queue_depth Messages waiting in the outbound queue.\n# TYPE ceobebot_queue_depth gauge\n")
+	for _, lane := range []string{"high", "normal", "low"} {
+		fmt.Fprintf(&sb, "ceobebot_queue_depth{priority=%q} %d\n", lane, metrics.Depth[lane])
+	}
+	for _, counter := range []struct {
+		name, help string
+		value      uint64
+	}{
+		{"sent", "Messages sent by the outbound queue.", metrics.Sent},
+		{"retried", "Failed sends retried by the outbound queue.", metrics.Retried},
+		{"failed", "Messages given up after retries.", metrics.Failed},
+		{"dropped", "Messages dropped because the outbound queue was full.", metrics.Dropped},
+	} {
+		fmt.Fprintf(&sb, "# HELP ceobebot_queue_%s_total %s\n# TYPE ceobebot_queue_%s_total counter\nceobebot_queue_%s_total %d\n", counter.name, counter.help, counter.name, counter.name, counter.value)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(sb.String()))
+}
+
+// reload re-read config files, plugins disabled or re-enabled in config will be toggled globally,
+// group lists and default enablement are applied again
+func (a *adminServer) reload(w http.ResponseWriter, _ *http.Request) {
+	previous := map[string]core.PluginConfig{}
+	for _, plugin := range core.Plugins() {
+		previous[plugin.Name] = plugin
+	}
+
+	if _, reloadErr := core.Reload(a.ctx); reloadErr != nil {
+		writeAdmin(w, http.StatusInternalServerError, nil, reloadErr)
+		return
+	}
+
+	reloaded := core.Plugins()
+	for _, plugin := range reloaded {
+		manager, bound := control.Lookup(plugin.Name)
+		if !bound {
+			continue
+		}
+
+		applyGroups(a.ctx, previous[plugin.Name], plugin)
+		if previous[plugin.Name].Enable == plugin.Enable {
+			continue
+		}
+
+		if plugin.Enable {
+			manager.Enable(0)
+		} else {
+			manager.Disable(0)
+		}
+	}
+
+	writeAdmin(w, http.StatusOK, map[string]any{"plugins": len(reloaded)}, nil)
+}
+
+// sendMessage send a test message to group, or to user when group id is not set
+func (a *adminServer) sendMessage(w http.ResponseWriter, r *http.Request) {
+	request := adminMessageRequest{}
+	if decodeErr := json.NewDecoder(r.Body).Decode(&request); decodeErr != nil {
+		writeAdmin(w, http.StatusBadRequest, nil, decodeErr)
+		return
+	}
+	if request.Message == "" || (request.GroupID == 0 && request.UserID == 0) {
+		writeAdmin(w, http.StatusBadRequest, nil, errors.New("message and group_id or user_id are required"))
+		return
+	}
+
+	bot := findBot(request.SelfID)
+	if bot == nil {
+		writeAdmin(w, http.StatusServiceUnavailable, nil, errors.New("bot not connected"))
+		return
+	}
+
+	var messageID int64
+	if request.GroupID != 0 {
+		messageID = bot.SendGroupMessage(request.GroupID, message.ParseMessageFromString(request.Message))
+	} else {
+		messageID = bot.SendPrivateMessage(request.UserID, message.ParseMessageFromString(request.Message))
+	}
+
+	writeAdmin(w, http.StatusOK, map[string]any{"message_id": messageID}, nil)
+}
+
+// broadcast send an announcement to groups and respond the report after every delivery finished
+func (a *adminServer) broadcast(w http.ResponseWriter, r *http.Request) {
+	request := BroadcastRequest{}
+	if decodeErr := json.NewDecoder(r.Body).Decode(&request); decodeErr != nil {
+		writeAdmin(w, http.StatusBadRequest, nil, decodeErr)
+		return
+	}
+
+	report, broadcastErr := Broadcast(request)
+	if broadcastErr != nil {
+		writeAdmin(w, shortcut.Ternary(errors.Is(broadcastErr, errBotNotConnected), http.StatusServiceUnavailable, http.StatusBadRequest), nil, broadcastErr)
+		return
+	}
+
+	core.Logger().Info(logger.NewFields(a.ctx).WithMessage("broadcast by admin api").WithData(map[string]any{"targets": len(report.Targets), "failed": len(report.Failed), "dry_run": report.DryRun}))
+	writeAdmin(w, http.StatusOK, report, nil)
+}
+
+func (a *adminServer) describePlugin(plugin core.PluginConfig, groupID int64) adminPlugin {
+	described := adminPlugin{
+		Name:        plugin.Name,
+		Description: plugin.Description,
+		Priority:    plugin.Priority,
+		Enable:      plugin.Enable,
+		Handlers:    []binding{},
+	}
+
+	if manager, bound := control.Lookup(plugin.Name); bound {
+		enabled := manager.IsEnabledIn(groupID)
+		described.Bound, described.EnabledIn = true, &enabled
+	}
+	for _, bound := range bindings {
+		if bound.Plugin == plugin.Name {
+			described.Handlers = append(described.Handlers, bound)
+		}
+	}
+
+	return described
+}
+
+// findBot get bot by self id, if self id is 0, return any connected bot
+func findBot(selfID int64) (bot *zero.Ctx) {
+	if selfID != 0 {
+		return zero.GetBot(selfID)
+	}
+
+	zero.RangeBot(func(_ int64, ctx *zero.Ctx) bool {
+		bot = ctx
+		return false
+	})
+
+	return bot
+}
+
+func queryInt(r *http.Request, key string) (int64, error) {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+
+	value, parseErr := strconv.ParseInt(raw, 10, 64)
+	if parseErr != nil {
+		return 0, fmt.Errorf("invalid %s: %s", key, raw)
+	}
+
+	return value, nil
+}
+
+func writeAdmin(w http.ResponseWriter, status int, data any, err error) {
+	response := adminResponse{Data: data}
+	if err != nil {
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const blocklistHandlerName = "blocklist"
+
+// blocklistArgs is the arguments of blocklist command, such as: blocklist add @user --for 1h or blocklist add --group 123
+type blocklistArgs struct {
+	Action   string        `arg:"action,pos,required" enum:"add|remove|list"`
+	User     int64         `arg:"user,pos,mention"`
+	Group    int64         `arg:"group"`
+	Duration time.Duration `arg:"for"`
+}
+
+// blocklistHandler add, remove or list blocked users and groups, only bot owners can use it
+func blocklistHandler(ctx *zero.Ctx, args blocklistArgs) {
+	if !zero.SuperUserPermission(ctx) {
+		ctx.Send(message.Text("permission denied: only bot owners can manage blocklist"))
+		return
+	}
+
+	if args.Action == "list" {
+		lines := []string{}
+		for _, entry := range core.Blocks() {
+			line := fmt.Sprintf("%s %d", entry.Kind, entry.ID)
+			switch {
+			case entry.Seeded:
+				line += " (config)"
+			case entry.ExpiresIn > 0:
+				line += " (expires in " + entry.ExpiresIn.Round(time.Second).String() + ")"
+			}
+			lines = append(lines, line)
+		}
+		ctx.Send(message.Text(shortcut.Ternary(len(lines) == 0, "blocklist is empty", strings.Join(lines, "\n"))))
+		return
+	}
+
+	kind, id := core.BlockUser, args.User
+	if args.Group != 0 {
+		kind, id = core.BlockGroup, args.Group
+	}
+	if id == 0 {
+		ctx.Send(message.Text("missing argument: user or --group"))
+		return
+	}
+
+	var manageErr error
+	if args.Action == "add" {
+		manageErr = core.Block(kind, id, args.Duration)
+	} else {
+		manageErr = core.Unblock(kind, id)
+	}
+	if manageErr != nil {
+		ctx.Send(message.Text(manageErr.Error()))
+		return
+	}
+
+	ctx.Send(message.Text(fmt.Sprintf("%s %s %d", shortcut.Ternary(args.Action == "add", "blocked", "unblocked"), kind, id)))
+}
@@ -0,0 +1,236 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/FloatTech/zbputils/control"
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const (
+	broadcastHandlerName = "broadcast"
+	// broadcastWindow is the most deliveries of a broadcast waiting in outbound queue at once, so large
+	// broadcasts never fill the queue shared with replies of handlers
+	broadcastWindow = 20
+)
+
+var (
+	// errBotNotConnected is returned when no bot can send the broadcast
+	errBotNotConnected = errors.New("bot not connected")
+	// errSelfIDRequired is returned when self id is not set and the bot to send the broadcast is ambiguous
+	errSelfIDRequired = errors.New("self_id is required when more than one bot is connected")
+)
+
+type (
+	// BroadcastRequest is an announcement sent to groups, it targets the listed groups, or every group of bot
+	// when groups are empty, and only groups where the plugin is enabled when plugin is set,
+	// message is cq code, self id 0 means the only connected onebot bot
+	BroadcastRequest struct {
+		SelfID  int64   `json:"self_id"`
+		Groups  []int64 `json:"groups,omitempty"`
+		Plugin  string  `json:"plugin,omitempty"`
+		Message string  `json:"message"`
+		DryRun  bool    `json:"dry_run,omitempty"`
+	}
+
+	// BroadcastReport is the result of broadcast, nothing is sent in dry run, so only targets are reported
+	BroadcastReport struct {
+		SelfID    int64              `json:"self_id"`
+		DryRun    bool               `json:"dry_run,omitempty"`
+		Targets   []int64            `json:"targets"`
+		Succeeded []int64            `json:"succeeded"`
+		Failed    []BroadcastFailure `json:"failed"`
+	}
+
+	// delivery is a message waiting in outbound queue, such as *core.SendResult
+	delivery interface {
+		Wait() (int64, error)
+	}
+
+	// BroadcastFailure is a delivery failed after retries of outbound queue
+	BroadcastFailure struct {
+		GroupID int64  `json:"group_id"`
+		Error   string `json:"error"`
+	}
+)
+
+// broadcastArgs is the arguments of broadcast command, such as: broadcast --plugin weather --dry-run hello everyone
+type broadcastArgs struct {
+	Message string `arg:"message,pos,required"`
+	Groups  string `arg:"groups"`
+	Plugin  string `arg:"plugin"`
+	DryRun  bool   `arg:"dry-run"`
+}
+
+// Broadcast send the message to target groups through the outbound queue with low priority, so replies of
+// handlers are not delayed by it, targets are enqueued progressively with at most broadcastWindow waiting,
+// it blocks until every delivery is sent or failed after retries
+func Broadcast(request BroadcastRequest) (BroadcastReport, error) {
+	if strings.TrimSpace(request.Message) == "" {
+		return BroadcastReport{}, errors.New("message is required")
+	}
+
+	selfID, targets, resolveErr := broadcastTargets(request)
+	if resolveErr != nil {
+		return BroadcastReport{}, resolveErr
+	}
+
+	report := BroadcastReport{SelfID: selfID, DryRun: request.DryRun, Targets: targets, Succeeded: []int64{}, Failed: []BroadcastFailure{}}
+	if request.DryRun {
+		return report, nil
+	}
+
+	enqueue := func(i int) delivery {
+		return core.Enqueue(core.OutboundMessage{SelfID: selfID, GroupID: targets[i], Message: message.ParseMessageFromString(request.Message), Priority: core.PriorityLow})
+	}
+	deliverInWindow(len(targets), min(broadcastWindow, core.QueueSize()), enqueue, func(i int, sendErr error) {
+		if sendErr != nil {
+			report.Failed = append(report.Failed, BroadcastFailure{GroupID: targets[i], Error: sendErr.Error()})
+		} else {
+			report.Succeeded = append(report.Succeeded, targets[i])
+		}
+	})
+
+	return report, nil
+}
+
+// deliverInWindow enqueue count deliveries in order, the next one is enqueued only after the oldest finished
+// when window deliveries are waiting, finished is called in order of deliveries
+func deliverInWindow(count, window int, enqueue func(i int) delivery, finished func(i int, err error)) {
+	window = max(window, 1)
+	results := make([]delivery, count)
+	for i := 0; i < count; i++ {
+		if i >= window {
+			_, sendErr := results[i-window].Wait()
+			finished(i-window, sendErr)
+		}
+		results[i] = enqueue(i)
+	}
+	for i := max(count-window, 0); i < count; i++ {
+		_, sendErr := results[i].Wait()
+		finished(i, sendErr)
+	}
+}
+
+// broadcastTargets resolve the bot and target groups of request, groups are sorted and deduplicated
+func broadcastTargets(request BroadcastRequest) (int64, []int64, error) {
+	selfID := request.SelfID
+	if selfID == 0 {
+		// bots of adapters cannot list groups, so only onebot bots are picked
+		onebots := []int64{}
+		zero.APICallers.Range(func(id int64, caller zero.APICaller) bool {
+			if _, adapted := caller.(*AdapterDriver); !adapted {
+				onebots = append(onebots, id)
+			}
+			return true
+		})
+		if len(onebots) > 1 {
+			return 0, nil, errSelfIDRequired
+		}
+		if len(onebots) == 1 {
+			selfID = onebots[0]
+		}
+	}
+	caller, connected := zero.APICallers.Load(selfID)
+	if !connected {
+		return 0, nil, errBotNotConnected
+	}
+
+	enabled := func(int64) bool { return true }
+	if request.Plugin != "" {
+		manager, bound := control.Lookup(request.Plugin)
+		if !bound {
+			return 0, nil, fmt.Errorf("plugin not found: %s", request.Plugin)
+		}
+		enabled = manager.IsEnabledIn
+	}
+
+	groups := slices.Clone(request.Groups)
+	if len(groups) == 0 {
+		response, callErr := caller.CallApi(zero.APIRequest{Action: "get_group_list", Params: zero.Params{}})
+		if callErr == nil && response.Status != "ok" {
+			callErr = fmt.Errorf("get_group_list failed: %d %s", response.RetCode, response.Msg)
+		}
+		if callErr != nil {
+			return 0, nil, callErr
+		}
+
+		for _, group := range response.Data.Array() {
+			groups = append(groups, group.Get("group_id").Int())
+		}
+	}
+
+	targets := []int64{}
+	for _, groupID := range groups {
+		if groupID > 0 && enabled(groupID) {
+			targets = append(targets, groupID)
+		}
+	}
+	slices.Sort(targets)
+
+	return selfID, slices.Compact(targets), nil
+}
+
+// broadcastHandler send an announcement to groups, only bot owners can use it, the report is replied in
+// background after every delivery finished, groups is a comma separated list of group ids
+func broadcastHandler(ctx *zero.Ctx, args broadcastArgs) {
+	if !zero.SuperUserPermission(ctx) {
+		ctx.Send(message.Text("permission denied: only bot owners can broadcast"))
+		return
+	}
+
+	request := BroadcastRequest{SelfID: ctx.Event.SelfID, Plugin: args.Plugin, Message: args.Message, DryRun: args.DryRun}
+	for _, raw := range strings.FieldsFunc(args.Groups, func(r rune) bool { return r == ',' }) {
+		groupID, parseErr := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if parseErr != nil {
+			ctx.Send(message.Text("invalid group id: " + raw))
+			return
+		}
+		request.Groups = append(request.Groups, groupID)
+	}
+
+	if !request.DryRun {
+		ctx.Send(message.Text("broadcasting, the report will be sent when finished"))
+	}
+
+	// broadcast waits for every delivery, so it runs aside instead of holding the handler
+	go func() {
+		report, broadcastErr := Broadcast(request)
+		if broadcastErr != nil {
+			ctx.Send(message.Text(broadcastErr.Error()))
+			return
+		}
+
+		ctx.Send(message.Text(report.String()))
+	}()
+}
+
+// String get readable summary of report
+func (r BroadcastReport) String() string {
+	if r.DryRun {
+		return fmt.Sprintf("dry run, %d groups targeted: %s", len(r.Targets), joinIDs(r.Targets))
+	}
+
+	lines := []string{fmt.Sprintf("broadcast finished, %d succeeded, %d failed", len(r.Succeeded), len(r.Failed))}
+	for _, failure := range r.Failed {
+		lines = append(lines, fmt.Sprintf("group %d: %s", failure.GroupID, failure.Error))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func joinIDs(ids []int64) string {
+	formatted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		formatted = append(formatted, strconv.FormatInt(id, 10))
+	}
+
+	return shortcut.Ternary(len(formatted) == 0, "none", strings.Join(formatted, ", "))
+}
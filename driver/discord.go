@@ -0,0 +1,301 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RomiChan/websocket"
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/logger"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultDiscordAPI     = "https://discord.com/api/v10"
+	defaultDiscordGateway = "wss://gateway.discord.gg/?v=10&encoding=json"
+
+	// guild messages, direct messages and message content
+	discordIntents = 1<<9 | 1<<12 | 1<<15
+)
+
+func init() {
+	RegisterAdapter("discord", func(config core.AdapterConfig) (Adapter, error) {
+		return NewDiscordAdapter(config)
+	})
+}
+
+// DiscordAdapter is an adapter of discord bot, messages are received from gateway and sent by rest api,
+// guild text channels are channels, and direct messages are private
+type DiscordAdapter struct {
+	API     string
+	Gateway string
+	Token   string
+
+	client  *http.Client
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+	seq     *int64
+	selfID  string
+}
+
+// NewDiscordAdapter create a discord adapter, api and gateway override the official endpoints
+func NewDiscordAdapter(config core.AdapterConfig) (*DiscordAdapter, error) {
+	if config.Token == "" {
+		return nil, errors.New("token is required by discord adapter")
+	}
+
+	api, gateway := config.API, config.Gateway
+	if api == "" {
+		api = defaultDiscordAPI
+	}
+	if gateway == "" {
+		gateway = defaultDiscordGateway
+	}
+
+	return &DiscordAdapter{
+		API:     strings.TrimSuffix(api, "/"),
+		Gateway: gateway,
+		Token:   config.Token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Start connect to gateway and wait for ready, the session is identified again when the connection breaks
+func (d *DiscordAdapter) Start(ctx context.Context, deliver func(Event)) (string, error) {
+	if connectErr := d.connect(ctx); connectErr != nil {
+		return "", connectErr
+	}
+
+	go d.listen(ctx, deliver)
+	return d.selfID, nil
+}
+
+// Send create a message in channel, images are sent as embeds, direct message channel is opened for
+// private targets without channel
+func (d *DiscordAdapter) Send(ctx context.Context, target Target, outgoing Outgoing) (string, error) {
+	channelID := target.ChannelID
+	if channelID == "" {
+		channel, openErr := d.call(ctx, http.MethodPost, "/users/@me/channels", map[string]any{"recipient_id": target.UserID})
+		if openErr != nil {
+			return "", openErr
+		}
+		channelID = channel.Get("id").Str
+	}
+
+	params := map[string]any{"content": outgoing.Text}
+	if len(outgoing.Images) > 0 {
+		embeds := make([]map[string]any, 0, len(outgoing.Images))
+		for _, image := range outgoing.Images {
+			embeds = append(embeds, map[string]any{"image": map[string]any{"url": image}})
+		}
+		params["embeds"] = embeds
+	}
+
+	sent, sendErr := d.call(ctx, http.MethodPost, "/channels/"+channelID+"/messages", params)
+	if sendErr != nil {
+		return "", sendErr
+	}
+
+	return sent.Get("id").Str, nil
+}
+
+// Mention mention user by id
+func (d *DiscordAdapter) Mention(userID string) string {
+	return "<@" + userID + ">"
+}
+
+// connect open the gateway connection, identify the bot and start heartbeating, it returns after ready
+func (d *DiscordAdapter) connect(ctx context.Context) error {
+	conn, response, dialErr := websocket.DefaultDialer.DialContext(ctx, d.Gateway, nil)
+	if dialErr != nil {
+		return fmt.Errorf("failed to connect discord gateway: %w", dialErr)
+	}
+	_ = response.Body.Close()
+
+	_, hello, readErr := conn.ReadMessage()
+	if readErr != nil || gjson.GetBytes(hello, "op").Int() != 10 {
+		_ = conn.Close()
+		return fmt.Errorf("invalid hello of discord gateway: %v", readErr)
+	}
+
+	d.mu.Lock()
+	d.conn, d.seq = conn, nil
+	d.mu.Unlock()
+
+	identify := map[string]any{"token": d.Token, "intents": discordIntents, "properties": map[string]any{"os": "linux", "browser": "ceobebot", "device": "ceobebot"}}
+	if writeErr := d.write(conn, 2, identify); writeErr != nil {
+		_ = conn.Close()
+		return writeErr
+	}
+
+	for {
+		_, payload, readErr := conn.ReadMessage()
+		if readErr != nil {
+			_ = conn.Close()
+			return fmt.Errorf("discord gateway closed before ready: %w", readErr)
+		}
+
+		result := gjson.ParseBytes(payload)
+		if result.Get("op").Int() == 0 && result.Get("t").Str == "READY" {
+			d.mu.Lock()
+			d.selfID = result.Get("d.user.id").Str
+			d.mu.Unlock()
+			d.updateSeq(result)
+			break
+		}
+	}
+
+	interval := time.Duration(gjson.GetBytes(hello, "d.heartbeat_interval").Int()) * time.Millisecond
+	go d.heartbeat(ctx, conn, interval)
+	return nil
+}
+
+// listen read dispatches from gateway and deliver messages, it reconnects until ctx is done
+func (d *DiscordAdapter) listen(ctx context.Context, deliver func(Event)) {
+	for ctx.Err() == nil {
+		d.mu.Lock()
+		conn := d.conn
+		d.mu.Unlock()
+
+		_, payload, readErr := conn.ReadMessage()
+		if readErr != nil {
+			if log := core.Logger(); log != nil {
+				log.Warn(logger.NewFields(ctx).WithMessage("discord gateway disconnected").WithData(readErr.Error()))
+			}
+			for ctx.Err() == nil {
+				time.Sleep(time.Second)
+				if connectErr := d.connect(ctx); connectErr == nil {
+					break
+				}
+			}
+			continue
+		}
+
+		result := gjson.ParseBytes(payload)
+		d.updateSeq(result)
+		if result.Get("op").Int() != 0 || result.Get("t").Str != "MESSAGE_CREATE" {
+			continue
+		}
+		if event, ok := d.eventOf(result.Get("d")); ok {
+			deliver(event)
+		}
+	}
+}
+
+// eventOf convert message of dispatch to event, messages mentioning bot are to me and the mention is removed
+func (d *DiscordAdapter) eventOf(msg gjson.Result) (Event, bool) {
+	if msg.Get("author.bot").Bool() || msg.Get("content").Str == "" {
+		return Event{}, false
+	}
+
+	d.mu.Lock()
+	selfID := d.selfID
+	d.mu.Unlock()
+
+	text, toMe := msg.Get("content").Str, false
+	for _, mention := range msg.Get("mentions.#.id").Array() {
+		if mention.Str == selfID {
+			toMe = true
+		}
+	}
+	for _, mention := range []string{"<@" + selfID + ">", "<@!" + selfID + ">"} {
+		text = strings.ReplaceAll(text, mention, "")
+	}
+
+	nickname := msg.Get("member.nick").Str
+	if nickname == "" {
+		nickname = msg.Get("author.username").Str
+	}
+
+	return Event{
+		ChannelID: msg.Get("channel_id").Str,
+		UserID:    msg.Get("author.id").Str,
+		Nickname:  nickname,
+		Text:      strings.TrimSpace(text),
+		Private:   !msg.Get("guild_id").Exists(),
+		ToMe:      toMe,
+	}, true
+}
+
+// heartbeat send heartbeats with the last sequence until the connection is replaced
+func (d *DiscordAdapter) heartbeat(ctx context.Context, conn *websocket.Conn, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			current, seq := d.conn, d.seq
+			d.mu.Unlock()
+			if current != conn {
+				return
+			}
+
+			_ = d.write(conn, 1, seq)
+		}
+	}
+}
+
+func (d *DiscordAdapter) updateSeq(result gjson.Result) {
+	if !result.Get("s").Exists() || result.Get("s").Type == gjson.Null {
+		return
+	}
+
+	seq := result.Get("s").Int()
+	d.mu.Lock()
+	d.seq = &seq
+	d.mu.Unlock()
+}
+
+func (d *DiscordAdapter) write(conn *websocket.Conn, op int, data any) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+
+	return conn.WriteJSON(map[string]any{"op": op, "d": data})
+}
+
+// call send request to rest api, it returns the response body
+func (d *DiscordAdapter) call(ctx context.Context, method, path string, params map[string]any) (gjson.Result, error) {
+	encoded, encodeErr := json.Marshal(params)
+	if encodeErr != nil {
+		return gjson.Result{}, encodeErr
+	}
+
+	request, buildErr := http.NewRequestWithContext(ctx, method, d.API+path, bytes.NewReader(encoded))
+	if buildErr != nil {
+		return gjson.Result{}, buildErr
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bot "+d.Token)
+
+	response, callErr := d.client.Do(request)
+	if callErr != nil {
+		return gjson.Result{}, callErr
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	body, readErr := io.ReadAll(response.Body)
+	if readErr != nil {
+		return gjson.Result{}, readErr
+	}
+	if response.StatusCode/100 != 2 {
+		return gjson.Result{}, fmt.Errorf("discord %s %s failed: %d %s", method, path, response.StatusCode, gjson.GetBytes(body, "message").Str)
+	}
+
+	return gjson.ParseBytes(body), nil
+}
@@ -0,0 +1,127 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/FloatTech/zbputils/control"
+	"github.com/FloatTech/zbputils/img/text"
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/logger"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const helpHandlerName = "help"
+
+// helpHandler list plugins enabled in current group with their handlers and triggers,
+// if the command has an argument, only show the plugin with the given name
+func helpHandler(coreConfig *core.Config) func(*zero.Ctx) {
+	return func(ctx *zero.Ctx) {
+		// private chat is stored as negative user id in zbpctrl
+		groupID := ctx.Event.GroupID
+		if groupID == 0 {
+			groupID = -ctx.Event.UserID
+		}
+		target, _ := ctx.State["args"].(string)
+		target = strings.TrimSpace(target)
+
+		menu := strings.Builder{}
+		for _, plugin := range core.Plugins() {
+			manager, bound := control.Lookup(plugin.Name)
+			if !plugin.Enable || !bound || !manager.IsEnabledIn(groupID) {
+				// skip plugin not available in current group
+				continue
+			}
+			if target != "" && target != plugin.Name {
+				continue
+			}
+
+			writePluginHelp(&menu, plugin, target != "")
+		}
+
+		if menu.Len() == 0 {
+			ctx.Send(message.Text(fmt.Sprintf("no plugin available: %s", target)))
+			return
+		}
+
+		content := strings.TrimSpace(menu.String())
+		if !coreConfig.Bot.HelpAsImage {
+			ctx.Send(message.Text(content))
+			return
+		}
+
+		// render as image card, fallback to text when font or rendering is not available
+		card, renderErr := text.RenderToBase64(content, text.FontFile, 640, 24)
+		if renderErr != nil {
+			core.Logger().Info(logger.NewFields().WithMessage("failed to render help card, fallback to text").WithData(renderErr.Error()))
+			ctx.Send(message.Text(content))
+			return
+		}
+
+		ctx.Send(message.Image("base64://" + string(card)))
+	}
+}
+
+func writePluginHelp(menu *strings.Builder, plugin core.PluginConfig, detailed bool) {
+	menu.WriteString("[" + plugin.Name + "]")
+	if plugin.Description != "" {
+		menu.WriteString(" " + plugin.Description)
+	}
+	menu.WriteString("\n")
+	if detailed && plugin.Help != "" {
+		menu.WriteString(plugin.Help + "\n")
+	}
+
+	for _, bound := range bindings {
+		if bound.Plugin != plugin.Name {
+			continue
+		}
+
+		menu.WriteString("  - " + strings.Join(describeTriggers(bound.Triggers), " | "))
+		if bound.Description != "" {
+			menu.WriteString(": " + bound.Description)
+		}
+		menu.WriteString("\n")
+	}
+}
+
+// describeTriggers convert triggers to human-readable words, commands are shown with trigger prefix
+func describeTriggers(triggers core.TriggerConfig) (words []string) {
+	words = append(words, triggers.FullMatches...)
+	for _, command := range triggers.Commands {
+		words = append(words, zero.BotConfig.CommandPrefix+command)
+	}
+	for _, keyword := range triggers.KeyWords {
+		words = append(words, "*"+keyword+"*")
+	}
+	for _, prefix := range triggers.Prefixes {
+		words = append(words, prefix+"...")
+	}
+	for _, suffix := range triggers.Suffixes {
+		words = append(words, "..."+suffix)
+	}
+	for _, regex := range triggers.Regexes {
+		words = append(words, "/"+regex+"/")
+	}
+	if triggers.Notice {
+		words = append(words, "<notice>")
+	}
+	for _, notice := range triggers.Notices {
+		words = append(words, "<notice:"+notice+">")
+	}
+	for _, request := range triggers.Requests {
+		words = append(words, "<request:"+request+">")
+	}
+	for _, meta := range triggers.Metas {
+		words = append(words, "<meta:"+meta+">")
+	}
+	for _, segment := range triggers.Segments {
+		words = append(words, "<"+segment+">")
+	}
+	if triggers.Fuzzy != nil {
+		words = append(words, triggers.Fuzzy.Aliases...)
+	}
+
+	return words
+}
@@ -0,0 +1,62 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/utils/shortcut"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+const roleHandlerName = "role"
+
+// roleArgs is the arguments of role command, such as: role grant moderator @user --global
+type roleArgs struct {
+	Action string `arg:"action,pos,required" enum:"grant|revoke|list"`
+	Role   string `arg:"role,pos"`
+	User   int64  `arg:"user,pos,mention"`
+	Global bool   `arg:"global"`
+}
+
+// roleHandler grant, revoke or list roles, only bot owners can grant and revoke,
+// roles are granted in current group unless global flag is given or in private chat
+func roleHandler(ctx *zero.Ctx, args roleArgs) {
+	gid := ctx.Event.GroupID
+	if args.Global {
+		gid = 0
+	}
+	if args.User == 0 {
+		args.User = ctx.Event.UserID
+	}
+
+	if args.Action == "list" {
+		roles := []string{}
+		for _, grant := range core.Grants(args.User, gid) {
+			roles = append(roles, grant.Role+shortcut.Ternary(grant.GroupID == 0, "(global)", ""))
+		}
+		ctx.Send(message.Text(fmt.Sprintf("roles of %d: %s", args.User, strings.Join(roles, ", "))))
+		return
+	}
+
+	if !zero.SuperUserPermission(ctx) {
+		ctx.Send(message.Text("permission denied: only bot owners can manage roles"))
+		return
+	}
+	if args.Role == "" {
+		ctx.Send(message.Text("missing argument: role"))
+		return
+	}
+
+	manage := core.Grant
+	if args.Action == "revoke" {
+		manage = core.Revoke
+	}
+	if manageErr := manage(args.Role, args.User, gid); manageErr != nil {
+		ctx.Send(message.Text(manageErr.Error()))
+		return
+	}
+
+	ctx.Send(message.Text(fmt.Sprintf("%s role %s of %d %s", args.Action, args.Role, args.User, shortcut.Ternary(gid == 0, "globally", fmt.Sprintf("in group %d", gid)))))
+}
@@ -0,0 +1,203 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/alioth-center/infrastructure/logger"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultTelegramAPI = "https://api.telegram.org"
+	telegramPollWait   = 30
+)
+
+func init() {
+	RegisterAdapter("telegram", func(config core.AdapterConfig) (Adapter, error) {
+		return NewTelegramAdapter(config)
+	})
+}
+
+// TelegramAdapter is an adapter of telegram bot api, updates are received by long polling,
+// chats are channels, and private chats have the same id as users
+type TelegramAdapter struct {
+	API   string
+	Token string
+
+	client   *http.Client
+	username string
+	selfID   string
+	mu       sync.Mutex
+	names    map[string]string
+}
+
+// NewTelegramAdapter create a telegram adapter, api overrides the official bot api endpoint
+func NewTelegramAdapter(config core.AdapterConfig) (*TelegramAdapter, error) {
+	if config.Token == "" {
+		return nil, errors.New("token is required by telegram adapter")
+	}
+
+	api := config.API
+	if api == "" {
+		api = defaultTelegramAPI
+	}
+
+	return &TelegramAdapter{
+		API:    strings.TrimSuffix(api, "/"),
+		Token:  config.Token,
+		client: &http.Client{Timeout: (telegramPollWait + 10) * time.Second},
+		names:  map[string]string{},
+	}, nil
+}
+
+// Start get the account of bot and poll updates until ctx is done
+func (t *TelegramAdapter) Start(ctx context.Context, deliver func(Event)) (string, error) {
+	me, callErr := t.call(ctx, "getMe", map[string]any{})
+	if callErr != nil {
+		return "", callErr
+	}
+	t.selfID, t.username = me.Get("id").Raw, me.Get("username").Str
+
+	go t.poll(ctx, deliver)
+	return t.selfID, nil
+}
+
+// Send send text by sendMessage, images are sent by sendPhoto with text as caption of the first one
+func (t *TelegramAdapter) Send(ctx context.Context, target Target, outgoing Outgoing) (string, error) {
+	chatID := target.ChannelID
+	if chatID == "" {
+		chatID = target.UserID
+	}
+
+	var sent gjson.Result
+	if len(outgoing.Images) == 0 {
+		result, callErr := t.call(ctx, "sendMessage", map[string]any{"chat_id": chatID, "text": outgoing.Text})
+		if callErr != nil {
+			return "", callErr
+		}
+		sent = result
+	}
+	for i, image := range outgoing.Images {
+		params := map[string]any{"chat_id": chatID, "photo": image}
+		if i == 0 && outgoing.Text != "" {
+			params["caption"] = outgoing.Text
+		}
+
+		result, callErr := t.call(ctx, "sendPhoto", params)
+		if callErr != nil {
+			return "", callErr
+		}
+		sent = result
+	}
+
+	return sent.Get("message_id").Raw, nil
+}
+
+// Mention mention user by username, users without username seen are mentioned by name, which is not notified
+func (t *TelegramAdapter) Mention(userID string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if name, exist := t.names[userID]; exist {
+		return name
+	}
+
+	return "@" + userID
+}
+
+// poll receive updates by getUpdates, failed polls are retried after a while
+func (t *TelegramAdapter) poll(ctx context.Context, deliver func(Event)) {
+	offset := int64(0)
+	for ctx.Err() == nil {
+		updates, callErr := t.call(ctx, "getUpdates", map[string]any{"offset": offset, "timeout": telegramPollWait, "allowed_updates": []string{"message"}})
+		if callErr != nil {
+			if log := core.Logger(); log != nil {
+				log.Warn(logger.NewFields(ctx).WithMessage("failed to get telegram updates").WithData(callErr.Error()))
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, update := range updates.Array() {
+			offset = max(offset, update.Get("update_id").Int()+1)
+			if event, ok := t.eventOf(update.Get("message")); ok {
+				deliver(event)
+			}
+		}
+	}
+}
+
+// eventOf convert message of update to event, messages mentioning or replying to bot are to me,
+// and the mention is removed from text
+func (t *TelegramAdapter) eventOf(msg gjson.Result) (Event, bool) {
+	if !msg.Exists() || msg.Get("from.is_bot").Bool() || msg.Get("text").Str == "" {
+		return Event{}, false
+	}
+
+	text, mention := msg.Get("text").Str, "@"+t.username
+	toMe := msg.Get("reply_to_message.from.id").Raw == t.selfID
+	if t.username != "" && strings.Contains(text, mention) {
+		text, toMe = strings.TrimSpace(strings.ReplaceAll(text, mention, "")), true
+	}
+
+	nickname, name := msg.Get("from.username").Str, "@"+msg.Get("from.username").Str
+	if nickname == "" {
+		nickname = strings.TrimSpace(msg.Get("from.first_name").Str + " " + msg.Get("from.last_name").Str)
+		name = nickname
+	}
+	t.mu.Lock()
+	t.names[msg.Get("from.id").Raw] = name
+	t.mu.Unlock()
+
+	return Event{
+		ChannelID: msg.Get("chat.id").Raw,
+		UserID:    msg.Get("from.id").Raw,
+		Nickname:  nickname,
+		Text:      text,
+		Private:   msg.Get("chat.type").Str == "private",
+		ToMe:      toMe,
+	}, true
+}
+
+// call post params to the method of bot api, it returns the result field of response
+func (t *TelegramAdapter) call(ctx context.Context, method string, params map[string]any) (gjson.Result, error) {
+	encoded, encodeErr := json.Marshal(params)
+	if encodeErr != nil {
+		return gjson.Result{}, encodeErr
+	}
+
+	request, buildErr := http.NewRequestWithContext(ctx, http.MethodPost, t.API+"/bot"+t.Token+"/"+method, bytes.NewReader(encoded))
+	if buildErr != nil {
+		return gjson.Result{}, buildErr
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, callErr := t.client.Do(request)
+	if callErr != nil {
+		// the url contains token, so only the method is reported
+		return gjson.Result{}, fmt.Errorf("telegram %s failed: %w", method, errors.Unwrap(callErr))
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	body, readErr := io.ReadAll(response.Body)
+	if readErr != nil {
+		return gjson.Result{}, readErr
+	}
+
+	result := gjson.ParseBytes(body)
+	if !result.Get("ok").Bool() {
+		return gjson.Result{}, fmt.Errorf("telegram %s failed: %d %s", method, response.StatusCode, result.Get("description").Str)
+	}
+
+	return result.Get("result"), nil
+}
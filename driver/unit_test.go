@@ -0,0 +1,490 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ctrl "github.com/FloatTech/zbpctrl"
+	"github.com/FloatTech/zbputils/control"
+	"github.com/RomiChan/websocket"
+	"github.com/alioth-center/ceobebot-core/core"
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+func TestMain(m *testing.M) {
+	// core is global, initialize it before adapters start logging
+	if _, _, _, initErr := core.InitializeWithConfig([]byte("bot:\n  logger: console\nplugins:\n  - name: admin-test\n    enable: true\n")); initErr != nil {
+		panic(initErr)
+	}
+
+	os.Exit(m.Run())
+}
+
+// echoHandler reply the text of message events as a single segment, as ctx.Send does
+func echoHandler(t *testing.T) func([]byte, zero.APICaller) {
+	return func(event []byte, caller zero.APICaller) {
+		result := gjson.ParseBytes(event)
+		params := zero.Params{"message_type": result.Get("message_type").Str, "user_id": result.Get("user_id").Int(), "message": message.Text("echo: " + result.Get("raw_message").Str)}
+		if result.Get("group_id").Exists() {
+			params["group_id"] = result.Get("group_id").Int()
+		}
+
+		if response, callErr := caller.CallApi(zero.APIRequest{Action: "send_msg", Params: params}); callErr != nil || response.Status != "ok" {
+			t.Errorf("Expected send_msg succeeded, but got %v %s", callErr, response.Msg)
+		}
+	}
+}
+
+func receive[T any](t *testing.T, received chan T) T {
+	t.Helper()
+
+	select {
+	case value := <-received:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected value received, but timed out")
+		return *new(T)
+	}
+}
+
+func TestTelegramAdapter(t *testing.T) {
+	updates, sent := make(chan string, 4), make(chan gjson.Result, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/botTOKEN/getMe":
+			_, _ = io.WriteString(w, `{"ok":true,"result":{"id":42,"is_bot":true,"username":"ceobe_bot"}}`)
+		case "/botTOKEN/getUpdates":
+			select {
+			case update := <-updates:
+				_, _ = io.WriteString(w, `{"ok":true,"result":[`+update+`]}`)
+			case <-time.After(100 * time.Millisecond):
+				_, _ = io.WriteString(w, `{"ok":true,"result":[]}`)
+			}
+		case "/botTOKEN/sendMessage":
+			sent <- gjson.ParseBytes(body)
+			_, _ = io.WriteString(w, `{"ok":true,"result":{"message_id":7}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"ok":false,"description":"Not Found"}`)
+		}
+	}))
+	defer server.Close()
+
+	adapter, createErr := NewTelegramAdapter(core.AdapterConfig{Platform: "telegram", Token: "TOKEN", API: server.URL})
+	if createErr != nil {
+		t.Fatalf("Expected adapter created, but got %v", createErr)
+	}
+	driver := NewAdapterDriver("telegram", adapter)
+	driver.Connect()
+	driver.Listen(echoHandler(t))
+
+	t.Run("LoginInfo", func(t *testing.T) {
+		response, _ := driver.CallApi(zero.APIRequest{Action: "get_login_info"})
+		if response.Data.Get("user_id").Int() != numericID("telegram", "42") {
+			t.Errorf("Expected self id 42, but got %s", response.Data.Raw)
+		}
+	})
+
+	t.Run("GroupMention", func(t *testing.T) {
+		updates <- `{"update_id":1,"message":{"message_id":1,"from":{"id":5,"username":"alice"},"chat":{"id":-100123,"type":"supergroup"},"text":"@ceobe_bot ping"}}`
+
+		reply := receive(t, sent)
+		if reply.Get("chat_id").Str != "-100123" || reply.Get("text").Str != "echo: ping" {
+			t.Errorf("Expected echo to chat -100123, but got %s", reply.Raw)
+		}
+	})
+
+	t.Run("PrivateMessage", func(t *testing.T) {
+		updates <- `{"update_id":2,"message":{"message_id":2,"from":{"id":5,"first_name":"Alice"},"chat":{"id":5,"type":"private"},"text":"hi"}}`
+
+		reply := receive(t, sent)
+		if reply.Get("chat_id").Str != "5" || reply.Get("text").Str != "echo: hi" {
+			t.Errorf("Expected echo to private chat 5, but got %s", reply.Raw)
+		}
+	})
+
+	t.Run("NamespacedID", func(t *testing.T) {
+		received := make(chan int64, 1)
+		driver.Listen(func(event []byte, _ zero.APICaller) { received <- gjson.GetBytes(event, "user_id").Int() })
+		defer driver.Listen(echoHandler(t))
+
+		// telegram user having the same number as the super user must not be taken as the super user
+		superUsers := zero.BotConfig.SuperUsers
+		zero.BotConfig.SuperUsers = []int64{1145141919}
+		defer func() { zero.BotConfig.SuperUsers = superUsers }()
+
+		updates <- `{"update_id":3,"message":{"message_id":3,"from":{"id":1145141919,"username":"mallory"},"chat":{"id":-100123,"type":"supergroup"},"text":"hi"}}`
+		userID := receive(t, received)
+		if zero.SuperUserPermission(&zero.Ctx{Event: &zero.Event{UserID: userID}}) {
+			t.Errorf("Expected telegram user not to be the super user, but got id %d", userID)
+		}
+		if userID == numericID("discord", "1145141919") {
+			t.Errorf("Expected ids of platforms not to collide, but got %d on both", userID)
+		}
+	})
+
+	t.Run("Mention", func(t *testing.T) {
+		response, _ := driver.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": numericID("telegram", "-100123"), "message": message.Message{message.At(numericID("telegram", "1145141919")), message.Text("hi")}}})
+		if response.Status != "ok" {
+			t.Errorf("Expected group message sent, but got %s", response.Msg)
+		}
+		if reply := receive(t, sent); reply.Get("text").Str != "@mallory hi" {
+			t.Errorf("Expected user mentioned by username, but got %s", reply.Raw)
+		}
+	})
+
+	t.Run("PersistedID", func(t *testing.T) {
+		// a new driver has not seen the chat, it is resolved from the persisted ids as restarting
+		restarted := NewAdapterDriver("telegram", adapter)
+		response, _ := restarted.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": numericID("telegram", "-100123"), "message": "hello"}})
+		if response.Status != "ok" {
+			t.Errorf("Expected group message sent, but got %s", response.Msg)
+		}
+		if reply := receive(t, sent); reply.Get("chat_id").Str != "-100123" {
+			t.Errorf("Expected message sent to persisted chat, but got %s", reply.Raw)
+		}
+	})
+
+	t.Run("UnsupportedAction", func(t *testing.T) {
+		response, _ := driver.CallApi(zero.APIRequest{Action: "set_group_ban"})
+		if response.Status != "failed" {
+			t.Errorf("Expected unsupported action failed, but got %s", response.Status)
+		}
+	})
+}
+
+func TestDiscordAdapter(t *testing.T) {
+	dispatches, sent := make(chan string, 4), make(chan [2]string, 4)
+	mu, identified := sync.Mutex{}, gjson.Result{}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/gateway":
+			conn, upgradeErr := upgrader.Upgrade(w, r, nil)
+			if upgradeErr != nil {
+				return
+			}
+			defer func() { _ = conn.Close() }()
+
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"op":10,"d":{"heartbeat_interval":45000}}`))
+			_, identify, _ := conn.ReadMessage()
+			mu.Lock()
+			identified = gjson.ParseBytes(identify)
+			mu.Unlock()
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"op":0,"t":"READY","s":1,"d":{"user":{"id":"900","username":"ceobe"}}}`))
+			for dispatch := range dispatches {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(dispatch))
+			}
+		case r.Header.Get("Authorization") != "Bot TOKEN":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/api/users/@me/channels":
+			_, _ = io.WriteString(w, `{"id":"dm-`+gjson.GetBytes(body, "recipient_id").Str+`"}`)
+		case strings.HasPrefix(r.URL.Path, "/api/channels/"):
+			sent <- [2]string{strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/channels/"), "/messages"), gjson.GetBytes(body, "content").Str}
+			_, _ = io.WriteString(w, `{"id":"1000"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer close(dispatches)
+
+	adapter, createErr := NewDiscordAdapter(core.AdapterConfig{Platform: "discord", Token: "TOKEN", API: server.URL + "/api", Gateway: "ws" + strings.TrimPrefix(server.URL, "http") + "/gateway"})
+	if createErr != nil {
+		t.Fatalf("Expected adapter created, but got %v", createErr)
+	}
+	driver := NewAdapterDriver("discord", adapter)
+	driver.Connect()
+	driver.Listen(echoHandler(t))
+
+	t.Run("Identify", func(t *testing.T) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if identified.Get("op").Int() != 2 || identified.Get("d.token").Str != "TOKEN" {
+			t.Errorf("Expected identify with token, but got %s", identified.Raw)
+		}
+		if _, exist := zero.APICallers.Load(numericID("discord", "900")); !exist {
+			t.Error("Expected bot 900 registered as api caller, but not found")
+		}
+	})
+
+	t.Run("GuildMention", func(t *testing.T) {
+		dispatches <- `{"op":0,"t":"MESSAGE_CREATE","s":2,"d":{"id":"1","channel_id":"300","guild_id":"200","author":{"id":"5","username":"alice"},"content":"<@900> ping","mentions":[{"id":"900"}]}}`
+
+		if reply := receive(t, sent); reply != [2]string{"300", "echo: ping"} {
+			t.Errorf("Expected echo to channel 300, but got %v", reply)
+		}
+	})
+
+	t.Run("BotMessageIgnored", func(t *testing.T) {
+		dispatches <- `{"op":0,"t":"MESSAGE_CREATE","s":3,"d":{"id":"2","channel_id":"300","guild_id":"200","author":{"id":"6","bot":true},"content":"ping"}}`
+		dispatches <- `{"op":0,"t":"MESSAGE_CREATE","s":4,"d":{"id":"3","channel_id":"301","author":{"id":"5","username":"alice"},"content":"hi"}}`
+
+		if reply := receive(t, sent); reply != [2]string{"301", "echo: hi"} {
+			t.Errorf("Expected only direct message echoed, but got %v", reply)
+		}
+	})
+
+	t.Run("Mention", func(t *testing.T) {
+		response, _ := driver.CallApi(zero.APIRequest{Action: "send_group_msg", Params: zero.Params{"group_id": numericID("discord", "300"), "message": "[CQ:at,qq=" + strconv.FormatInt(numericID("discord", "5"), 10) + "]hi"}})
+		if response.Status != "ok" {
+			t.Errorf("Expected group message sent, but got %s", response.Msg)
+		}
+		if reply := receive(t, sent); reply != [2]string{"300", "<@5> hi"} {
+			t.Errorf("Expected user mentioned by discord mention, but got %v", reply)
+		}
+	})
+
+	t.Run("OpenDirectChannel", func(t *testing.T) {
+		response, _ := driver.CallApi(zero.APIRequest{Action: "send_private_msg", Params: zero.Params{"user_id": int64(77), "message": "hello"}})
+		if response.Status != "ok" {
+			t.Errorf("Expected private message sent, but got %s", response.Msg)
+		}
+		if reply := receive(t, sent); reply != [2]string{"dm-77", "hello"} {
+			t.Errorf("Expected message sent to opened direct channel, but got %v", reply)
+		}
+	})
+}
+
+func TestOutgoingOf(t *testing.T) {
+	segments, _ := json.Marshal(message.Message{message.At(5), message.Text("hi"), message.Image("https://example.com/a.png")})
+	raw := json.RawMessage(segments)
+	plain := func(qq string) string { return "@" + qq }
+
+	t.Run("Segments", func(t *testing.T) {
+		outgoing := outgoingOf(raw, plain)
+		if outgoing.Text != "@5 hi" || len(outgoing.Images) != 1 || outgoing.Images[0] != "https://example.com/a.png" {
+			t.Errorf("Expected text and image converted, but got %+v", outgoing)
+		}
+	})
+
+	t.Run("SingleSegment", func(t *testing.T) {
+		if outgoing := outgoingOf(message.Text("hi"), plain); outgoing.Text != "hi" {
+			t.Errorf("Expected single segment converted, but got %+v", outgoing)
+		}
+	})
+
+	t.Run("CQString", func(t *testing.T) {
+		if outgoing := outgoingOf("[CQ:at,qq=5]hi", plain); outgoing.Text != "@5 hi" {
+			t.Errorf("Expected cq string converted, but got %+v", outgoing)
+		}
+	})
+}
+
+// stubCaller is a onebot bot answering every api call with ok
+type stubCaller struct{}
+
+func (stubCaller) CallApi(zero.APIRequest) (zero.APIResponse, error) {
+	return okResponse(nil), nil
+}
+
+func TestBroadcastTargets(t *testing.T) {
+	adapted := NewAdapterDriver("test", nil)
+	zero.APICallers.Store(hashedIDBase+1, adapted)
+	defer zero.APICallers.Delete(hashedIDBase + 1)
+
+	t.Run("AdapterSkipped", func(t *testing.T) {
+		if _, _, resolveErr := broadcastTargets(BroadcastRequest{Groups: []int64{100}}); !errors.Is(resolveErr, errBotNotConnected) {
+			t.Errorf("Expected bot of adapter not picked, but got %v", resolveErr)
+		}
+	})
+
+	t.Run("OnlyOnebot", func(t *testing.T) {
+		zero.APICallers.Store(10000, stubCaller{})
+		defer zero.APICallers.Delete(10000)
+
+		if selfID, _, resolveErr := broadcastTargets(BroadcastRequest{Groups: []int64{100}}); resolveErr != nil || selfID != 10000 {
+			t.Errorf("Expected onebot bot 10000 picked, but got %d, %v", selfID, resolveErr)
+		}
+	})
+
+	t.Run("Ambiguous", func(t *testing.T) {
+		zero.APICallers.Store(10000, stubCaller{})
+		zero.APICallers.Store(20000, stubCaller{})
+		defer zero.APICallers.Delete(10000)
+		defer zero.APICallers.Delete(20000)
+
+		if _, _, resolveErr := broadcastTargets(BroadcastRequest{Groups: []int64{100}}); !errors.Is(resolveErr, errSelfIDRequired) {
+			t.Errorf("Expected self id required, but got %v", resolveErr)
+		}
+		if selfID, _, resolveErr := broadcastTargets(BroadcastRequest{SelfID: 20000, Groups: []int64{100}}); resolveErr != nil || selfID != 20000 {
+			t.Errorf("Expected bot 20000 picked by self id, but got %d, %v", selfID, resolveErr)
+		}
+	})
+}
+
+// fakeDelivery is a delivery finished with err once waited
+type fakeDelivery struct {
+	err      error
+	inFlight *int
+}
+
+func (d fakeDelivery) Wait() (int64, error) {
+	*d.inFlight--
+	return 0, d.err
+}
+
+func TestDeliverInWindow(t *testing.T) {
+	inFlight, most, order := 0, 0, []int{}
+	enqueue := func(i int) delivery {
+		inFlight++
+		most = max(most, inFlight)
+		if i == 3 {
+			return fakeDelivery{err: errors.New("risk controlled"), inFlight: &inFlight}
+		}
+		return fakeDelivery{inFlight: &inFlight}
+	}
+	failed := -1
+	deliverInWindow(5, 2, enqueue, func(i int, err error) {
+		order = append(order, i)
+		if err != nil {
+			failed = i
+		}
+	})
+
+	if most != 2 {
+		t.Errorf("Expected at most 2 deliveries waiting, but got %d", most)
+	}
+	if !slices.Equal(order, []int{0, 1, 2, 3, 4}) || failed != 3 {
+		t.Errorf("Expected every delivery finished in order with 3 failed, but got %v and %d", order, failed)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	control.Register("admin-test", &ctrl.Options[*zero.Ctx]{})
+	manager, _ := control.Lookup("admin-test")
+	// settings of control are persisted in data folder, start from default
+	_ = manager.Manager.D.Del(manager.Service, "WHERE gid=0")
+	manager.Cache = map[int64]uint8{}
+
+	server := httptest.NewServer(adminHandler(context.Background(), "TOKEN"))
+	defer server.Close()
+
+	call := func(method, path, token, body string) (int, gjson.Result) {
+		request, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, requestErr := http.DefaultClient.Do(request)
+		if requestErr != nil {
+			t.Fatalf("Expected admin api reachable, but got %v", requestErr)
+		}
+		defer response.Body.Close()
+
+		content, _ := io.ReadAll(response.Body)
+		return response.StatusCode, gjson.ParseBytes(content)
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		if status, _ := call(http.MethodGet, "/api/plugins", "WRONG", ""); status != http.StatusUnauthorized {
+			t.Errorf("Expected wrong token rejected, but got %d", status)
+		}
+	})
+
+	t.Run("ListPlugins", func(t *testing.T) {
+		status, result := call(http.MethodGet, "/api/plugins?group_id=100", "TOKEN", "")
+		if status != http.StatusOK || result.Get("data.#").Int() != 1 || result.Get("data.0.name").Str != "admin-test" || !result.Get("data.0.bound").Bool() {
+			t.Errorf("Expected bound plugin listed, but got %d %s", status, result.Raw)
+		}
+		if status, _ = call(http.MethodGet, "/api/plugins/missing", "TOKEN", ""); status != http.StatusNotFound {
+			t.Errorf("Expected unknown plugin not found, but got %d", status)
+		}
+	})
+
+	t.Run("Toggle", func(t *testing.T) {
+		status, _ := call(http.MethodPost, "/api/plugins/admin-test/disable", "TOKEN", `{"group_id":100}`)
+		if status != http.StatusOK || manager.IsEnabledIn(100) {
+			t.Errorf("Expected plugin disabled in group, but got %d", status)
+		}
+		if _, result := call(http.MethodGet, "/api/plugins/admin-test?group_id=100", "TOKEN", ""); result.Get("data.enabled_in").Bool() {
+			t.Errorf("Expected plugin shown disabled in group, but got %s", result.Raw)
+		}
+
+		status, _ = call(http.MethodPost, "/api/plugins/admin-test/enable", "TOKEN", `{"group_id":100}`)
+		if status != http.StatusOK || !manager.IsEnabledIn(100) {
+			t.Errorf("Expected plugin enabled in group, but got %d", status)
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		_ = os.MkdirAll("config", os.ModePerm)
+		defer func() { _ = os.RemoveAll("config") }()
+
+		_ = os.WriteFile(filepath.Join("config", "bot.yaml"), []byte("plugins:\n  - name: admin-test\n    enable: false\n"), os.ModePerm)
+		status, result := call(http.MethodPost, "/api/reload", "TOKEN", "")
+		if status != http.StatusOK || result.Get("data.plugins").Int() != 1 {
+			t.Errorf("Expected config reloaded, but got %d %s", status, result.Raw)
+		}
+		if manager.IsEnabledIn(200) {
+			t.Errorf("Expected plugin disabled in config disabled globally, but it was not")
+		}
+		if _, result = call(http.MethodGet, "/api/plugins/admin-test", "TOKEN", ""); result.Get("data.enable").Bool() {
+			t.Errorf("Expected reloaded config listed, but got %s", result.Raw)
+		}
+
+		_ = os.WriteFile(filepath.Join("config", "bot.yaml"), []byte("plugins:\n  - name: admin-test\n    enable: true\n  - name: admin-test\n    enable: true\n"), os.ModePerm)
+		if status, _ = call(http.MethodPost, "/api/reload", "TOKEN", ""); status != http.StatusInternalServerError {
+			t.Errorf("Expected invalid config rejected, but got %d", status)
+		}
+	})
+}
+
+func TestApplyGroups(t *testing.T) {
+	control.Register("groups-test", &ctrl.Options[*zero.Ctx]{})
+	manager, _ := control.Lookup("groups-test")
+	// settings of control and applied groups are persisted in data folder, start from nothing applied
+	_ = manager.Manager.D.Del(manager.Service, "WHERE gid<>0")
+	manager.Cache = map[int64]uint8{}
+	_ = appliedGroupsStorage().Delete("groups-test")
+
+	disabled := false
+	config := core.PluginConfig{Name: "groups-test", Groups: core.GroupsConfig{Allow: []int64{1}, Deny: []int64{2}}}
+
+	t.Run("Start", func(t *testing.T) {
+		applyGroups(context.Background(), lastAppliedGroups("groups-test"), config)
+		if !manager.IsEnabledIn(1) || manager.IsEnabledIn(2) || !manager.IsEnabledIn(3) {
+			t.Errorf("Expected group lists applied, but it was not")
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		// changed at runtime by control commands
+		manager.Disable(1)
+		manager.Enable(2)
+
+		applyGroups(context.Background(), lastAppliedGroups("groups-test"), config)
+		if manager.IsEnabledIn(1) || !manager.IsEnabledIn(2) {
+			t.Errorf("Expected runtime settings kept when config not changed, but they were overwritten")
+		}
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		reloaded := core.PluginConfig{Name: "groups-test", DefaultEnabled: &disabled, Groups: core.GroupsConfig{Allow: []int64{1, 4}}}
+		applyGroups(context.Background(), config, reloaded)
+
+		if manager.IsEnabledIn(1) {
+			t.Errorf("Expected runtime setting of unchanged group kept, but it was overwritten")
+		}
+		if !manager.IsEnabledIn(4) || manager.IsEnabledIn(2) || manager.IsEnabledIn(3) {
+			t.Errorf("Expected added group enabled, removed group reset and default disabled, but it was not")
+		}
+		if applied := lastAppliedGroups("groups-test"); applied.DefaultEnabled == nil || len(applied.Groups.Allow) != 2 {
+			t.Errorf("Expected applied groups saved, but got %v", applied)
+		}
+	})
+}
@@ -2,6 +2,12 @@ package driver
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	ctrl "github.com/FloatTech/zbpctrl"
@@ -13,9 +19,63 @@ import (
 	"github.com/alioth-center/infrastructure/utils/values"
 	zero "github.com/wdvxdr1123/ZeroBot"
 	"github.com/wdvxdr1123/ZeroBot/extension/rate"
+	"github.com/wdvxdr1123/ZeroBot/message"
+)
+
+// binding is a handler bound to the control engine, recorded for runtime inspection
+type binding struct {
+	Plugin      string             `json:"plugin"`
+	Handler     string             `json:"handler"`
+	Description string             `json:"description,omitempty"`
+	Blocked     bool               `json:"blocked,omitempty"`
+	Limiter     string             `json:"limiter,omitempty"`
+	Rules       []string           `json:"rules,omitempty"`
+	Triggers    core.TriggerConfig `json:"triggers"`
+	limit       func(*zero.Ctx) *rate.Limiter
+}
+
+// metaChatID is the chat meta events are checked in, no group or user has this id
+const metaChatID = math.MinInt64
+
+var (
+	// bindings are written while initializing and read only after components locked
+	bindings []binding
+
+	appliedGroupsOnce  sync.Once
+	appliedGroupsStore core.Storage
 )
 
 func InitializeZeroBot(ctx context.Context, coreConfig *core.Config, pluginConfigMap map[string]*core.PluginConfig) {
+	// bind plugins and lock components
+	BindZeroBot(ctx, coreConfig, pluginConfigMap)
+
+	// serving admin api if enabled
+	serveAdmin(ctx, coreConfig)
+
+	// bind adapters of other platforms, they run along with onebot connection
+	bindAdapters(ctx, coreConfig)
+
+	// serving bot
+	serve(ctx, coreConfig)
+}
+
+// BindZeroBot initialize plugins and bind their handlers to zero without connecting, components are locked after
+// binding, it is used by InitializeZeroBot and testing harness which drives zero with a fake driver
+func BindZeroBot(ctx context.Context, coreConfig *core.Config, pluginConfigMap map[string]*core.PluginConfig) {
+	// register built-in help, role, blocklist and broadcast handlers, unless they are replaced by user
+	if _, existHelp := core.Components.Handlers().Get(helpHandlerName); !existHelp {
+		core.RegisterHandler(helpHandlerName, helpHandler(coreConfig))
+	}
+	if _, existRole := core.Components.Handlers().Get(roleHandlerName); !existRole {
+		core.RegisterCommandHandler(roleHandlerName, roleHandler)
+	}
+	if _, existBlocklist := core.Components.Handlers().Get(blocklistHandlerName); !existBlocklist {
+		core.RegisterCommandHandler(blocklistHandlerName, blocklistHandler)
+	}
+	if _, existBroadcast := core.Components.Handlers().Get(broadcastHandlerName); !existBroadcast {
+		core.RegisterCommandHandler(broadcastHandlerName, broadcastHandler)
+	}
+
 	// inject hard coded priority
 	filtered := values.FilterArray(coreConfig.Plugins, func(cfg core.PluginConfig) bool { return cfg.Enable && len(cfg.Handlers) > 0 })
 	for _, plugin := range filtered {
@@ -43,8 +103,15 @@ func InitializeZeroBot(ctx context.Context, coreConfig *core.Config, pluginConfi
 			continue
 		}
 
+		// attach plugin storage to init context, plugins always get a storage, data is lost on restart if it fell back
+		storage, openErr := core.OpenStorage(item)
+		if openErr != nil {
+			core.Logger().Error(logger.NewFields(ctx).WithMessage("failed to open plugin storage, fallback to memory").WithData(map[string]any{"plugin": item.Name, "error": openErr.Error()}))
+			storage = core.NewMemoryStorage()
+		}
+
 		pluginBuffer.Init()
-		pluginBuffer.InitCtx(ctx)
+		pluginBuffer.InitCtx(core.ContextWithStorage(ctx, storage))
 		core.Logger().Debug(logger.NewFields(ctx).WithMessage("plugin initialized").WithData(map[string]any{"plugin": item.Name}))
 	}
 
@@ -57,6 +124,18 @@ func InitializeZeroBot(ctx context.Context, coreConfig *core.Config, pluginConfi
 			impl, got := core.Components.Handlers().Get(handler.Name)
 			if got && impl != nil {
 				endpoints[handler.Name] = impl
+				continue
+			}
+
+			// handler declared reply in config, no implementation required
+			if handler.Reply != nil {
+				reply, buildErr := core.NewReplyHandler(item, *handler.Reply)
+				if buildErr != nil {
+					core.Logger().Info(logger.NewFields(ctx).WithMessage("invalid reply handler").WithData(map[string]any{"plugin": item.Name, "handler": handler.Name, "error": buildErr.Error()}))
+					continue
+				}
+
+				endpoints[handler.Name] = reply
 			}
 		}
 		if len(endpoints) == 0 {
@@ -71,79 +150,187 @@ func InitializeZeroBot(ctx context.Context, coreConfig *core.Config, pluginConfi
 			Banner:            item.Banner,
 			PublicDataFolder:  item.ResourceFolder,
 			PrivateDataFolder: item.DataFolder,
+			DisableOnDefault:  item.DefaultEnabled != nil && !*item.DefaultEnabled,
 			OnEnable:          enableCallback(item),
 			OnDisable:         disableCallback(item),
 		})
+		applyGroups(ctx, lastAppliedGroups(item.Name), item)
 
-		// bind middlewares
-		for _, middleware := range item.Middlewares.PreHandlers {
-			impl, got := core.Components.Middlewares().Get(middleware)
-			if got && impl != nil {
-				engine.UsePreHandler(impl)
+		// meta events belong to no chat, the control engine rejects them, so they are bound to a plain engine
+		// checking whether the plugin is enabled for all chats
+		metaEngine := zero.New()
+		metaEngine.UsePreHandler(metaEnabledRule(item.Name))
+
+		// bind middlewares, blocklist is checked before plugin pre handlers
+		for _, bound := range []interface {
+			UsePreHandler(...zero.Rule)
+			UseMidHandler(...zero.Rule)
+		}{engine, metaEngine} {
+			bound.UsePreHandler(core.NotBlocked)
+			for _, middleware := range item.Middlewares.PreHandlers {
+				impl, got := core.Components.Middlewares().Get(middleware)
+				if got && impl != nil {
+					bound.UsePreHandler(impl)
+				}
 			}
-		}
-		for _, middleware := range item.Middlewares.MidHandlers {
-			impl, got := core.Components.Middlewares().Get(middleware)
-			if got && impl != nil {
-				engine.UseMidHandler(impl)
+			for _, middleware := range item.Middlewares.MidHandlers {
+				impl, got := core.Components.Middlewares().Get(middleware)
+				if got && impl != nil {
+					bound.UseMidHandler(impl)
+				}
 			}
 		}
 
 		// register handlers
-		bindHandler(ctx, engine, item, endpoints)
+		bindHandler(ctx, engine, metaEngine, item, endpoints, coreConfig.Bot.TriggerPrefix)
 	}
 
+	// suggest trigger words for messages close to fuzzy triggers
+	bindSuggestion(coreConfig.Bot.TriggerPrefix)
+
+	// report conflicts between bound triggers
+	checkConflicts(ctx, coreConfig)
+
 	// lock components
 	core.Components.Done()
-
-	// serving bot
-	serve(ctx, coreConfig)
 }
 
-func bindHandler(ctx context.Context, engine *control.Engine, plugin core.PluginConfig, endpoints map[string]func(*zero.Ctx)) {
+func bindHandler(ctx context.Context, engine *control.Engine, metaEngine *zero.Engine, plugin core.PluginConfig, endpoints map[string]func(*zero.Ctx), commandPrefix string) {
 	for _, handler := range plugin.Handlers {
 		if endpoints[handler.Name] == nil {
 			core.Logger().Info(logger.NewFields(ctx).WithMessage("handler not found").WithData(handler.Name))
 			continue
 		}
 
+		// handled events are recorded, so the suggestion will not reply to them,
+		// and sessions started by the handler are namespaced by it
+		endpoint := core.MarkHandled(core.WithSessionScope(plugin.Name, handler.Name, endpoints[handler.Name]))
 		extraRules, limiter := findRules(ctx, handler.Rules), findLimiter(ctx, handler.Limiter)
+		extraRules = append([]zero.Rule{core.HandlerEnabledRule(plugin.Name, handler.Name)}, extraRules...)
+		onLimited := core.LimitedNotifier(core.ResolveOnLimited(handler), limiter, core.LimiterInterval(handler.Limiter))
 		if len(handler.Triggers.FullMatches) > 0 {
 			engine.OnFullMatchGroup(handler.Triggers.FullMatches, extraRules...).
-				SetBlock(handler.Blocked).Limit(limiter).Handle(endpoints[handler.Name])
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
 		}
 		if len(handler.Triggers.KeyWords) > 0 {
 			engine.OnKeywordGroup(handler.Triggers.KeyWords, extraRules...).
-				SetBlock(handler.Blocked).Limit(limiter).Handle(endpoints[handler.Name])
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
 		}
 		if len(handler.Triggers.Commands) > 0 {
 			engine.OnCommandGroup(handler.Triggers.Commands, extraRules...).
-				SetBlock(handler.Blocked).Limit(limiter).Handle(endpoints[handler.Name])
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
 		}
 		if len(handler.Triggers.Prefixes) > 0 {
 			engine.OnPrefixGroup(handler.Triggers.Prefixes, extraRules...).
-				SetBlock(handler.Blocked).Limit(limiter).Handle(endpoints[handler.Name])
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
 		}
 		if len(handler.Triggers.Suffixes) > 0 {
 			engine.OnSuffixGroup(handler.Triggers.Suffixes, extraRules...).
-				SetBlock(handler.Blocked).Limit(limiter).Handle(endpoints[handler.Name])
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
 		}
 		if handler.Triggers.Notice {
 			engine.OnNotice(extraRules...).
-				SetBlock(handler.Blocked).Limit(limiter).Handle(endpoints[handler.Name])
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
+		}
+		if len(handler.Triggers.Notices) > 0 {
+			engine.OnNotice(append([]zero.Rule{core.NoticeRule(handler.Triggers.Notices...)}, extraRules...)...).
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
+		}
+		if len(handler.Triggers.Requests) > 0 {
+			engine.OnRequest(append([]zero.Rule{core.RequestRule(handler.Triggers.Requests...)}, extraRules...)...).
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
+		}
+		if len(handler.Triggers.Metas) > 0 {
+			(*control.Matcher)(metaEngine.On("meta_event", append([]zero.Rule{core.MetaRule(handler.Triggers.Metas...)}, extraRules...)...)).
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
+		}
+		if len(handler.Triggers.Segments) > 0 {
+			engine.OnMessage(append([]zero.Rule{core.SegmentRule(handler.Triggers.Segments...)}, extraRules...)...).
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
+		}
+		if handler.Triggers.Fuzzy != nil {
+			engine.OnMessage(append([]zero.Rule{core.FuzzyRule(handler.Triggers, commandPrefix)}, extraRules...)...).
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(endpoint)
 		}
 		for _, regex := range handler.Triggers.Regexes {
 			engine.OnRegex(regex, extraRules...).
-				SetBlock(handler.Blocked).Limit(limiter).Handle(endpoints[handler.Name])
+				SetBlock(handler.Blocked).Limit(limiter, onLimited).Handle(core.WithParams(regex, endpoint))
 		}
 
+		bindings = append(bindings, binding{
+			Plugin:      plugin.Name,
+			Handler:     handler.Name,
+			Description: handler.Description,
+			Blocked:     handler.Blocked,
+			Limiter:     handler.Limiter,
+			Rules:       handler.Rules,
+			Triggers:    handler.Triggers,
+			limit:       limiter,
+		})
 		core.Logger().Debug(logger.NewFields(ctx).WithMessage("handler registered").WithData(map[string]any{"plugin": plugin.Name, "handler": handler.Name, "metadata": plugin.Handlers}))
 	}
 }
 
+// bindSuggestion reply the closest trigger word to messages not handled by any handler, only words of handlers
+// enabled in the chat are suggested, it is bound with the lowest priority so that all other matchers are tried first
+func bindSuggestion(commandPrefix string) {
+	targets := []core.SuggestTarget{}
+	for _, item := range bindings {
+		if item.Triggers.Fuzzy != nil && item.Triggers.Fuzzy.Suggest > 0 {
+			targets = append(targets, core.SuggestTarget{Triggers: item.Triggers, Enabled: suggestEnabledRule(item.Plugin, item.Handler)})
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	zero.OnMessage(core.NotBlocked, core.SuggestRule(targets, commandPrefix)).SetPriority(math.MaxInt32).Handle(func(ctx *zero.Ctx) {
+		ctx.Send(message.Text(fmt.Sprintf("did you mean: %s ?", core.Suggestion(ctx))))
+	})
+}
+
+// suggestEnabledRule pass events of chats where the plugin control and the handler groups allow the handler
+func suggestEnabledRule(plugin, handler string) zero.Rule {
+	handlerEnabled := core.HandlerEnabledRule(plugin, handler)
+	return func(ctx *zero.Ctx) bool {
+		if manager, bound := control.Lookup(plugin); bound && !manager.Handler(ctx.Event.GroupID, ctx.Event.UserID) {
+			return false
+		}
+
+		return handlerEnabled(ctx)
+	}
+}
+
+// checkConflicts log the triggers overlapped between handlers, panic when strict trigger enabled
+func checkConflicts(ctx context.Context, coreConfig *core.Config) {
+	bound := []core.PluginConfig{}
+	for _, item := range bindings {
+		if len(bound) == 0 || bound[len(bound)-1].Name != item.Plugin {
+			bound = append(bound, core.PluginConfig{Name: item.Plugin})
+		}
+
+		last := &bound[len(bound)-1]
+		last.Handlers = append(last.Handlers, core.HandlerConfig{Name: item.Handler, Blocked: item.Blocked, Triggers: item.Triggers})
+	}
+
+	conflicts := core.DetectConflicts(bound, coreConfig.Bot.TriggerPrefix)
+	for _, conflict := range conflicts {
+		core.Logger().Info(logger.NewFields(ctx).WithMessage("trigger conflict detected").WithData(conflict.String()))
+	}
+
+	if coreConfig.Bot.StrictTrigger && len(conflicts) > 0 {
+		panic(fmt.Sprintf("%d trigger conflicts detected, first: %s", len(conflicts), conflicts[0]))
+	}
+}
+
 func findRules(ctx context.Context, rus []string) (result []zero.Rule) {
 	result = []zero.Rule{}
 	for _, rule := range rus {
+		if role, isRoleRule := core.ParseRoleRule(rule); isRoleRule {
+			result = append(result, core.HasRoleRule(role))
+			continue
+		}
+
 		impl, got := core.Components.Rules().Get(rule)
 		if !got || impl == nil {
 			core.Logger().Info(logger.NewFields(ctx).WithMessage("rule not found").WithData(rule))
@@ -178,9 +365,96 @@ func disableCallback(plugin core.PluginConfig) func(_ *zero.Ctx) {
 	}
 }
 
+// metaEnabledRule pass events of the plugin unless it is disabled for all chats, meta events have no chat,
+// so they follow the setting of a chat which never has its own one, which is the setting for all chats or default
+func metaEnabledRule(service string) zero.Rule {
+	return func(*zero.Ctx) bool {
+		manager, bound := control.Lookup(service)
+		return !bound || manager.IsEnabledIn(metaChatID)
+	}
+}
+
+// appliedGroups is the default enablement and group lists applied to control last time
+type appliedGroups struct {
+	DefaultEnabled *bool             `json:"default_enabled,omitempty"`
+	Groups         core.GroupsConfig `json:"groups"`
+}
+
+// lastAppliedGroups get the groups config applied to control of the plugin before restart,
+// empty config is returned when it was never applied
+func lastAppliedGroups(plugin string) core.PluginConfig {
+	applied := appliedGroups{}
+	if stored, exist := appliedGroupsStorage().Get(plugin); exist {
+		_ = json.Unmarshal(stored, &applied)
+	}
+
+	return core.PluginConfig{Name: plugin, DefaultEnabled: applied.DefaultEnabled, Groups: applied.Groups}
+}
+
+// applyGroups apply default enablement and group lists changed since previous config to the control of plugin,
+// groups removed from the lists are reset to default, unchanged values are not applied again,
+// so settings changed at runtime by control commands are kept
+func applyGroups(ctx context.Context, previous, current core.PluginConfig) {
+	manager, bound := control.Lookup(current.Name)
+	if !bound {
+		return
+	}
+
+	// cached states of groups without own setting are computed from default, drop them
+	disableOnDefault := current.DefaultEnabled != nil && !*current.DefaultEnabled
+	if (previous.DefaultEnabled != nil && !*previous.DefaultEnabled) != disableOnDefault {
+		manager.Manager.Lock()
+		manager.Options.DisableOnDefault = disableOnDefault
+		manager.Cache = make(map[int64]uint8, 16)
+		manager.Manager.Unlock()
+	}
+
+	for _, gid := range previous.Groups.Listed() {
+		if gid != 0 && !slices.Contains(current.Groups.Listed(), gid) {
+			manager.Reset(gid)
+		}
+	}
+	for _, gid := range current.Groups.Allow {
+		if !slices.Contains(previous.Groups.Allow, gid) {
+			manager.Enable(gid)
+		}
+	}
+	for _, gid := range current.Groups.Deny {
+		if !slices.Contains(previous.Groups.Deny, gid) {
+			manager.Disable(gid)
+		}
+	}
+
+	encoded, _ := json.Marshal(appliedGroups{DefaultEnabled: current.DefaultEnabled, Groups: current.Groups})
+	if saveErr := appliedGroupsStorage().Set(current.Name, encoded); saveErr != nil {
+		core.Logger().Error(logger.NewFields(ctx).WithMessage("failed to save applied plugin groups").WithData(map[string]any{"plugin": current.Name, "error": saveErr.Error()}))
+	}
+	core.Logger().Debug(logger.NewFields(ctx).WithMessage("plugin groups applied").WithData(map[string]any{"plugin": current.Name, "groups": current.Groups}))
+}
+
+func appliedGroupsStorage() core.Storage {
+	appliedGroupsOnce.Do(func() {
+		storage, openErr := core.NewFileStorage(filepath.Join("data", "control", "groups.storage.db"))
+		if openErr != nil {
+			core.Logger().Error(logger.NewFields().WithMessage("failed to open applied groups storage, fallback to memory").WithData(openErr.Error()))
+			storage = core.NewMemoryStorage()
+		}
+		appliedGroupsStore = storage
+	})
+
+	return appliedGroupsStore
+}
+
 func serve(ctx context.Context, coreConfig *core.Config) {
 	// start bot
-	core.Logger().Infof(logger.NewFields(ctx), "startting bot, connecting to onebot adapter server: %s:%d", coreConfig.Websocket.Host, coreConfig.Websocket.Port)
+	endpoint, _ := coreConfig.Websocket.Endpoint()
+	switch coreConfig.Websocket.Mode {
+	case core.ModeHTTP:
+		endpoint = coreConfig.Websocket.HTTP.API
+	case core.ModeConsole:
+		endpoint = "console"
+	}
+	core.Logger().Infof(logger.NewFields(ctx), "startting bot, connecting to onebot adapter server: %s", endpoint)
 	zero.Run(coreConfig.ZeroConfig)
 	core.Logger().Info(logger.NewFields(ctx).WithMessage("bot started"))
 